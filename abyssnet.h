@@ -87,11 +87,59 @@ extern __declspec(dllexport) void CloseAbyssHandle(uintptr_t handle);
 extern __declspec(dllexport) uintptr_t NewSimplePathResolver();
 extern __declspec(dllexport) void SimplePathResolver_SetMapping(uintptr_t h, char* path_ptr, int path_len, char* world_ID, uintptr_t* err_out);
 extern __declspec(dllexport) int SimplePathResolver_DeleteMapping(uintptr_t h, char* path_ptr, int path_len);
+// serves the directory; "/" is main.aml. no manifest unless AbystServer_SetManifestPath.
+//
 extern __declspec(dllexport) uintptr_t NewSimpleAbystServer(char* path_ptr, int path_len);
+
+// serves a .zip, .tar, .tar.gz or .tgz archive like NewSimpleAbystServer. a zip archive stays open for the process lifetime.
+//
+extern __declspec(dllexport) uintptr_t NewArchiveAbystServer(char* path_ptr, int path_len, uintptr_t* err_out);
+// forwards peer requests to a local HTTP backend, e.g. http://127.0.0.1:8080, with the peer hash in X-Abyss-Peer-Hash.
+// only requests under path_prefix are forwarded, without the prefix; empty for all. h2c: 1 for an unencrypted HTTP/2 backend.
+//
+extern __declspec(dllexport) uintptr_t NewProxyAbystServer(char* backend_ptr, int backend_len, char* path_prefix_ptr, int path_prefix_len, int h2c, uintptr_t* err_out);
+// the cache downloads over the host's abyst client.
+//
+extern __declspec(dllexport) uintptr_t NewAssetCache(uintptr_t h_host, char* dir_ptr, int dir_len, uintptr_t* err_out);
+
+// members share their cached assets through a server from NewSimpleAbystServer or NewArchiveAbystServer.
+//
+extern __declspec(dllexport) int AbystServer_SetAssetCache(uintptr_t h_server, uintptr_t h_cache);
+
+// publishes the manifest of a server from NewSimpleAbystServer or NewArchiveAbystServer at path, e.g. /.abyst/manifest.json.
+// it lists every served file, linked or not. empty path: no manifest.
+//
+extern __declspec(dllexport) int AbystServer_SetManifestPath(uintptr_t h_server, char* path_ptr, int path_len);
+
+// downloads the asset at the abyst URL, if not cached, and writes the local file path to buf.
+// sources_json is a JSON array of member peer hashes that may have the asset cached.
+//
+extern __declspec(dllexport) int AssetCache_Fetch(uintptr_t h, char* addr_ptr, int addr_len, char* sources_json_ptr, int sources_json_len, int timeout_ms, char* buf, int buf_len, uintptr_t* err_out);
+// sets the directory where hosts created afterwards keep known peers, device revocations and the blocklist. an empty path disables it.
+// by default, "abyss/peers" in the user config directory.
+//
+extern __declspec(dllexport) int SetPeerStoreDirectory(char* path_ptr, int path_len);
+// sets the directory where hosts created afterwards by NewHost keep their handshake keys, so that a restart reuses them.
+// the keys are written unencrypted; NewHostFromKeystore keeps them under a passphrase instead. empty (default): disabled.
+//
+extern __declspec(dllexport) int SetIdentityStateDirectory(char* path_ptr, int path_len);
 extern __declspec(dllexport) uintptr_t NewHost(char* root_priv_key_pem_ptr, int root_priv_key_pem_len, uintptr_t h_path_resolver, uintptr_t h_abyst_server);
+// keystore: written by abyss-keytool. the host keeps the stored certificates.
+//
+extern __declspec(dllexport) uintptr_t NewHostFromKeystore(char* keystore_ptr, int keystore_len, char* passphrase_ptr, int passphrase_len, uintptr_t h_path_resolver, uintptr_t h_abyst_server);
 extern __declspec(dllexport) int Host_GetLocalAbyssURL(uintptr_t h, char* buf_ptr, int buf_len);
 extern __declspec(dllexport) int Host_GetCertificates(uintptr_t h, char* root_cert_buf_ptr, int* root_cert_len, char* hs_key_cert_buf_ptr, int* hs_key_cert_len);
 extern __declspec(dllexport) void Host_AppendKnownPeer(uintptr_t h, char* root_cert_buf_ptr, int root_cert_len, char* hs_key_cert_buf_ptr, int hs_key_cert_len, uintptr_t* err_out);
+extern __declspec(dllexport) void Host_LoadBlocklist(uintptr_t h, char* path_ptr, int path_len, uintptr_t* err_out);
+
+// action: 0 block peer, 1 unblock peer, 2 block network, 3 unblock network. target is a peer hash or a CIDR.
+//
+extern __declspec(dllexport) void Host_Block(uintptr_t h, int action, char* target_ptr, int target_len, uintptr_t* err_out);
+extern __declspec(dllexport) int Host_IsPeerBlocked(uintptr_t h, char* peer_hash_ptr, int peer_hash_len);
+
+// leaves all worlds and closes the connections and the socket. the handle must still be closed with CloseAbyssHandle.
+//
+extern __declspec(dllexport) void Host_Shutdown(uintptr_t h, int timeout_ms, uintptr_t* err_out);
 extern __declspec(dllexport) int Host_OpenOutboundConnection(uintptr_t h, char* abyss_url_ptr, int abyss_url_len);
 extern __declspec(dllexport) uintptr_t Host_OpenWorld(uintptr_t h, char* url_ptr, int url_len);
 extern __declspec(dllexport) uintptr_t Host_JoinWorld(uintptr_t h, char* url_ptr, int url_len, int timeout_ms);
@@ -108,15 +156,75 @@ extern __declspec(dllexport) int WorldPeerObjectAppend_GetHead(uintptr_t h, char
 extern __declspec(dllexport) int WorldPeerObjectAppend_GetBody(uintptr_t h, char* buf, int buf_len);
 extern __declspec(dllexport) int WorldPeerObjectDelete_GetHead(uintptr_t h, char* peer_hash_out, int* body_len);
 extern __declspec(dllexport) int WorldPeerObjectDelete_GetBody(uintptr_t h, char* buf, int buf_len);
+extern __declspec(dllexport) int WorldPeerAssetInvalidate_GetHead(uintptr_t h, char* peer_hash_out, int* body_len);
+
+// JSON array of {"Path", "ETag"}. an empty ETag means the asset was removed.
+//
+extern __declspec(dllexport) int WorldPeerAssetInvalidate_GetBody(uintptr_t h, char* buf, int buf_len);
 extern __declspec(dllexport) int WorldPeerLeave_GetHash(uintptr_t h, char* buf, int buf_len);
 extern __declspec(dllexport) int WorldLeave(uintptr_t h);
 extern __declspec(dllexport) uintptr_t Host_GetAbystClientConnection(uintptr_t h, char* peer_hash_ptr, int peer_hash_len, int timeout_ms, uintptr_t* err_out);
+// method: 0 GET, 1 HEAD, 2 POST, 3 PUT, 4 DELETE, 5 PATCH, 6 OPTIONS. no request body; see AbystClient_NewRequest.
+//
 extern __declspec(dllexport) uintptr_t AbystClient_Request(uintptr_t h, int method, char* path_ptr, int path_len, uintptr_t* err_out);
+
+// method is any HTTP method name. headers are added with AbystRequest_AddHeader before AbystRequest_Send.
+//
+extern __declspec(dllexport) uintptr_t AbystClient_NewRequest(uintptr_t h, char* method_ptr, int method_len, char* path_ptr, int path_len, uintptr_t* err_out);
+extern __declspec(dllexport) int AbystRequest_AddHeader(uintptr_t h, char* key_ptr, int key_len, char* value_ptr, int value_len);
+
+// starts the request. content_length: 0 no body, -1 unknown length, otherwise the exact length.
+// with a body, write it with AbystRequest_WriteBody and finish with AbystRequest_CloseBody.
+//
+extern __declspec(dllexport) int AbystRequest_Send(uintptr_t h, long long content_length);
+
+// blocks until the chunk is taken by the stream. returns the written length.
+//
+extern __declspec(dllexport) int AbystRequest_WriteBody(uintptr_t h, char* buf_ptr, int buf_len);
+extern __declspec(dllexport) int AbystRequest_CloseBody(uintptr_t h);
+
+// waits for the response headers. returns 0 without an error on timeout; it may be called again.
+// the response can be taken only once.
+//
+extern __declspec(dllexport) uintptr_t AbystRequest_GetResponse(uintptr_t h, int timeout_ms, uintptr_t* err_out);
 extern __declspec(dllexport) int AbyssResponse_GetHeaders(uintptr_t h, char* buf, int buf_len);
 extern __declspec(dllexport) int AbyssResponse_GetContentLength(uintptr_t h);
 extern __declspec(dllexport) int AbystResponse_ReadBody(uintptr_t h, char* buf_ptr, int buf_len);
 extern __declspec(dllexport) int AbystResponse_ReadBodyAll(uintptr_t h, char* buf_ptr, int buf_len);
 
+// JSON object of the response trailers. complete only after the body is read to EOF.
+//
+extern __declspec(dllexport) int AbystResponse_GetTrailers(uintptr_t h, char* buf, int buf_len);
+
+// serves http://127.0.0.1:<port>/<peer hash>/<path> from peers allowed with AbystGateway_Allow. port 0 picks a free port.
+// requests must be addressed to 127.0.0.1:<port> or localhost:<port>; cross-origin requests other than GET, HEAD and OPTIONS are refused.
+//
+extern __declspec(dllexport) uintptr_t Host_StartAbystGateway(uintptr_t h, int port, uintptr_t* err_out);
+extern __declspec(dllexport) int AbystGateway_GetPort(uintptr_t h);
+
+// allow: 1 allow, 0 disallow.
+//
+extern __declspec(dllexport) int AbystGateway_Allow(uintptr_t h, char* peer_hash_ptr, int peer_hash_len, int allow);
+
+// accepts bidirectional streams that peers open for the protocol. the host needs an abyst server.
+//
+extern __declspec(dllexport) uintptr_t Host_ListenAbystStream(uintptr_t h, char* protocol_ptr, int protocol_len, uintptr_t* err_out);
+
+// returns 0 without an error on timeout; it may be called again.
+//
+extern __declspec(dllexport) uintptr_t AbystStreamListener_Accept(uintptr_t h, int timeout_ms, uintptr_t* err_out);
+extern __declspec(dllexport) uintptr_t Host_OpenAbystStream(uintptr_t h, char* peer_hash_ptr, int peer_hash_len, char* protocol_ptr, int protocol_len, int timeout_ms, uintptr_t* err_out);
+extern __declspec(dllexport) int AbystStream_GetPeerHash(uintptr_t h, char* buf, int buf_len);
+
+// blocks until some data arrives. returns EOF once the peer closed its side.
+//
+extern __declspec(dllexport) int AbystStream_Read(uintptr_t h, char* buf_ptr, int buf_len);
+extern __declspec(dllexport) int AbystStream_Write(uintptr_t h, char* buf_ptr, int buf_len);
+
+// closes our side; the peer reads EOF. the stream can still be read.
+//
+extern __declspec(dllexport) int AbystStream_CloseWrite(uintptr_t h);
+
 #ifdef __cplusplus
 }
 #endif
@@ -0,0 +1,500 @@
+package abyst
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cached assets are served by their hash under this path, e.g. /.abyst/cas/<sha256 hex>.
+const CACHE_PATH = "/.abyst/cas/"
+
+// the SHA-256 of each chunk of an asset, as ChunkHashes JSON: /.abyst/chunks/<sha256 hex>?size=<chunk size>.
+const CHUNKS_PATH = "/.abyst/chunks/"
+
+const DEFAULT_ASSET_CHUNK_SIZE = 1024 * 1024
+const MIN_ASSET_CHUNK_SIZE = 1024 //smaller chunk hash lists are refused.
+const ASSET_FETCH_WORKERS = 4
+
+var ErrAssetHashMismatch = errors.New("asset hash mismatch")
+var ErrNoAssetSource = errors.New("no source has the asset")
+var ErrInvalidAssetHash = errors.New("invalid asset hash")
+var ErrAssetChunkMismatch = errors.New("asset chunk hash mismatch")
+
+type AssetRef struct {
+	SHA256 string //hex
+	Size   int64
+}
+
+type ChunkHashes struct {
+	ChunkSize int64
+	SHA256    []string //hex, one per chunk
+}
+
+// AssetCache keeps assets by content hash in a directory, and serves them to other members (see ServeHTTP).
+// Fetch downloads an asset in chunks from the origin and from members that have it in their cache,
+// verifies the hash, and resumes a partial download. with an origin, each chunk is checked against
+// the origin's chunk hashes, so a member that serves a bad chunk is dropped and the chunk is fetched elsewhere.
+//
+// files: <hash> complete, <hash>.part partial, <hash>.chunks one byte per finished chunk of the partial file.
+type AssetCache struct {
+	dir       string
+	client    *http.Client //an abyst client, e.g. AbyssHost.AbystClient()
+	ChunkSize int64
+
+	fetching map[string]*assetFetch //hash
+	mtx      *sync.Mutex
+}
+
+type assetFetch struct {
+	done chan bool
+	err  error
+}
+
+func NewAssetCache(dir string, client *http.Client) (*AssetCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &AssetCache{
+		dir:       dir,
+		client:    client,
+		ChunkSize: DEFAULT_ASSET_CHUNK_SIZE,
+		fetching:  make(map[string]*assetFetch),
+		mtx:       new(sync.Mutex),
+	}, nil
+}
+
+func isAssetHash(hash string) bool {
+	decoded, err := hex.DecodeString(hash)
+	return err == nil && len(decoded) == sha256.Size && strings.ToLower(hash) == hash
+}
+
+// Path is the local file of a cached asset.
+func (c *AssetCache) Path(hash string) (string, bool) {
+	if !isAssetHash(hash) {
+		return "", false
+	}
+	result := filepath.Join(c.dir, hash)
+	if info, err := os.Stat(result); err != nil || !info.Mode().IsRegular() {
+		return "", false
+	}
+	return result, true
+}
+
+// Put adds a local asset, e.g. one that this peer introduces to a world.
+func (c *AssetCache) Put(content io.Reader) (AssetRef, error) {
+	temp, err := os.CreateTemp(c.dir, "put-*.part")
+	if err != nil {
+		return AssetRef{}, err
+	}
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(temp, hasher), content)
+	if err != nil {
+		return AssetRef{}, err
+	}
+	if err := temp.Close(); err != nil {
+		return AssetRef{}, err
+	}
+	result := AssetRef{SHA256: hex.EncodeToString(hasher.Sum(nil)), Size: size}
+	if err := os.Rename(temp.Name(), filepath.Join(c.dir, result.SHA256)); err != nil {
+		return AssetRef{}, err
+	}
+	return result, nil
+}
+
+// Resolve asks the origin for the hash (the ETag of a ContentServer) and the size of an asset.
+func (c *AssetCache) Resolve(ctx context.Context, addr string) (AssetRef, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodHead, addr, nil)
+	if err != nil {
+		return AssetRef{}, err
+	}
+	response, err := c.client.Do(request)
+	if err != nil {
+		return AssetRef{}, err
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return AssetRef{}, errors.New("asset resolve: " + response.Status)
+	}
+	hash := strings.Trim(response.Header.Get("ETag"), "\"")
+	if !isAssetHash(hash) || response.ContentLength < 0 {
+		return AssetRef{}, errors.New("asset resolve: origin does not serve content hashes")
+	}
+	return AssetRef{SHA256: hash, Size: response.ContentLength}, nil
+}
+
+// Fetch returns the local file of the asset at addr (an abyst URL), downloading it if needed.
+// sources are the peer hashes of members that may have the asset cached.
+func (c *AssetCache) Fetch(ctx context.Context, addr string, sources []string) (string, error) {
+	ref, err := c.Resolve(ctx, addr)
+	if err != nil {
+		return "", err
+	}
+	return c.FetchRef(ctx, ref, addr, sources)
+}
+
+// FetchRef is Fetch with a known hash and size. addr may be empty if the asset is only in member caches.
+func (c *AssetCache) FetchRef(ctx context.Context, ref AssetRef, addr string, sources []string) (string, error) {
+	if !isAssetHash(ref.SHA256) || ref.Size < 0 {
+		return "", ErrInvalidAssetHash
+	}
+	for {
+		if result, ok := c.Path(ref.SHA256); ok {
+			return result, nil
+		}
+
+		c.mtx.Lock()
+		fetch, ok := c.fetching[ref.SHA256]
+		if !ok {
+			fetch = &assetFetch{done: make(chan bool)}
+			c.fetching[ref.SHA256] = fetch
+		}
+		c.mtx.Unlock()
+		if ok { //someone else is downloading it.
+			select {
+			case <-fetch.done:
+				if fetch.err != nil {
+					return "", fetch.err
+				}
+				continue
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+		}
+
+		fetch.err = c.download(ctx, ref, addr, sources)
+		c.mtx.Lock()
+		delete(c.fetching, ref.SHA256)
+		c.mtx.Unlock()
+		close(fetch.done)
+		if fetch.err != nil {
+			return "", fetch.err
+		}
+	}
+}
+
+func (c *AssetCache) download(ctx context.Context, ref AssetRef, addr string, sources []string) error {
+	part_path := filepath.Join(c.dir, ref.SHA256+".part")
+	chunks_path := filepath.Join(c.dir, ref.SHA256+".chunks")
+	part, err := os.OpenFile(part_path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer part.Close()
+	chunks, err := os.OpenFile(chunks_path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer chunks.Close()
+
+	chunk_size := c.ChunkSize
+	if chunk_size <= 0 {
+		chunk_size = DEFAULT_ASSET_CHUNK_SIZE
+	}
+	chunk_count := int((ref.Size + chunk_size - 1) / chunk_size)
+	done, err := io.ReadAll(chunks)
+	if err != nil {
+		return err
+	}
+	if len(done) != chunk_count { //a new download, or one with another chunk size.
+		done = make([]byte, chunk_count)
+		if err := part.Truncate(ref.Size); err != nil {
+			return err
+		}
+		if _, err := chunks.WriteAt(done, 0); err != nil {
+			return err
+		}
+		if err := chunks.Truncate(int64(chunk_count)); err != nil {
+			return err
+		}
+	}
+
+	//only the origin is trusted for chunk hashes. without them (no origin, or an origin that has none),
+	//a bad chunk is found only by the whole file hash.
+	var chunk_hashes []string
+	if addr != "" {
+		if hashes, err := c.fetchChunkHashes(ctx, addr, ref, chunk_size); err == nil && len(hashes) == chunk_count {
+			chunk_hashes = hashes
+		} else if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+
+	urls := make([]string, 0, len(sources)+1)
+	if addr != "" {
+		urls = append(urls, addr)
+	}
+	for _, peer_hash := range sources {
+		urls = append(urls, "abyst:"+peer_hash+CACHE_PATH+ref.SHA256)
+	}
+	downloader := &assetDownloader{
+		cache:        c,
+		ref:          ref,
+		chunk_hashes: chunk_hashes,
+		urls:         urls,
+		bad:          make([]bool, len(urls)),
+		mtx:          new(sync.Mutex),
+	}
+
+	queue := make(chan int, chunk_count)
+	for i, finished := range done {
+		if finished == 0 {
+			queue <- i
+		}
+	}
+	close(queue)
+	errs := make([]error, ASSET_FETCH_WORKERS)
+	var wg sync.WaitGroup
+	for worker := range ASSET_FETCH_WORKERS {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for chunk := range queue {
+				start := int64(chunk) * chunk_size
+				end := min(start+chunk_size, ref.Size)
+				data, err := downloader.fetchChunk(ctx, worker, chunk, start, end)
+				if err == nil {
+					_, err = part.WriteAt(data, start)
+				}
+				if err == nil {
+					_, err = chunks.WriteAt([]byte{1}, int64(chunk))
+				}
+				if err != nil {
+					errs[worker] = err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return err //the finished chunks are kept for the next try.
+	}
+
+	if _, err := part.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, part); err != nil {
+		return err
+	}
+	part.Close()
+	chunks.Close()
+	os.Remove(chunks_path)
+	if hex.EncodeToString(hasher.Sum(nil)) != ref.SHA256 { //with chunk hashes, the origin's list is wrong.
+		os.Remove(part_path)
+		return ErrAssetHashMismatch
+	}
+	return os.Rename(part_path, filepath.Join(c.dir, ref.SHA256))
+}
+
+type assetDownloader struct {
+	cache        *AssetCache
+	ref          AssetRef
+	chunk_hashes []string //nil: chunks are not checked
+	urls         []string
+	bad          []bool //sources that failed, or served a bad chunk, are not asked again.
+	mtx          *sync.Mutex
+}
+
+// workers start at different sources, so the chunks are spread over them.
+func (d *assetDownloader) fetchChunk(ctx context.Context, worker int, chunk int, start int64, end int64) ([]byte, error) {
+	for i := range d.urls {
+		index := (worker + i) % len(d.urls)
+		d.mtx.Lock()
+		bad := d.bad[index]
+		d.mtx.Unlock()
+		if bad {
+			continue
+		}
+
+		data, err := d.fetchRange(ctx, d.urls[index], start, end)
+		if err == nil && d.chunk_hashes != nil {
+			if hash := sha256.Sum256(data); hex.EncodeToString(hash[:]) != d.chunk_hashes[chunk] {
+				err = ErrAssetChunkMismatch
+			}
+		}
+		if err == nil {
+			return data, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		d.mtx.Lock()
+		d.bad[index] = true
+		d.mtx.Unlock()
+	}
+	return nil, ErrNoAssetSource
+}
+
+func (d *assetDownloader) fetchRange(ctx context.Context, url string, start int64, end int64) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Range", "bytes="+strconv.FormatInt(start, 10)+"-"+strconv.FormatInt(end-1, 10))
+	request.Header.Set("If-Match", "\""+d.ref.SHA256+"\"") //another version of the file is refused.
+	response, err := d.cache.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	switch {
+	case response.StatusCode == http.StatusPartialContent:
+	case response.StatusCode == http.StatusOK && start == 0 && response.ContentLength == end: //the whole asset in one chunk.
+	default:
+		return nil, errors.New("asset chunk: " + response.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(response.Body, end-start+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) != end-start {
+		return nil, errors.New("asset chunk: wrong length")
+	}
+	return data, nil
+}
+
+// the chunk hashes of the asset from the origin at addr. the origin must still have the same version.
+func (c *AssetCache) fetchChunkHashes(ctx context.Context, addr string, ref AssetRef, chunk_size int64) ([]string, error) {
+	chunks_url, err := chunkHashesURL(addr, ref.SHA256, chunk_size)
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, chunks_url, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := c.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, errors.New("asset chunk hashes: " + response.Status)
+	}
+	var result ChunkHashes
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	if result.ChunkSize != chunk_size {
+		return nil, errors.New("asset chunk hashes: wrong chunk size")
+	}
+	return result.SHA256, nil
+}
+
+// CHUNKS_PATH on the host of addr. opaque abyst URLs ("abyst:<peer hash>/path") keep their form.
+func chunkHashesURL(addr string, hash string, chunk_size int64) (string, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", err
+	}
+	query := "?size=" + strconv.FormatInt(chunk_size, 10)
+	if u.Opaque != "" {
+		peer_hash, _, _ := strings.Cut(u.Opaque, "/")
+		return u.Scheme + ":" + peer_hash + CHUNKS_PATH + hash + query, nil
+	}
+	u.Path, u.RawPath, u.RawQuery, u.Fragment = CHUNKS_PATH+hash, "", "", ""
+	return u.String() + query, nil
+}
+
+// the chunk size of a CHUNKS_PATH request. false if it is missing or too small.
+func requestedChunkSize(r *http.Request) (int64, bool) {
+	chunk_size, err := strconv.ParseInt(r.URL.Query().Get("size"), 10, 64)
+	return chunk_size, err == nil && chunk_size >= MIN_ASSET_CHUNK_SIZE
+}
+
+// hashes content chunk by chunk, and writes the list as ChunkHashes JSON.
+func serveChunkHashes(w http.ResponseWriter, r *http.Request, content io.Reader, chunk_size int64) {
+	result := ChunkHashes{ChunkSize: chunk_size, SHA256: make([]string, 0)}
+	buf := make([]byte, chunk_size)
+	for {
+		n, err := io.ReadFull(content, buf)
+		if n > 0 {
+			hash := sha256.Sum256(buf[:n])
+			result.SHA256 = append(result.SHA256, hex.EncodeToString(hash[:]))
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, "read failed", http.StatusInternalServerError)
+			return
+		}
+	}
+	result_bytes, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, "read failed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(result_bytes))
+}
+
+// ServeHTTP serves CACHE_PATH<hash> with range requests, and CHUNKS_PATH<hash>; other paths are 404.
+func (c *AssetCache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if hash, ok := strings.CutPrefix(r.URL.Path, CHUNKS_PATH); ok {
+		c.serveChunkHashes(w, r, hash)
+		return
+	}
+	hash, ok := strings.CutPrefix(r.URL.Path, CACHE_PATH)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	file_path, ok := c.Path(hash)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	file, err := os.Open(file_path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		http.Error(w, "read failed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("ETag", "\""+hash+"\"")
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	http.ServeContent(w, r, hash, info.ModTime(), file)
+}
+
+func (c *AssetCache) serveChunkHashes(w http.ResponseWriter, r *http.Request, hash string) {
+	chunk_size, ok := requestedChunkSize(r)
+	if !ok {
+		http.Error(w, "invalid chunk size", http.StatusBadRequest)
+		return
+	}
+	file_path, ok := c.Path(hash)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	file, err := os.Open(file_path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+	serveChunkHashes(w, r, file, chunk_size)
+}
@@ -0,0 +1,329 @@
+package abyst
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	abyss "github.com/MinwooWebeng/abyss_core/interfaces"
+)
+
+const DEFAULT_INDEX = "main.aml"
+const DEFAULT_MANIFEST_PATH = "/.abyst/manifest.json"
+
+// ContentServer serves the files of a content source: an OS directory (os.DirFS), an embed.FS,
+// an archive (OpenArchiveSource), a MemoryFS, or any other fs.FS.
+// responses have a content hash ETag and Last-Modified, and support range requests.
+type ContentServer struct {
+	source fs.FS
+	Index  string //served for "/". empty: 404.
+
+	manifest_path string                 //the generated manifest. empty: no manifest.
+	cache         *AssetCache            //served under CACHE_PATH, so members can download from each other.
+	hashes        map[string]contentHash //file name -> hash, while size and mod time match.
+	mtx           *sync.Mutex
+}
+
+type contentHash struct {
+	size     int64
+	mod_time time.Time
+	sha256   string
+}
+
+// the manifest lists every file of the source. clients compare hashes to see what changed.
+type Manifest struct {
+	Files []ManifestEntry
+}
+
+type ManifestEntry struct {
+	Path     string //with the leading "/", as requested.
+	Size     int64
+	SHA256   string //hex
+	Modified time.Time
+}
+
+func NewContentServer(source fs.FS) *ContentServer {
+	return &ContentServer{
+		source: source,
+		Index:  DEFAULT_INDEX,
+		hashes: make(map[string]contentHash),
+		mtx:    new(sync.Mutex),
+	}
+}
+
+func (s *ContentServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if manifest_path := s.getManifestPath(); manifest_path != "" && r.URL.Path == manifest_path {
+		s.serveManifest(w, r)
+		return
+	}
+	if hash, ok := strings.CutPrefix(r.URL.Path, CHUNKS_PATH); ok && s.serveChunkHashes(w, r, hash) {
+		return
+	}
+	if cache := s.getCache(); cache != nil && (strings.HasPrefix(r.URL.Path, CACHE_PATH) || strings.HasPrefix(r.URL.Path, CHUNKS_PATH)) {
+		cache.ServeHTTP(w, r)
+		return
+	}
+
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" {
+		name = s.Index
+	}
+	if name == "" || !fs.ValidPath(name) {
+		http.NotFound(w, r)
+		return
+	}
+	file, err := s.source.Open(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	content, ok := file.(io.ReadSeeker)
+	if !ok { //compressed archive entries can't seek.
+		data, err := io.ReadAll(file)
+		if err != nil {
+			http.Error(w, "read failed", http.StatusInternalServerError)
+			return
+		}
+		content = bytes.NewReader(data)
+	}
+	hash, err := s.hash(name, info, content)
+	if err != nil {
+		http.Error(w, "read failed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("ETag", "\""+hash+"\"")
+	http.ServeContent(w, r, info.Name(), info.ModTime(), content)
+}
+
+// SetCache serves the cached assets with the content. nil stops it.
+func (s *ContentServer) SetCache(cache *AssetCache) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.cache = cache
+}
+
+func (s *ContentServer) getCache() *AssetCache {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.cache
+}
+
+// SetManifestPath publishes the manifest at manifest_path, e.g. DEFAULT_MANIFEST_PATH.
+// it lists every file of the source, linked or not; empty (the default) stops it.
+func (s *ContentServer) SetManifestPath(manifest_path string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.manifest_path = manifest_path
+}
+
+func (s *ContentServer) getManifestPath() string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.manifest_path
+}
+
+// Manifest hashes every file of the source. hashes are cached while the size and mod time stay the same.
+func (s *ContentServer) Manifest() (*Manifest, error) {
+	result := &Manifest{Files: make([]ManifestEntry, 0)}
+	err := fs.WalkDir(s.source, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		file, err := s.source.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		hash, err := s.hash(name, info, file)
+		if err != nil {
+			return err
+		}
+		result.Files = append(result.Files, ManifestEntry{
+			Path:     "/" + name,
+			Size:     info.Size(),
+			SHA256:   hash,
+			Modified: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(result.Files, func(i, j int) bool { return result.Files[i].Path < result.Files[j].Path })
+	return result, nil
+}
+
+// Watch polls the source every interval until ctx is done, and calls changed with the paths whose ETag
+// differs from the previous poll. a removed file has an empty ETag. the index also changes "/".
+func (s *ContentServer) Watch(ctx context.Context, interval time.Duration, changed func([]abyss.AssetChange)) {
+	etags := s.etags()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+
+		current := s.etags()
+		if current == nil { //source not readable now; compare with the next poll.
+			continue
+		}
+		changes := make([]abyss.AssetChange, 0)
+		for asset_path, etag := range current {
+			if etags[asset_path] != etag {
+				changes = append(changes, abyss.AssetChange{Path: asset_path, ETag: etag})
+			}
+		}
+		for asset_path := range etags {
+			if _, ok := current[asset_path]; !ok {
+				changes = append(changes, abyss.AssetChange{Path: asset_path})
+			}
+		}
+		etags = current
+		if len(changes) != 0 {
+			sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+			changed(changes)
+		}
+	}
+}
+
+// path -> ETag, as served. nil if the manifest fails.
+func (s *ContentServer) etags() map[string]string {
+	manifest, err := s.Manifest()
+	if err != nil {
+		return nil
+	}
+	result := make(map[string]string, len(manifest.Files)+1)
+	for _, entry := range manifest.Files {
+		result[entry.Path] = "\"" + entry.SHA256 + "\""
+		if s.Index != "" && entry.Path == "/"+s.Index {
+			result["/"] = result[entry.Path]
+		}
+	}
+	return result
+}
+
+func (s *ContentServer) serveManifest(w http.ResponseWriter, r *http.Request) {
+	manifest, err := s.Manifest()
+	if err != nil {
+		http.Error(w, "manifest failed", http.StatusInternalServerError)
+		return
+	}
+	manifest_bytes, err := json.Marshal(manifest)
+	if err != nil {
+		http.Error(w, "manifest failed", http.StatusInternalServerError)
+		return
+	}
+	manifest_hash := sha256.Sum256(manifest_bytes)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", "\""+hex.EncodeToString(manifest_hash[:])+"\"")
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(manifest_bytes))
+}
+
+// serves the chunk hashes of a source file with the hash, as AssetCache does for cached assets.
+// files are found among those hashed so far, e.g. by the HEAD of AssetCache.Resolve. returns false if there is none.
+func (s *ContentServer) serveChunkHashes(w http.ResponseWriter, r *http.Request, hash string) bool {
+	name := ""
+	s.mtx.Lock()
+	for hashed_name, cached := range s.hashes {
+		if cached.sha256 == hash {
+			name = hashed_name
+			break
+		}
+	}
+	s.mtx.Unlock()
+	if name == "" {
+		return false
+	}
+	chunk_size, ok := requestedChunkSize(r)
+	if !ok {
+		http.Error(w, "invalid chunk size", http.StatusBadRequest)
+		return true
+	}
+
+	file, err := s.source.Open(name)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	content, ok := file.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(file)
+		if err != nil {
+			return false
+		}
+		content = bytes.NewReader(data)
+	}
+	if current, err := s.hash(name, info, content); err != nil || current != hash { //changed since.
+		return false
+	}
+	serveChunkHashes(w, r, content, chunk_size)
+	return true
+}
+
+// the content is read to the end; a seeker is rewound.
+func (s *ContentServer) hash(name string, info fs.FileInfo, content io.Reader) (string, error) {
+	s.mtx.Lock()
+	cached, ok := s.hashes[name]
+	s.mtx.Unlock()
+	if ok && cached.size == info.Size() && cached.mod_time.Equal(info.ModTime()) {
+		return cached.sha256, nil
+	}
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, content); err != nil {
+		return "", err
+	}
+	if seeker, ok := content.(io.Seeker); ok {
+		if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+	}
+	result := hex.EncodeToString(hasher.Sum(nil))
+
+	s.mtx.Lock()
+	s.hashes[name] = contentHash{
+		size:     info.Size(),
+		mod_time: info.ModTime(),
+		sha256:   result,
+	}
+	s.mtx.Unlock()
+	return result, nil
+}
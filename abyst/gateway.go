@@ -0,0 +1,135 @@
+package abyst
+
+import (
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/MinwooWebeng/abyss_core/aurl"
+)
+
+// Gateway lets ordinary HTTP clients read peer content: /<peer hash>/<path> is requested as abyst:<peer hash>/<path>
+// through transport (the host's AbystClient().Transport). only allowed peers are served.
+// status, headers and bodies are forwarded as they come. it must listen on a loopback address.
+// against DNS rebinding, the Host must name the loopback address it is served on, and requests
+// that change state are refused from other origins.
+type Gateway struct {
+	proxy   *httputil.ReverseProxy
+	allowed map[string]bool //peer hash
+	mtx     *sync.Mutex
+}
+
+func NewGateway(transport http.RoundTripper) *Gateway {
+	return &Gateway{
+		proxy: &httputil.ReverseProxy{
+			Rewrite: func(r *httputil.ProxyRequest) {
+				peer_hash, raw_path, _ := strings.Cut(strings.TrimPrefix(r.In.URL.EscapedPath(), "/"), "/")
+				path, _ := url.PathUnescape("/" + raw_path) //checked in ServeHTTP
+				r.Out.URL = &url.URL{
+					Scheme:   "abyst",
+					Host:     peer_hash,
+					Path:     path,
+					RawPath:  "/" + raw_path,
+					RawQuery: r.In.URL.RawQuery,
+				}
+				r.Out.Host = ""
+			},
+			Transport:     transport,
+			FlushInterval: -1,
+		},
+		allowed: make(map[string]bool),
+		mtx:     new(sync.Mutex),
+	}
+}
+
+func (g *Gateway) Allow(peer_hash string) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	g.allowed[peer_hash] = true
+}
+
+func (g *Gateway) Disallow(peer_hash string) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	delete(g.allowed, peer_hash)
+}
+
+func (g *Gateway) IsAllowed(peer_hash string) bool {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	return g.allowed[peer_hash]
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !isGatewayHost(r, r.Host) {
+		http.Error(w, "invalid host", http.StatusForbidden)
+		return
+	}
+	if !isSafeMethod(r.Method) && !isSameOrigin(r) {
+		http.Error(w, "cross-origin request refused", http.StatusForbidden)
+		return
+	}
+	peer_hash, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if _, err := url.PathUnescape(r.URL.EscapedPath()); err != nil || !aurl.IsValidPeerID(peer_hash) {
+		http.NotFound(w, r)
+		return
+	}
+	if !g.IsAllowed(peer_hash) {
+		http.Error(w, "peer not allowed", http.StatusForbidden)
+		return
+	}
+	g.proxy.ServeHTTP(w, r)
+}
+
+// host is 127.0.0.1, [::1] or localhost, with the port that the request came in on.
+func isGatewayHost(r *http.Request, host string) bool {
+	local_addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	if !ok {
+		return false
+	}
+	_, local_port, err := net.SplitHostPort(local_addr.String())
+	if err != nil {
+		return false
+	}
+	hostname, port, err := net.SplitHostPort(host)
+	if err != nil || port != local_port {
+		return false
+	}
+	switch hostname {
+	case "localhost", "127.0.0.1", "::1":
+		return true
+	}
+	return false
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// requests without Origin or Sec-Fetch-Site come from non-browser clients.
+func isSameOrigin(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "", "same-origin", "none":
+	default:
+		return false
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	origin_url, err := url.Parse(origin)
+	if err != nil || origin_url.Scheme != "http" {
+		return false
+	}
+	return isGatewayHost(r, origin_url.Host)
+}
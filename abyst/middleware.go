@@ -0,0 +1,120 @@
+// Package abyst has helpers for abyst (HTTP/3 over abyss) servers.
+// the network service accepts abyst connections only from authenticated abyss peers,
+// and puts the peer hash in the request context.
+package abyst
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+)
+
+type peerHashKey struct{}
+
+func WithPeerHash(ctx context.Context, peer_hash string) context.Context {
+	return context.WithValue(ctx, peerHashKey{}, peer_hash)
+}
+
+// PeerHash is the authenticated abyss peer that sent the request. the local peer hash for loopback requests.
+func PeerHash(request *http.Request) (string, bool) {
+	peer_hash, ok := request.Context().Value(peerHashKey{}).(string)
+	return peer_hash, ok && peer_hash != ""
+}
+
+// a world, or anything else that tracks its members by peer hash.
+type IMemberSet interface {
+	IsMember(peer_hash string) bool
+}
+
+func AllowPeers(peer_hashes ...string) func(string) bool {
+	allowed := make(map[string]bool, len(peer_hashes))
+	for _, peer_hash := range peer_hashes {
+		allowed[peer_hash] = true
+	}
+	return func(peer_hash string) bool {
+		return allowed[peer_hash]
+	}
+}
+
+func AllowMembers(members IMemberSet) func(string) bool {
+	return members.IsMember
+}
+
+func AllowAny(string) bool { return true }
+
+// RequirePeer serves only the peers that allow accepts: 401 without a peer identity, 403 if not allowed.
+func RequirePeer(allow func(peer_hash string) bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peer_hash, ok := PeerHash(r)
+		if !ok {
+			http.Error(w, "abyss peer identity required", http.StatusUnauthorized)
+			return
+		}
+		if !allow(peer_hash) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Guard restricts paths by peer. the rule with the longest matching path prefix applies;
+// paths without a rule are served to any authenticated peer.
+type Guard struct {
+	next  http.Handler
+	rules map[string]func(string) bool //path prefix
+	mtx   *sync.Mutex
+}
+
+func NewGuard(next http.Handler) *Guard {
+	return &Guard{
+		next:  next,
+		rules: make(map[string]func(string) bool),
+		mtx:   new(sync.Mutex),
+	}
+}
+
+// replaces the rule for the prefix, if any.
+func (g *Guard) Restrict(path_prefix string, allow func(peer_hash string) bool) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	g.rules[path_prefix] = allow
+}
+
+func (g *Guard) Unrestrict(path_prefix string) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	delete(g.rules, path_prefix)
+}
+
+// the rules apply to the cleaned path, as ContentServer serves it; "/a/../private" is "/private".
+func (g *Guard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	RequirePeer(g.rule(path.Clean("/"+r.URL.Path)), g.next).ServeHTTP(w, r)
+}
+
+func (g *Guard) rule(clean_path string) func(string) bool {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	result := AllowAny
+	matched := -1
+	for prefix, allow := range g.rules {
+		if hasPathPrefix(clean_path, prefix) && len(prefix) > matched {
+			result, matched = allow, len(prefix)
+		}
+	}
+	return result
+}
+
+// prefix matches whole path segments: "/private" matches "/private" and "/private/a", not "/privateX".
+func hasPathPrefix(clean_path string, prefix string) bool {
+	prefix = path.Clean("/" + prefix)
+	if prefix == "/" {
+		return true
+	}
+	return clean_path == prefix || strings.HasPrefix(clean_path, prefix+"/")
+}
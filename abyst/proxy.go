@@ -0,0 +1,86 @@
+package abyst
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// set by the proxy from the authenticated peer. the same headers from the peer are dropped.
+const PEER_HASH_HEADER = "X-Abyss-Peer-Hash"
+const LOCAL_HASH_HEADER = "X-Abyss-Local-Hash" //set if ProxyConfig.LocalHash is not empty.
+
+type ProxyConfig struct {
+	Backend          string //e.g. http://127.0.0.1:8080 ; its path is prepended.
+	PathPrefix       string //only requests under it are forwarded, without it. empty: all.
+	LocalHash        string
+	UnencryptedHTTP2 bool //h2c backend. https backends negotiate HTTP/2 by themselves.
+}
+
+// NewReverseProxy forwards peer requests to a local HTTP/1.1 or HTTP/2 backend.
+// bodies are streamed both ways, and responses are flushed as they come.
+func NewReverseProxy(config ProxyConfig) (http.Handler, error) {
+	backend, err := url.Parse(config.Backend)
+	if err != nil {
+		return nil, err
+	}
+	if backend.Scheme != "http" && backend.Scheme != "https" {
+		return nil, errors.New("proxy backend must be http or https")
+	}
+	path_prefix := strings.TrimSuffix(config.PathPrefix, "/")
+	escaped_prefix := (&url.URL{Path: path_prefix}).EscapedPath()
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config.UnencryptedHTTP2 {
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	}
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			clean_path, escaped_path, _ := cleanProxyPath(r.In.URL)
+			r.Out.URL.Path = strings.TrimPrefix(clean_path, path_prefix)
+			r.Out.URL.RawPath = strings.TrimPrefix(escaped_path, escaped_prefix)
+			r.SetURL(backend)
+			r.SetXForwarded()
+
+			r.Out.Header.Del(PEER_HASH_HEADER)
+			r.Out.Header.Del(LOCAL_HASH_HEADER)
+			if peer_hash, ok := PeerHash(r.In); ok {
+				r.Out.Header.Set(PEER_HASH_HEADER, peer_hash)
+			}
+			if config.LocalHash != "" {
+				r.Out.Header.Set(LOCAL_HASH_HEADER, config.LocalHash)
+			}
+		},
+		Transport:     transport,
+		FlushInterval: -1,
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clean_path, _, ok := cleanProxyPath(r.URL)
+		if !ok {
+			http.Error(w, "invalid path", http.StatusBadRequest)
+			return
+		}
+		if path_prefix != "" && clean_path != path_prefix && !strings.HasPrefix(clean_path, path_prefix+"/") {
+			http.NotFound(w, r)
+			return
+		}
+		proxy.ServeHTTP(w, r)
+	}), nil
+}
+
+// the path without dot segments, decoded and escaped. escaped separators ("%2F") stay escaped.
+// false if the escaped path hides dot segments (e.g. "%2e%2e"), which the backend might resolve.
+func cleanProxyPath(u *url.URL) (string, string, bool) {
+	clean_path := path.Clean("/" + u.Path)
+	escaped_path := path.Clean("/" + u.EscapedPath())
+	if strings.HasSuffix(u.Path, "/") && clean_path != "/" { //kept; backends tell "/dir/" from "/dir".
+		clean_path += "/"
+		escaped_path += "/"
+	}
+	unescaped_path, err := url.PathUnescape(escaped_path)
+	return clean_path, escaped_path, err == nil && unescaped_path == clean_path
+}
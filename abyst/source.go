@@ -0,0 +1,221 @@
+package abyst
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrUnknownArchive = errors.New("unknown archive type")
+
+// OpenArchiveSource opens a .zip, .tar, .tar.gz or .tgz file as a content source.
+// zip entries are read from the file until it is closed; tar archives are loaded in memory.
+func OpenArchiveSource(archive_path string) (fs.FS, io.Closer, error) {
+	switch {
+	case strings.HasSuffix(archive_path, ".zip"):
+		reader, err := zip.OpenReader(archive_path)
+		if err != nil {
+			return nil, nil, err
+		}
+		return reader, reader, nil
+	case strings.HasSuffix(archive_path, ".tar"),
+		strings.HasSuffix(archive_path, ".tar.gz"),
+		strings.HasSuffix(archive_path, ".tgz"):
+		file, err := os.Open(archive_path)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer file.Close()
+		var reader io.Reader = file
+		if !strings.HasSuffix(archive_path, ".tar") {
+			gzip_reader, err := gzip.NewReader(file)
+			if err != nil {
+				return nil, nil, err
+			}
+			reader = gzip_reader
+		}
+		source, err := NewTarSource(reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		return source, io.NopCloser(nil), nil
+	default:
+		return nil, nil, ErrUnknownArchive
+	}
+}
+
+// NewTarSource loads the regular files of a tar stream.
+func NewTarSource(reader io.Reader) (*MemoryFS, error) {
+	result := NewMemoryFS()
+	tar_reader := tar.NewReader(reader)
+	for {
+		header, err := tar_reader.Next()
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := io.ReadAll(tar_reader)
+		if err != nil {
+			return nil, err
+		}
+		name := path.Clean(strings.TrimPrefix(header.Name, "./"))
+		if !fs.ValidPath(name) {
+			continue
+		}
+		result.set(name, data, header.ModTime)
+	}
+}
+
+// MemoryFS is a content source that the application fills. directories are implied by file names.
+type MemoryFS struct {
+	files map[string]memoryEntry
+	mtx   *sync.Mutex
+}
+
+type memoryEntry struct {
+	data     []byte
+	mod_time time.Time
+}
+
+func NewMemoryFS() *MemoryFS {
+	return &MemoryFS{
+		files: make(map[string]memoryEntry),
+		mtx:   new(sync.Mutex),
+	}
+}
+
+// Set adds or replaces a file; name is slash separated, without a leading "/".
+func (m *MemoryFS) Set(name string, data []byte) error {
+	if !fs.ValidPath(name) || name == "." {
+		return fs.ErrInvalid
+	}
+	m.set(name, bytes.Clone(data), time.Now())
+	return nil
+}
+
+func (m *MemoryFS) Delete(name string) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	delete(m.files, name)
+}
+
+func (m *MemoryFS) set(name string, data []byte, mod_time time.Time) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.files[name] = memoryEntry{data: data, mod_time: mod_time}
+}
+
+func (m *MemoryFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if entry, ok := m.files[name]; ok {
+		return &memoryFile{
+			Reader: bytes.NewReader(entry.data),
+			info:   memoryInfo{name: path.Base(name), size: int64(len(entry.data)), mod_time: entry.mod_time},
+		}, nil
+	}
+
+	prefix := name + "/"
+	if name == "." {
+		prefix = ""
+	}
+	children := make(map[string]fs.DirEntry)
+	for file_name, entry := range m.files {
+		rest, ok := strings.CutPrefix(file_name, prefix)
+		if !ok {
+			continue
+		}
+		if child, _, is_dir := strings.Cut(rest, "/"); is_dir {
+			children[child] = fs.FileInfoToDirEntry(memoryInfo{name: child, dir: true})
+		} else {
+			children[child] = fs.FileInfoToDirEntry(memoryInfo{name: child, size: int64(len(entry.data)), mod_time: entry.mod_time})
+		}
+	}
+	if len(children) == 0 && name != "." {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	entries := make([]fs.DirEntry, 0, len(children))
+	for _, child := range children {
+		entries = append(entries, child)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return &memoryDir{
+		info:    memoryInfo{name: path.Base(name), dir: true},
+		entries: entries,
+	}, nil
+}
+
+type memoryInfo struct {
+	name     string
+	size     int64
+	mod_time time.Time
+	dir      bool
+}
+
+func (i memoryInfo) Name() string       { return i.name }
+func (i memoryInfo) Size() int64        { return i.size }
+func (i memoryInfo) ModTime() time.Time { return i.mod_time }
+func (i memoryInfo) IsDir() bool        { return i.dir }
+func (i memoryInfo) Sys() any           { return nil }
+func (i memoryInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+type memoryFile struct {
+	*bytes.Reader
+	info memoryInfo
+}
+
+func (f *memoryFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *memoryFile) Close() error               { return nil }
+
+type memoryDir struct {
+	info    memoryInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *memoryDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *memoryDir) Close() error               { return nil }
+func (d *memoryDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: fs.ErrInvalid}
+}
+
+func (d *memoryDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(rest))
+	d.offset += n
+	return rest[:n], nil
+}
@@ -0,0 +1,228 @@
+package abyst
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/quicvarint"
+)
+
+// a bidirectional stream on an abyst connection starts with this HTTP/3 frame type (not defined by HTTP/3),
+// then the protocol ID (varint length, bytes). the server answers a varint 0 when a listener takes it.
+const STREAM_FRAME_TYPE = 0x4142
+
+const STREAM_HEADER_TIMEOUT = 10 * time.Second
+const MAX_STREAM_PROTOCOL_LENGTH = 256
+
+// stream error code when no listener takes the protocol.
+const STREAM_REJECTED quic.StreamErrorCode = 0x0A10
+
+var ErrStreamRejected = errors.New("abyst stream rejected")
+var ErrProtocolInUse = errors.New("abyst stream protocol in use")
+var ErrStreamListenerClosed = errors.New("abyst stream listener closed")
+
+// Stream is a bidirectional stream with an authenticated peer, for one protocol.
+type Stream struct {
+	quic.Stream
+	peer_hash string
+	protocol  string
+
+	read_done  bool
+	write_done bool
+	on_done    func() //once both directions are done.
+	mtx        *sync.Mutex
+}
+
+func newStream(stream quic.Stream, peer_hash string, protocol string) *Stream {
+	return &Stream{
+		Stream:    stream,
+		peer_hash: peer_hash,
+		protocol:  protocol,
+		mtx:       new(sync.Mutex),
+	}
+}
+
+func (s *Stream) PeerHash() string { return s.peer_hash }
+func (s *Stream) Protocol() string { return s.protocol }
+
+func (s *Stream) Read(p []byte) (int, error) {
+	n, err := s.Stream.Read(p)
+	if err != nil {
+		s.done(true, false)
+	}
+	return n, err
+}
+func (s *Stream) Close() error {
+	err := s.Stream.Close()
+	s.done(false, true)
+	return err
+}
+func (s *Stream) CancelRead(code quic.StreamErrorCode) {
+	s.Stream.CancelRead(code)
+	s.done(true, false)
+}
+func (s *Stream) CancelWrite(code quic.StreamErrorCode) {
+	s.Stream.CancelWrite(code)
+	s.done(false, true)
+}
+
+// OnDone is called once the stream is read to the end (or canceled) and closed (or canceled).
+func (s *Stream) OnDone(f func()) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.on_done = f
+}
+
+func (s *Stream) done(read bool, write bool) {
+	s.mtx.Lock()
+	was_done := s.read_done && s.write_done
+	s.read_done = s.read_done || read
+	s.write_done = s.write_done || write
+	on_done := s.on_done
+	now_done := !was_done && s.read_done && s.write_done
+	s.mtx.Unlock()
+
+	if now_done && on_done != nil {
+		on_done()
+	}
+}
+
+// OpenStream opens a stream for protocol on an abyst connection, and waits until the peer accepts it.
+func OpenStream(ctx context.Context, connection quic.Connection, peer_hash string, protocol string) (*Stream, error) {
+	if protocol == "" || len(protocol) > MAX_STREAM_PROTOCOL_LENGTH {
+		return nil, errors.New("invalid abyst stream protocol")
+	}
+	stream, err := connection.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	header := quicvarint.Append(nil, STREAM_FRAME_TYPE)
+	header = quicvarint.Append(header, uint64(len(protocol)))
+	header = append(header, protocol...)
+	if _, err := stream.Write(header); err != nil {
+		stream.CancelRead(STREAM_REJECTED)
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetReadDeadline(deadline)
+	}
+	answer, err := quicvarint.Read(quicvarint.NewReader(stream))
+	stream.SetReadDeadline(time.Time{})
+	if err != nil || answer != 0 {
+		stream.CancelRead(STREAM_REJECTED)
+		stream.CancelWrite(STREAM_REJECTED)
+		var stream_err *quic.StreamError
+		if errors.As(err, &stream_err) && stream_err.ErrorCode == STREAM_REJECTED {
+			return nil, ErrStreamRejected
+		}
+		return nil, errors.Join(ErrStreamRejected, err)
+	}
+	return newStream(stream, peer_hash, protocol), nil
+}
+
+// StreamMux hands accepted streams to the listener of their protocol.
+type StreamMux struct {
+	listeners map[string]*StreamListener
+	mtx       *sync.Mutex
+}
+
+func NewStreamMux() *StreamMux {
+	return &StreamMux{
+		listeners: make(map[string]*StreamListener),
+		mtx:       new(sync.Mutex),
+	}
+}
+
+func (m *StreamMux) Listen(protocol string) (*StreamListener, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if _, ok := m.listeners[protocol]; ok {
+		return nil, ErrProtocolInUse
+	}
+	result := &StreamListener{
+		mux:      m,
+		protocol: protocol,
+		streams:  make(chan *Stream, 16),
+		closed:   make(chan bool),
+	}
+	m.listeners[protocol] = result
+	return result, nil
+}
+
+// Serve reads the protocol ID of a stream that started with STREAM_FRAME_TYPE, and passes it on, or rejects it.
+func (m *StreamMux) Serve(stream quic.Stream, peer_hash string) {
+	reject := func() {
+		stream.CancelRead(STREAM_REJECTED)
+		stream.CancelWrite(STREAM_REJECTED)
+	}
+
+	stream.SetReadDeadline(time.Now().Add(STREAM_HEADER_TIMEOUT))
+	reader := quicvarint.NewReader(stream)
+	length, err := quicvarint.Read(reader)
+	if err != nil || length == 0 || length > MAX_STREAM_PROTOCOL_LENGTH {
+		reject()
+		return
+	}
+	protocol := make([]byte, length)
+	if _, err := io.ReadFull(stream, protocol); err != nil {
+		reject()
+		return
+	}
+	stream.SetReadDeadline(time.Time{})
+
+	m.mtx.Lock()
+	listener, ok := m.listeners[string(protocol)]
+	m.mtx.Unlock()
+	if !ok {
+		reject()
+		return
+	}
+	if _, err := stream.Write(quicvarint.Append(nil, 0)); err != nil {
+		reject()
+		return
+	}
+	select {
+	case listener.streams <- newStream(stream, peer_hash, string(protocol)):
+	case <-listener.closed:
+		reject()
+	case <-stream.Context().Done():
+	}
+}
+
+type StreamListener struct {
+	mux      *StreamMux
+	protocol string
+	streams  chan *Stream
+	closed   chan bool
+	once     sync.Once
+}
+
+func (l *StreamListener) Protocol() string { return l.protocol }
+
+func (l *StreamListener) Accept(ctx context.Context) (*Stream, error) {
+	select {
+	case stream := <-l.streams:
+		return stream, nil
+	case <-l.closed:
+		return nil, ErrStreamListenerClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Close rejects further streams of the protocol. the protocol can be listened again.
+func (l *StreamListener) Close() {
+	l.once.Do(func() {
+		l.mux.mtx.Lock()
+		delete(l.mux.listeners, l.protocol)
+		l.mux.mtx.Unlock()
+		close(l.closed)
+	})
+}
@@ -1,6 +1,7 @@
 package ahmp
 
 import (
+	"net"
 	"time"
 
 	"github.com/google/uuid"
@@ -61,6 +62,47 @@ type SOD struct {
 	ObjectIDs       []uuid.UUID
 }
 
+type AIV struct { //asset invalidation
+	SenderSessionID uuid.UUID
+	RecverSessionID uuid.UUID
+	Assets          []abyss.AssetChange
+}
+
+type OBS struct {
+	Address *net.UDPAddr
+}
+
+type PRQ struct { //hole punch request, sent to a peer connected to both sides
+	TargetHash string
+}
+type RLQ struct { //relay request, sent to a peer connected to both sides. answered with PNC carrying the relay address
+	TargetHash string
+}
+type DFQ struct { //DHT find query: the records closest to the target, or the target's own record
+	QueryID    uuid.UUID
+	TargetHash string
+}
+type DFR struct { //DHT find response
+	QueryID uuid.UUID
+	Records []abyss.SignedPeerRecord
+}
+type DST struct { //DHT store
+	Record abyss.SignedPeerRecord
+}
+type HKR struct { //handshake key rotation. the certificate is signed by the sender's root key.
+	HandshakeKeyCertificateDer []byte
+}
+type DRV struct { //device revocation, signed by the owner root key. passed on to connected peers.
+	Revocation abyss.DeviceRevocation
+}
+type PNC struct { //hole punch notice, relayed to both sides
+	PeerHash                   string
+	Addresses                  []*net.UDPAddr
+	RootCertificateDer         []byte
+	HandshakeKeyCertificateDer []byte
+	Delay                      time.Duration
+}
+
 type INVAL struct {
 	Err error
 }
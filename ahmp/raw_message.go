@@ -2,6 +2,8 @@ package ahmp
 
 import (
 	"errors"
+	"net"
+	"net/netip"
 	"time"
 
 	"github.com/MinwooWebeng/abyss_core/aurl"
@@ -19,6 +21,26 @@ type RawSessionInfoForDiscovery struct {
 	HandshakeKeyCertificateDer []byte
 }
 
+type RawPeerRecord struct {
+	Body                       []byte
+	Signature                  []byte
+	RootCertificateDer         []byte
+	HandshakeKeyCertificateDer []byte
+}
+
+// only checks the shape. the signature is verified by the network service.
+func (r *RawPeerRecord) TryParse() (abyss.SignedPeerRecord, error) {
+	if len(r.Body) == 0 || len(r.Signature) == 0 || len(r.RootCertificateDer) == 0 || len(r.HandshakeKeyCertificateDer) == 0 {
+		return abyss.SignedPeerRecord{}, errors.New("incomplete peer record")
+	}
+	return abyss.SignedPeerRecord{
+		Body:                       r.Body,
+		Signature:                  r.Signature,
+		RootCertificateDer:         r.RootCertificateDer,
+		HandshakeKeyCertificateDer: r.HandshakeKeyCertificateDer,
+	}, nil
+}
+
 type RawSessionInfoForSJN struct {
 	PeerHash  string
 	SessionID string
@@ -36,6 +58,20 @@ const (
 
 	SOA_T
 	SOD_T
+
+	OBS_T
+	PRQ_T
+	PNC_T
+	RLQ_T
+
+	DFQ_T
+	DFR_T
+	DST_T
+
+	HKR_T
+	DRV_T
+
+	AIV_T
 )
 
 type RawJN struct {
@@ -292,3 +328,173 @@ func (r *RawSOD) TryParse() (*SOD, error) {
 	}
 	return &SOD{ssid, rsid, oids}, nil
 }
+
+type RawAssetChange struct {
+	Path string
+	ETag string
+}
+type RawAIV struct {
+	SenderSessionID string
+	RecverSessionID string
+	Assets          []RawAssetChange
+}
+
+func (r *RawAIV) TryParse() (*AIV, error) {
+	ssid, err := uuid.Parse(r.SenderSessionID)
+	if err != nil {
+		return nil, err
+	}
+	rsid, err := uuid.Parse(r.RecverSessionID)
+	if err != nil {
+		return nil, err
+	}
+	assets, _, err := functional.Filter_until_err(r.Assets,
+		func(asset_raw RawAssetChange) (abyss.AssetChange, error) {
+			if asset_raw.Path == "" {
+				return abyss.AssetChange{}, errors.New("empty asset path")
+			}
+			return abyss.AssetChange{
+				Path: asset_raw.Path,
+				ETag: asset_raw.ETag,
+			}, nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return &AIV{ssid, rsid, assets}, nil
+}
+
+type RawOBS struct {
+	Address string //remote address of the inbound connection, as seen by the sender
+}
+
+func (r *RawOBS) TryParse() (*OBS, error) {
+	addrport, err := netip.ParseAddrPort(r.Address)
+	if err != nil {
+		return nil, err
+	}
+	return &OBS{net.UDPAddrFromAddrPort(addrport)}, nil
+}
+
+type RawPRQ struct {
+	TargetHash string
+}
+
+func (r *RawPRQ) TryParse() (*PRQ, error) {
+	if !aurl.IsValidPeerID(r.TargetHash) {
+		return nil, errors.New("invalid peer hash")
+	}
+	return &PRQ{r.TargetHash}, nil
+}
+
+type RawRLQ struct {
+	TargetHash string
+}
+
+func (r *RawRLQ) TryParse() (*RLQ, error) {
+	if !aurl.IsValidPeerID(r.TargetHash) {
+		return nil, errors.New("invalid peer hash")
+	}
+	return &RLQ{r.TargetHash}, nil
+}
+
+type RawPNC struct {
+	PeerHash                   string
+	Addresses                  []string
+	RootCertificateDer         []byte
+	HandshakeKeyCertificateDer []byte
+	DelayMs                    int
+}
+
+func (r *RawPNC) TryParse() (*PNC, error) {
+	addresses, _, err := functional.Filter_until_err(r.Addresses,
+		func(address_raw string) (*net.UDPAddr, error) {
+			addrport, err := netip.ParseAddrPort(address_raw)
+			if err != nil {
+				return nil, err
+			}
+			return net.UDPAddrFromAddrPort(addrport), nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	if r.DelayMs < 0 {
+		return nil, errors.New("negative punch delay")
+	}
+	return &PNC{r.PeerHash, addresses, r.RootCertificateDer, r.HandshakeKeyCertificateDer, time.Duration(r.DelayMs) * time.Millisecond}, nil
+}
+
+type RawDFQ struct {
+	QueryID    string
+	TargetHash string
+}
+
+func (r *RawDFQ) TryParse() (*DFQ, error) {
+	qid, err := uuid.Parse(r.QueryID)
+	if err != nil {
+		return nil, err
+	}
+	if !aurl.IsValidPeerID(r.TargetHash) {
+		return nil, errors.New("invalid peer hash")
+	}
+	return &DFQ{qid, r.TargetHash}, nil
+}
+
+type RawDFR struct {
+	QueryID string
+	Records []RawPeerRecord
+}
+
+func (r *RawDFR) TryParse() (*DFR, error) {
+	qid, err := uuid.Parse(r.QueryID)
+	if err != nil {
+		return nil, err
+	}
+	records, _, err := functional.Filter_until_err(r.Records, func(i RawPeerRecord) (abyss.SignedPeerRecord, error) {
+		return i.TryParse()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &DFR{qid, records}, nil
+}
+
+type RawDST struct {
+	Record RawPeerRecord
+}
+
+func (r *RawDST) TryParse() (*DST, error) {
+	record, err := r.Record.TryParse()
+	if err != nil {
+		return nil, err
+	}
+	return &DST{record}, nil
+}
+
+type RawHKR struct {
+	HandshakeKeyCertificateDer []byte
+}
+
+func (r *RawHKR) TryParse() (*HKR, error) {
+	if len(r.HandshakeKeyCertificateDer) == 0 {
+		return nil, errors.New("empty handshake key certificate")
+	}
+	return &HKR{r.HandshakeKeyCertificateDer}, nil
+}
+
+type RawDRV struct {
+	Body                    []byte
+	Signature               []byte
+	OwnerRootCertificateDer []byte
+}
+
+func (r *RawDRV) TryParse() (*DRV, error) {
+	if len(r.Body) == 0 || len(r.Signature) == 0 || len(r.OwnerRootCertificateDer) == 0 {
+		return nil, errors.New("incomplete device revocation")
+	}
+	return &DRV{abyss.DeviceRevocation{
+		Body:                    r.Body,
+		Signature:               r.Signature,
+		OwnerRootCertificateDer: r.OwnerRootCertificateDer,
+	}}, nil
+}
@@ -18,6 +18,8 @@ type AND struct {
 	peers  map[string]abyss.IANDPeer //id hash - peer
 	worlds map[uuid.UUID]*ANDWorld   //local session id - world
 
+	is_blocked func(peer_hash string) bool
+
 	stat ANDStatistics
 
 	api_mtx *sync.Mutex
@@ -29,6 +31,7 @@ func NewAND(local_hash string) *AND {
 		local_hash: local_hash,
 		peers:      make(map[string]abyss.IANDPeer),
 		worlds:     make(map[uuid.UUID]*ANDWorld),
+		is_blocked: func(string) bool { return false },
 		api_mtx:    new(sync.Mutex),
 	}
 }
@@ -37,6 +40,13 @@ func (a *AND) EventChannel() chan abyss.NeighborEvent {
 	return a.eventCh
 }
 
+func (a *AND) SetPeerFilter(is_blocked func(peer_hash string) bool) {
+	a.api_mtx.Lock()
+	defer a.api_mtx.Unlock()
+
+	a.is_blocked = is_blocked
+}
+
 func (a *AND) PeerConnected(peer abyss.IANDPeer) abyss.ANDERROR {
 	a.api_mtx.Lock()
 	defer a.api_mtx.Unlock()
@@ -300,6 +310,20 @@ func (a *AND) SOD(local_session_id uuid.UUID, peer_session abyss.ANDPeerSession,
 	world.SOD(peer_session, objectIDs)
 	return 0
 }
+func (a *AND) AIV(local_session_id uuid.UUID, peer_session abyss.ANDPeerSession, assets []abyss.AssetChange) abyss.ANDERROR {
+	a.api_mtx.Lock()
+	defer a.api_mtx.Unlock()
+
+	world, ok := a.worlds[local_session_id]
+	if !ok {
+		a.stat.B(36)
+		return 0
+	}
+	a.stat.B(37)
+
+	world.AIV(peer_session, assets)
+	return 0
+}
 
 func (a *AND) Statistics() string {
 	return a.stat.String()
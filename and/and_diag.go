@@ -27,9 +27,10 @@ type ANDStatistics struct {
 	RST_RX int
 	SOA_RX int
 	SOD_RX int
+	AIV_RX int
 
-	_b [36]int
-	_w [80]int
+	_b [38]int
+	_w [84]int
 }
 
 func (s *ANDStatistics) B(i int) {
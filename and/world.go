@@ -391,6 +391,10 @@ func (w *ANDWorld) JNI_MEMS(sender_id string, mem_info abyss.ANDFullPeerSessionI
 		w.o.stat.W(15)
 		return
 	}
+	if w.o.is_blocked(peer_id) {
+		w.o.stat.W(80)
+		return
+	}
 
 	info, ok := w.peers[peer_id]
 	if !ok {
@@ -405,8 +409,9 @@ func (w *ANDWorld) JNI_MEMS(sender_id string, mem_info abyss.ANDFullPeerSessionI
 			},
 		}
 		w.ech <- abyss.NeighborEvent{
-			Type:   abyss.ANDConnectRequest,
-			Object: mem_info.AURL,
+			Type:           abyss.ANDConnectRequest,
+			ANDPeerSession: w.peers[sender_id].ANDPeerSession, //introducer
+			Object:         mem_info.AURL,
 		}
 		return
 	}
@@ -664,6 +669,31 @@ func (w *ANDWorld) SOD(peer_session abyss.ANDPeerSession, objectIDs []uuid.UUID)
 		w.o.stat.W(49)
 	}
 }
+func (w *ANDWorld) AIV(peer_session abyss.ANDPeerSession, assets []abyss.AssetChange) {
+	w.o.stat.AIV_RX++
+
+	info := w.peers[peer_session.Peer.IDHash()]
+	if info.PeerSessionID != peer_session.PeerSessionID {
+		w.o.stat.W(81)
+
+		w.o.stat.RST_TX++
+		peer_session.Peer.TrySendRST(w.lsid, peer_session.PeerSessionID)
+		return
+	}
+	switch info.state {
+	case WS_MEM:
+		w.o.stat.W(82)
+
+		w.ech <- abyss.NeighborEvent{
+			Type:           abyss.ANDAssetInvalidate,
+			LocalSessionID: w.lsid,
+			ANDPeerSession: peer_session,
+			Object:         assets,
+		}
+	default:
+		w.o.stat.W(83)
+	}
+}
 func (w *ANDWorld) RST(peer_session abyss.ANDPeerSession) {
 	w.o.stat.RST_RX++
 
@@ -11,16 +11,21 @@ type AURL struct {
 	Scheme    string
 	Hash      string
 	Addresses []*net.UDPAddr
+	Relays    []string //hashes of peers that may relay for this peer. written as "@hash" candidates.
 	Path      string
 }
 
+// abyss:hash:9.8.7.6:1605|@relayhash/path
 func (a *AURL) ToString() string {
-	if len(a.Addresses) == 0 {
+	if len(a.Addresses) == 0 && len(a.Relays) == 0 {
 		return a.Scheme + ":" + a.Hash + "/" + a.Path
 	}
-	candidates_string := make([]string, len(a.Addresses))
-	for i, c := range a.Addresses {
-		candidates_string[i] = c.String()
+	candidates_string := make([]string, 0, len(a.Addresses)+len(a.Relays))
+	for _, c := range a.Addresses {
+		candidates_string = append(candidates_string, c.String())
+	}
+	for _, r := range a.Relays {
+		candidates_string = append(candidates_string, "@"+r)
 	}
 	return a.Scheme + ":" + a.Hash + ":" + strings.Join(candidates_string, "|") + "/" + a.Path
 }
@@ -99,6 +104,12 @@ func tryParseAbyss(input string) (*AURL, error) {
 		if ep == "" {
 			continue
 		}
+		if relay, ok := strings.CutPrefix(ep, "@"); ok {
+			if IsValidPeerID(relay) {
+				result.Relays = append(result.Relays, relay)
+			}
+			continue
+		}
 		var ipPart, portPart string
 		if strings.HasPrefix(ep, "[") {
 			closeIdx := strings.Index(ep, "]")
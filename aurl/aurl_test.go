@@ -41,3 +41,28 @@ func TestAurl(t *testing.T) {
 	ParsePrintAURL("abyss:hhh:|/")
 	ParsePrintAURL("abyss:hhh::1605/")
 }
+
+func TestAurlRelay(t *testing.T) {
+	const target = "IA7TEaKUbfPY9zyd5RLntUgHPSHnxiUVafpggVe1tNcVv"
+	const relay = "IBqxZkEyyJnN2uKKHmpVQY5j3FnNNzGyJ7nbyX2TKYcMj"
+	raw := "abyss:" + target + ":9.8.7.6:1605|@" + relay + "/somepath"
+
+	_aurl, err := TryParse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(_aurl.Addresses) != 1 || len(_aurl.Relays) != 1 || _aurl.Relays[0] != relay {
+		t.Fatal("relay candidate parse fail")
+	}
+	if _aurl.ToString() != raw {
+		t.Fatal("round trip mismatch: " + _aurl.ToString())
+	}
+
+	relay_only, err := TryParse("abyss:" + target + ":@" + relay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(relay_only.Addresses) != 0 || len(relay_only.Relays) != 1 {
+		t.Fatal("relay-only parse fail")
+	}
+}
@@ -0,0 +1,178 @@
+// abyss-keytool generates, stores and inspects abyss identities.
+//
+//	abyss-keytool generate -out id.aks [-type ed25519|P-256|P-384|RSA] [-scheme migration|hybrid|rsa]
+//	abyss-keytool info     -in id.aks
+//	abyss-keytool export   -in id.aks -out root_key.pem
+//	abyss-keytool import   -in root_key.pem -out id.aks [-scheme ...]
+//	abyss-keytool passwd   -in id.aks
+//	abyss-keytool bundle   -in id.aks [-addr 203.0.113.5:1605,...] [-out peer.pem]
+//
+// The passphrase is read from -passfile, the ABYSS_KEYTOOL_PASSPHRASE environment variable, or stdin.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/MinwooWebeng/abyss_core/keystore"
+	abyss_net "github.com/MinwooWebeng/abyss_core/net_service"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	command, args := os.Args[1], os.Args[2:]
+
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	in_path := flags.String("in", "", "input file")
+	out_path := flags.String("out", "", "output file. stdout if empty, where allowed")
+	key_type := flags.String("type", "ed25519", "root key type: ed25519, P-256, P-384 or RSA")
+	scheme_name := flags.String("scheme", "migration", "handshake key scheme: migration, hybrid or rsa")
+	pass_path := flags.String("passfile", "", "file holding the passphrase")
+	addresses := flags.String("addr", "", "comma-separated addresses for the bundle AURL")
+	flags.Parse(args)
+
+	passphrase := func(prompt string) []byte {
+		result, err := readPassphrase(*pass_path, prompt)
+		check(err)
+		return result
+	}
+
+	switch command {
+	case "generate":
+		requireFlag("out", *out_path)
+		scheme, err := parseScheme(*scheme_name)
+		check(err)
+		private_key, err := keystore.GenerateKey(*key_type)
+		check(err)
+		store, err := keystore.New(private_key, scheme)
+		check(err)
+		check(store.Save(*out_path, passphrase("new passphrase")))
+		fmt.Println(store.Identity.IDHash())
+	case "info":
+		requireFlag("in", *in_path)
+		store, err := keystore.Load(*in_path, passphrase("passphrase"))
+		check(err)
+		fmt.Println("peer hash: " + store.Identity.IDHash())
+		if device_id := store.Identity.DeviceID(); device_id != "" {
+			fmt.Println("owner:     " + store.Identity.OwnerHash())
+		}
+		fmt.Print(store.Identity.RootCertificate())
+		fmt.Print(store.Identity.HandshakeKeyCertificate())
+	case "export":
+		requireFlag("in", *in_path)
+		requireFlag("out", *out_path)
+		store, err := keystore.Load(*in_path, passphrase("passphrase"))
+		check(err)
+		private_key_pem, err := store.ExportPrivateKey()
+		check(err)
+		check(os.WriteFile(*out_path, private_key_pem, 0600))
+	case "import":
+		requireFlag("in", *in_path)
+		requireFlag("out", *out_path)
+		scheme, err := parseScheme(*scheme_name)
+		check(err)
+		private_key_pem, err := os.ReadFile(*in_path)
+		check(err)
+		private_key, err := keystore.ImportPrivateKey(private_key_pem)
+		check(err)
+		store, err := keystore.New(private_key, scheme)
+		check(err)
+		check(store.Save(*out_path, passphrase("new passphrase")))
+		fmt.Println(store.Identity.IDHash())
+	case "passwd":
+		requireFlag("in", *in_path)
+		store, err := keystore.Load(*in_path, passphrase("passphrase"))
+		check(err)
+		check(store.Save(*in_path, passphrase("new passphrase")))
+	case "bundle":
+		requireFlag("in", *in_path)
+		store, err := keystore.Load(*in_path, passphrase("passphrase"))
+		check(err)
+		bundle_addresses, err := parseAddresses(*addresses)
+		check(err)
+		bundle, err := keystore.NewPeerBundle(store.Identity, bundle_addresses).Encode()
+		check(err)
+		if *out_path == "" {
+			os.Stdout.Write(bundle)
+		} else {
+			check(os.WriteFile(*out_path, bundle, 0644))
+		}
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: abyss-keytool generate|info|export|import|passwd|bundle [flags]")
+	os.Exit(2)
+}
+
+func check(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func requireFlag(name string, value string) {
+	if value == "" {
+		check(errors.New("-" + name + " is required"))
+	}
+}
+
+func parseScheme(name string) (abyss_net.HandshakeKeyScheme, error) {
+	switch name {
+	case "migration":
+		return abyss_net.HANDSHAKE_KEY_MIGRATION, nil
+	case "hybrid":
+		return abyss_net.HANDSHAKE_KEY_HYBRID, nil
+	case "rsa":
+		return abyss_net.HANDSHAKE_KEY_RSA, nil
+	default:
+		return 0, errors.New("unknown handshake key scheme: " + name)
+	}
+}
+
+func parseAddresses(list string) ([]*net.UDPAddr, error) {
+	result := make([]*net.UDPAddr, 0)
+	for _, address_string := range strings.Split(list, ",") {
+		if address_string = strings.TrimSpace(address_string); address_string == "" {
+			continue
+		}
+		address, err := net.ResolveUDPAddr("udp", address_string)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, address)
+	}
+	return result, nil
+}
+
+var stdin_reader = bufio.NewReader(os.Stdin)
+
+// the same source answers every prompt; passwd with -passfile keeps the passphrase.
+func readPassphrase(pass_path string, prompt string) ([]byte, error) {
+	if pass_path != "" {
+		data, err := os.ReadFile(pass_path)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strings.TrimRight(string(data), "\r\n")), nil
+	}
+	if env := os.Getenv("ABYSS_KEYTOOL_PASSPHRASE"); env != "" {
+		return []byte(env), nil
+	}
+	fmt.Fprint(os.Stderr, prompt+": ")
+	line, err := stdin_reader.ReadString('\n')
+	if err != nil && line == "" {
+		return nil, err
+	}
+	return []byte(strings.TrimRight(line, "\r\n")), nil
+}
@@ -0,0 +1,280 @@
+package host
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+
+	abyss "github.com/MinwooWebeng/abyss_core/interfaces"
+)
+
+type AbystPoolConfig struct {
+	IdleTimeout             time.Duration //a connection without requests is closed after this
+	MaxStreamsPerConnection int           //concurrent requests on a connection before another one is dialed
+}
+
+func NewDefaultAbystPoolConfig() AbystPoolConfig {
+	return AbystPoolConfig{
+		IdleTimeout:             90 * time.Second,
+		MaxStreamsPerConnection: 64,
+	}
+}
+
+var ErrAbystPoolClosed = errors.New("abyst pool closed")
+
+// AbystPool keeps abyst connections per peer, for reuse across requests.
+// as an http.RoundTripper, the request URL host is the peer hash; the scheme is ignored.
+type AbystPool struct {
+	net_service abyss.INetworkService
+	transport   *http3.Transport
+	config      AbystPoolConfig
+	conns       map[string][]*abystPoolConn //peer hash
+	dials       map[string]*abystPoolDial   //in flight, by peer hash. concurrent requests wait for it instead of dialing their own
+	closed      bool
+	mtx         *sync.Mutex
+}
+
+type abystPoolConn struct {
+	peer_hash  string
+	connection quic.Connection
+	client     *http3.ClientConn
+	streams    int  //requests in flight, until the response body is closed
+	stale      bool //the abyss peer reconnected. closed once unused
+	idle_timer *time.Timer
+}
+
+type abystPoolDial struct {
+	done chan struct{} //closed when the dial ends
+	err  error
+}
+
+func NewAbystPool(net_service abyss.INetworkService, transport *http3.Transport, config AbystPoolConfig) *AbystPool {
+	return &AbystPool{
+		net_service: net_service,
+		transport:   transport,
+		config:      config,
+		conns:       make(map[string][]*abystPoolConn),
+		dials:       make(map[string]*abystPoolDial),
+		mtx:         new(sync.Mutex),
+	}
+}
+
+// applies to connections dialed later.
+func (p *AbystPool) SetConfig(config AbystPoolConfig) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	p.config = config
+}
+
+func (p *AbystPool) ConnectionCount(peer_hash string) int {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	return len(p.conns[peer_hash])
+}
+
+// a request on a reused connection that turned out dead is retried once on a new one, if its body can be replayed.
+func (p *AbystPool) RoundTrip(request *http.Request) (*http.Response, error) {
+	peer_hash := request.URL.Host
+	conn, reused, err := p.acquire(peer_hash)
+	if err != nil {
+		return nil, err
+	}
+	response, err := conn.client.RoundTrip(request)
+	if err != nil && reused && conn.connection.Context().Err() != nil && (request.Body == nil || request.GetBody != nil) {
+		p.release(conn)
+		if request.GetBody != nil {
+			if request.Body, err = request.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+		if conn, _, err = p.acquire(peer_hash); err != nil {
+			return nil, err
+		}
+		response, err = conn.client.RoundTrip(request)
+	}
+	if err != nil {
+		p.release(conn)
+		return nil, err
+	}
+	response.Body = &abystPoolBody{
+		ReadCloser: response.Body,
+		release:    func() { p.release(conn) },
+		once:       new(sync.Once),
+	}
+	return response, nil
+}
+
+// the abyss peer (re)connected. the connections dialed before are not used for new requests.
+func (p *AbystPool) PeerConnected(peer_hash string) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	for _, conn := range slices.Clone(p.conns[peer_hash]) { //removeLocked modifies the slice
+		conn.stale = true
+		if conn.streams == 0 {
+			p.removeLocked(conn)
+		}
+	}
+}
+
+// Close closes all connections. requests after Close fail.
+func (p *AbystPool) Close() {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	p.closed = true
+	for _, conns := range p.conns {
+		for _, conn := range slices.Clone(conns) {
+			p.removeLocked(conn)
+		}
+	}
+}
+
+// a usable connection, or a new one. while a connection to the peer is being dialed,
+// other requests wait for it and share it, rather than dialing their own.
+func (p *AbystPool) acquire(peer_hash string) (*abystPoolConn, bool, error) {
+	p.mtx.Lock()
+	for {
+		if p.closed {
+			p.mtx.Unlock()
+			return nil, false, ErrAbystPoolClosed
+		}
+		if conn := p.usableLocked(peer_hash); conn != nil {
+			conn.take()
+			p.mtx.Unlock()
+			return conn, true, nil
+		}
+		dial, ok := p.dials[peer_hash]
+		if !ok {
+			break
+		}
+		p.mtx.Unlock()
+		<-dial.done
+		if dial.err != nil {
+			return nil, false, dial.err
+		}
+		p.mtx.Lock()
+	}
+	dial := &abystPoolDial{done: make(chan struct{})}
+	p.dials[peer_hash] = dial
+	p.mtx.Unlock()
+
+	conn, err := p.dial(peer_hash)
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	delete(p.dials, peer_hash)
+	if err == nil && p.closed {
+		conn.connection.CloseWithError(0, "abyst pool closed")
+		conn, err = nil, ErrAbystPoolClosed
+	}
+	dial.err = err
+	close(dial.done)
+	if err != nil {
+		return nil, false, err
+	}
+	conn.take()
+	p.conns[peer_hash] = append(p.conns[peer_hash], conn)
+	return conn, false, nil
+}
+
+// called with p.mtx held. drops dead connections on the way.
+func (p *AbystPool) usableLocked(peer_hash string) *abystPoolConn {
+	for _, conn := range slices.Clone(p.conns[peer_hash]) { //removeLocked modifies the slice
+		if conn.connection.Context().Err() != nil {
+			p.removeLocked(conn)
+			continue
+		}
+		if conn.stale || conn.streams >= p.config.MaxStreamsPerConnection {
+			continue
+		}
+		return conn
+	}
+	return nil
+}
+
+func (p *AbystPool) dial(peer_hash string) (*abystPoolConn, error) {
+	connection, err := p.net_service.ConnectAbyst(peer_hash)
+	if err != nil {
+		return nil, err
+	}
+	return &abystPoolConn{
+		peer_hash:  peer_hash,
+		connection: connection,
+		client:     p.transport.NewClientConn(connection),
+	}, nil
+}
+
+func (p *AbystPool) release(conn *abystPoolConn) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	conn.streams--
+	if conn.streams != 0 {
+		return
+	}
+	if conn.stale || p.closed || conn.connection.Context().Err() != nil {
+		p.removeLocked(conn)
+		return
+	}
+	conn.idle_timer = time.AfterFunc(p.config.IdleTimeout, func() {
+		p.mtx.Lock()
+		defer p.mtx.Unlock()
+
+		if conn.streams == 0 {
+			p.removeLocked(conn)
+		}
+	})
+}
+
+// called with p.mtx held. no-op for a connection already removed.
+func (p *AbystPool) removeLocked(conn *abystPoolConn) {
+	conns := p.conns[conn.peer_hash]
+	for i, c := range conns {
+		if c != conn {
+			continue
+		}
+		conns = append(conns[:i], conns[i+1:]...)
+		if len(conns) == 0 {
+			delete(p.conns, conn.peer_hash)
+		} else {
+			p.conns[conn.peer_hash] = conns
+		}
+		if conn.idle_timer != nil {
+			conn.idle_timer.Stop()
+		}
+		conn.connection.CloseWithError(0, "abyst connection released")
+		return
+	}
+}
+
+// called with p.mtx held.
+func (c *abystPoolConn) take() {
+	c.streams++
+	if c.idle_timer != nil {
+		c.idle_timer.Stop()
+		c.idle_timer = nil
+	}
+}
+
+// releases the stream of the pooled connection once, on Close.
+type abystPoolBody struct {
+	io.ReadCloser
+	release func()
+	once    *sync.Once
+}
+
+func (b *abystPoolBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
@@ -0,0 +1,130 @@
+package host
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/MinwooWebeng/abyss_core/aurl"
+	abyss "github.com/MinwooWebeng/abyss_core/interfaces"
+)
+
+const ABYST_CONNECT_TIMEOUT = 10 * time.Second
+
+// the mapped host form, <peer hash>.abyst, for clients that need a host name. case-sensitive.
+const ABYST_HOST_SUFFIX = ".abyst"
+
+var ErrNotAbystURL = errors.New("not an abyst URL")
+
+// AbystTransport is an http.RoundTripper for peer content, over the host's abyst pool. it takes
+//
+//	abyst:<peer hash>/path
+//	abyst://<peer hash>/path
+//	https://<peer hash>.abyst/path
+//
+// a peer without a connection is dialed through the network service (resolved on the DHT if needed),
+// within ConnectTimeout. the local peer hash is served over loopback.
+type AbystTransport struct {
+	net_service    abyss.INetworkService
+	pool           *AbystPool
+	ConnectTimeout time.Duration //zero: ABYST_CONNECT_TIMEOUT
+}
+
+func NewAbystTransport(net_service abyss.INetworkService, pool *AbystPool) *AbystTransport {
+	return &AbystTransport{
+		net_service: net_service,
+		pool:        pool,
+	}
+}
+
+func (t *AbystTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	peer_hash, target, ok := ParseAbystRequestURL(request.URL)
+	if !ok {
+		if request.Body != nil {
+			request.Body.Close()
+		}
+		return nil, ErrNotAbystURL
+	}
+	if err := t.connect(request.Context(), peer_hash); err != nil {
+		if request.Body != nil {
+			request.Body.Close()
+		}
+		return nil, err
+	}
+
+	outgoing := request.Clone(request.Context())
+	outgoing.URL = target
+	outgoing.Host = peer_hash
+	return t.pool.RoundTrip(outgoing)
+}
+
+// ParseAbystRequestURL returns the peer hash, and the URL that the pool takes (https://<peer hash>/path).
+// the path keeps its escaping.
+func ParseAbystRequestURL(u *url.URL) (string, *url.URL, bool) {
+	var peer_hash string
+	var path, raw_path string
+	switch {
+	case u.Scheme == "abyst" && u.Opaque != "": //abyst:<hash>/path; the opaque part is still escaped.
+		hash, rest, _ := strings.Cut(u.Opaque, "/")
+		unescaped, err := url.PathUnescape("/" + rest)
+		if err != nil {
+			return "", nil, false
+		}
+		peer_hash, path, raw_path = hash, unescaped, "/"+rest
+	case u.Scheme == "abyst":
+		peer_hash, path, raw_path = u.Host, u.Path, u.RawPath
+	case strings.HasSuffix(u.Hostname(), ABYST_HOST_SUFFIX):
+		peer_hash, path, raw_path = strings.TrimSuffix(u.Hostname(), ABYST_HOST_SUFFIX), u.Path, u.RawPath
+	default:
+		return "", nil, false
+	}
+	if !aurl.IsValidPeerID(peer_hash) {
+		return "", nil, false
+	}
+	if path == "" {
+		path, raw_path = "/", ""
+	}
+	return peer_hash, &url.URL{
+		Scheme:   "https",
+		Host:     peer_hash,
+		Path:     path,
+		RawPath:  raw_path, //ignored by EscapedPath unless it is a valid escaping of path.
+		RawQuery: u.RawQuery,
+	}, true
+}
+
+// makes sure that the pool can dial the peer. the connection made here is pooled for the request.
+func (t *AbystTransport) connect(ctx context.Context, peer_hash string) error {
+	conn, _, err := t.pool.acquire(peer_hash)
+	if err == nil {
+		t.pool.release(conn)
+		return nil
+	}
+	if errors.Is(err, ErrAbystPoolClosed) {
+		return err
+	}
+	if err := t.net_service.ConnectAbyssAsync(&aurl.AURL{Scheme: "abyss", Hash: peer_hash}); err != nil {
+		return err
+	}
+
+	timeout := t.ConnectTimeout
+	if timeout == 0 {
+		timeout = ABYST_CONNECT_TIMEOUT
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		select {
+		case <-ctx.Done():
+			return errors.Join(errors.New("abyst: peer not reachable"), err)
+		case <-time.After(100 * time.Millisecond):
+		}
+		if conn, _, err = t.pool.acquire(peer_hash); err == nil {
+			t.pool.release(conn)
+			return nil
+		}
+	}
+}
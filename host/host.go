@@ -6,10 +6,12 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"net/http"
 	"reflect"
 	"sync"
 	"time"
 
+	"github.com/MinwooWebeng/abyss_core/abyst"
 	"github.com/MinwooWebeng/abyss_core/ahmp"
 	"github.com/MinwooWebeng/abyss_core/and"
 	"github.com/MinwooWebeng/abyss_core/aurl"
@@ -31,14 +33,24 @@ type WorldCreationEvent struct {
 
 type AbyssHost struct {
 	ctx         context.Context //set at ListenAndServe(ctx)
+	cancel      context.CancelFunc
 	listen_done chan bool
 	event_done  chan bool
+	serve_done  chan bool
 
 	NetworkService             abyss.INetworkService
 	neighborDiscoveryAlgorithm abyss.INeighborDiscovery
 	pathResolver               abyss.IPathResolver
 
 	abystClientTr *http3.Transport
+	abystPool     *AbystPool
+	abystClient   *http.Client //over abystPool, taking abyst: URLs
+	abystStreams  *abyst.StreamMux
+
+	eventCh chan any //host-wide events, not bound to a world
+
+	content_watches []*abyst.ContentServer //started at ListenAndServe
+	watch_interval  time.Duration
 
 	worlds     map[uuid.UUID]*World
 	worlds_mtx *sync.Mutex
@@ -48,21 +60,31 @@ type AbyssHost struct {
 }
 
 func NewAbyssHost(netServ abyss.INetworkService, nda abyss.INeighborDiscovery, path_resolver abyss.IPathResolver) *AbyssHost {
+	nda.SetPeerFilter(netServ.IsPeerBlocked)
+	abyst_client_transport := &http3.Transport{
+		Dial: func(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlyConnection, error) {
+			return nil, errors.New("dialing in abyst transport is prohibited")
+		},
+	}
+	abyst_pool := NewAbystPool(netServ, abyst_client_transport, NewDefaultAbystPoolConfig())
+	abyst_streams := abyst.NewStreamMux()
+	netServ.SetAbystStreamHandler(abyst_streams.Serve)
 	return &AbyssHost{
 		listen_done:                make(chan bool, 1),
 		event_done:                 make(chan bool, 1),
+		serve_done:                 make(chan bool, 1),
 		NetworkService:             netServ,
 		neighborDiscoveryAlgorithm: nda,
 		pathResolver:               path_resolver,
-		abystClientTr: &http3.Transport{
-			Dial: func(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlyConnection, error) {
-				return nil, errors.New("dialing in abyst transport is prohibited")
-			},
-		},
-		worlds:     make(map[uuid.UUID]*World),
-		worlds_mtx: new(sync.Mutex),
-		join_queue: make(map[uuid.UUID]chan *WorldCreationEvent),
-		join_q_mtx: new(sync.Mutex),
+		abystClientTr:              abyst_client_transport,
+		abystPool:                  abyst_pool,
+		abystClient:                &http.Client{Transport: NewAbystTransport(netServ, abyst_pool)},
+		abystStreams:               abyst_streams,
+		eventCh:                    make(chan any, 4096),
+		worlds:                     make(map[uuid.UUID]*World),
+		worlds_mtx:                 new(sync.Mutex),
+		join_queue:                 make(map[uuid.UUID]chan *WorldCreationEvent),
+		join_q_mtx:                 new(sync.Mutex),
 	}
 }
 
@@ -78,10 +100,15 @@ func (h *AbyssHost) GetLocalAbyssURL() *aurl.AURL {
 				return append(acc, addr)
 			},
 		),
-		Path: origin.Path,
+		Relays: origin.Relays,
+		Path:   origin.Path,
 	}
 }
 
+func (h *AbyssHost) GetEventChannel() chan any {
+	return h.eventCh
+}
+
 func (h *AbyssHost) OpenOutboundConnection(abyss_url *aurl.AURL) {
 	h.NetworkService.ConnectAbyssAsync(abyss_url)
 }
@@ -163,11 +190,49 @@ func (h *AbyssHost) GetAbystClientConnection(peer_hash string) (*http3.ClientCon
 	return h.abystClientTr.NewClientConn(conn), nil
 }
 
+// pooled abyst connections. the request URL host is the peer hash.
+func (h *AbyssHost) AbystRoundTripper() http.RoundTripper {
+	return h.abystPool
+}
+
+func (h *AbyssHost) AbystPool() *AbystPool {
+	return h.abystPool
+}
+
+// for abyst:<peer hash>/path URLs. see AbystTransport.
+func (h *AbyssHost) AbystClient() *http.Client {
+	return h.abystClient
+}
+
+// ListenAbystStream accepts the streams that peers open for protocol. needs an abyst server.
+func (h *AbyssHost) ListenAbystStream(protocol string) (*abyst.StreamListener, error) {
+	return h.abystStreams.Listen(protocol)
+}
+
+// OpenAbystStream opens a bidirectional stream to a peer that listens for protocol.
+// the peer is dialed like AbystClient does; its pooled connection is kept until the stream is done.
+func (h *AbyssHost) OpenAbystStream(ctx context.Context, peer_hash string, protocol string) (*abyst.Stream, error) {
+	if err := h.abystClient.Transport.(*AbystTransport).connect(ctx, peer_hash); err != nil {
+		return nil, err
+	}
+	conn, _, err := h.abystPool.acquire(peer_hash)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := abyst.OpenStream(ctx, conn.connection, peer_hash, protocol)
+	if err != nil {
+		h.abystPool.release(conn)
+		return nil, err
+	}
+	stream.OnDone(func() { h.abystPool.release(conn) })
+	return stream, nil
+}
+
 func (h *AbyssHost) ListenAndServe(ctx context.Context) {
 	if h.ctx != nil {
 		panic("ListenAndServe called twice")
 	}
-	h.ctx = ctx
+	h.ctx, h.cancel = context.WithCancel(ctx)
 
 	net_done := make(chan bool, 1)
 	go func() {
@@ -178,19 +243,117 @@ func (h *AbyssHost) ListenAndServe(ctx context.Context) {
 	}()
 	go h.listenLoop()
 	go h.eventLoop()
+	for _, server := range h.content_watches {
+		go server.Watch(h.ctx, h.watch_interval, h.InvalidateAssets)
+	}
 
 	<-h.listen_done
 	<-h.event_done
 
 	<-net_done
+	h.serve_done <- true
+}
+
+// WatchContent makes changes of the served content known to the members of every world (EMemberAssetInvalidate).
+// the source is polled every interval while the host serves. call before ListenAndServe.
+func (h *AbyssHost) WatchContent(server *abyst.ContentServer, interval time.Duration) {
+	h.content_watches = append(h.content_watches, server)
+	h.watch_interval = interval
+}
+
+// InvalidateAssets tells the members of every world that assets we serve have changed.
+func (h *AbyssHost) InvalidateAssets(assets []abyss.AssetChange) {
+	h.worlds_mtx.Lock()
+	worlds := make([]*World, 0, len(h.worlds))
+	for _, world := range h.worlds {
+		if world != nil {
+			worlds = append(worlds, world)
+		}
+	}
+	h.worlds_mtx.Unlock()
+
+	for _, world := range worlds {
+		world.InvalidateAssets(assets)
+	}
+}
+
+// Shutdown leaves every world, so that the members are notified, and waits for the world terminations.
+// then the network service is shut down and ListenAndServe returns.
+// ctx bounds the whole; the network service is shut down even if the worlds did not terminate in time.
+func (h *AbyssHost) Shutdown(ctx context.Context) error {
+	session_ids := make([]uuid.UUID, 0)
+	h.worlds_mtx.Lock()
+	for local_session_id, world := range h.worlds {
+		if world != nil {
+			session_ids = append(session_ids, local_session_id)
+		}
+	}
+	h.worlds_mtx.Unlock()
+	h.join_q_mtx.Lock()
+	for local_session_id := range h.join_queue {
+		session_ids = append(session_ids, local_session_id)
+	}
+	h.join_q_mtx.Unlock()
+
+	for _, local_session_id := range session_ids {
+		h.neighborDiscoveryAlgorithm.CloseWorld(local_session_id)
+	}
+	err := h.waitWorldsTerminated(ctx)
+	h.abystPool.Close()
+
+	if net_err := h.NetworkService.Shutdown(ctx); err == nil {
+		err = net_err
+	}
+
+	if h.cancel == nil { //not serving
+		return err
+	}
+	h.cancel()
+	select {
+	case <-h.serve_done:
+	case <-ctx.Done():
+		if err == nil {
+			err = ctx.Err()
+		}
+	}
+	return err
+}
+
+// the event loop removes a world on its ANDWorldLeave.
+func (h *AbyssHost) waitWorldsTerminated(ctx context.Context) error {
+	for {
+		h.worlds_mtx.Lock()
+		remaining := 0
+		for _, world := range h.worlds {
+			if world != nil {
+				remaining++
+			}
+		}
+		h.worlds_mtx.Unlock()
+		if remaining == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
 }
 
 func (h *AbyssHost) listenLoop() {
 	var wg sync.WaitGroup
 
 	accept_ch := h.NetworkService.GetAbyssPeerChannel()
+	lan_peer_ch := h.NetworkService.GetLANPeerChannel()
+	key_change_ch := h.NetworkService.GetPeerKeyChangeChannel()
 	for {
 		select {
+		case lan_peer := <-lan_peer_ch:
+			h.eventCh <- abyss.ELANPeerDiscovered{Peer: lan_peer}
+		case key_change := <-key_change_ch:
+			h.eventCh <- abyss.EPeerKeyChanged{Change: key_change}
 		case <-h.ctx.Done():
 			wg.Wait()
 			h.listen_done <- true
@@ -215,6 +378,7 @@ func (h *AbyssHost) serveLoop(peer abyss.IANDPeer) {
 	if retval != 0 {
 		return
 	}
+	h.abystPool.PeerConnected(peer.IDHash()) //abyst connections to its previous session are not reused
 
 	ahmp_channel := peer.AhmpCh()
 	for {
@@ -222,6 +386,9 @@ func (h *AbyssHost) serveLoop(peer abyss.IANDPeer) {
 		case <-h.ctx.Done():
 			return
 		case <-peer.Context().Done():
+			if len(ahmp_channel) != 0 {
+				continue //received before it closed, e.g. RST from a peer that shut down
+			}
 			//peer expired
 			fmt.Println("peer expired: " + peer.Error().Error())
 			return
@@ -254,6 +421,46 @@ func (h *AbyssHost) serveLoop(peer abyss.IANDPeer) {
 				and_result = h.neighborDiscoveryAlgorithm.SOA(message.RecverSessionID, abyss.ANDPeerSession{Peer: peer, PeerSessionID: message.SenderSessionID}, message.Objects)
 			case *ahmp.SOD:
 				and_result = h.neighborDiscoveryAlgorithm.SOD(message.RecverSessionID, abyss.ANDPeerSession{Peer: peer, PeerSessionID: message.SenderSessionID}, message.ObjectIDs)
+			case *ahmp.AIV:
+				and_result = h.neighborDiscoveryAlgorithm.AIV(message.RecverSessionID, abyss.ANDPeerSession{Peer: peer, PeerSessionID: message.SenderSessionID}, message.Assets)
+			case *ahmp.OBS:
+				if h.NetworkService.ReportObservedAddress(peer.IDHash(), message.Address) {
+					h.eventCh <- abyss.ELocalAURLChange{
+						LocalAURL: h.GetLocalAbyssURL(),
+					}
+				}
+			case *ahmp.PRQ:
+				if err := h.NetworkService.HandlePunchRequest(peer.IDHash(), message.TargetHash); err != nil {
+					watchdog.Error(err)
+				}
+			case *ahmp.RLQ:
+				if err := h.NetworkService.HandleRelayRequest(peer.IDHash(), message.TargetHash); err != nil {
+					watchdog.Error(err)
+				}
+			case *ahmp.DFQ:
+				if err := h.NetworkService.HandleDHTQuery(peer.IDHash(), message.QueryID, message.TargetHash); err != nil {
+					watchdog.Error(err)
+				}
+			case *ahmp.DFR:
+				if err := h.NetworkService.HandleDHTResponse(peer.IDHash(), message.QueryID, message.Records); err != nil {
+					watchdog.Error(err)
+				}
+			case *ahmp.DST:
+				if err := h.NetworkService.HandleDHTStore(peer.IDHash(), message.Record); err != nil {
+					watchdog.Error(err)
+				}
+			case *ahmp.HKR:
+				if err := h.NetworkService.HandleHandshakeKeyRotation(peer.IDHash(), message.HandshakeKeyCertificateDer); err != nil {
+					watchdog.Error(err)
+				}
+			case *ahmp.DRV:
+				if err := h.NetworkService.HandleDeviceRevocation(message.Revocation); err != nil {
+					watchdog.Error(err)
+				}
+			case *ahmp.PNC:
+				if err := h.NetworkService.HandlePunchNotice(peer.IDHash(), message.PeerHash, message.RootCertificateDer, message.HandshakeKeyCertificateDer, message.Addresses, message.Delay); err != nil {
+					watchdog.Error(err)
+				}
 			case *ahmp.INVAL:
 				//parsing fail
 				watchdog.Error(message.Err)
@@ -381,7 +588,11 @@ func (h *AbyssHost) eventLoop() {
 				}
 			case abyss.ANDConnectRequest:
 				//fmt.Println(h.NetworkService.LocalIdentity().IDHash()[:6] + " event ::: abyss.ANDConnectRequest")
-				h.NetworkService.ConnectAbyssAsync(e.Object.(*aurl.AURL))
+				if e.Peer != nil { //introduced by a mutual member, which can assist hole punching
+					h.NetworkService.ConnectAbyssRendezvousAsync(e.Object.(*aurl.AURL), e.Peer.IDHash())
+				} else {
+					h.NetworkService.ConnectAbyssAsync(e.Object.(*aurl.AURL))
+				}
 			case abyss.ANDTimerRequest:
 				//fmt.Println(h.NetworkService.LocalIdentity().IDHash()[:6] + " event ::: abyss.ANDTimerRequest: " + strconv.Itoa(e.Value))
 				target_local_session := e.LocalSessionID
@@ -426,6 +637,18 @@ func (h *AbyssHost) eventLoop() {
 				e.Peer.Renew()
 				world.RaiseObjectDelete(e.Peer.IDHash(), e.Object.([]uuid.UUID))
 
+			case abyss.ANDAssetInvalidate:
+				h.worlds_mtx.Lock()
+				world, ok := h.worlds[e.LocalSessionID]
+				h.worlds_mtx.Unlock()
+
+				if !ok {
+					panic("world not found")
+				}
+
+				e.Peer.Renew()
+				world.RaiseAssetInvalidate(e.Peer.IDHash(), e.Object.([]abyss.AssetChange))
+
 			case abyss.ANDNeighborEventDebug:
 				//fmt.Println(h.NetworkService.LocalIdentity().IDHash()[:6] + " event ::: abyss.ANDNeighborEventDebug")
 				fmt.Println(time.Now().Format("00:00:00.000") + " " + e.Text)
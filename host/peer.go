@@ -15,6 +15,12 @@ type WorldMember struct {
 func (p *WorldMember) Hash() string {
 	return p.hash
 }
+func (p *WorldMember) OwnerHash() string {
+	return p.peerSession.Peer.OwnerHash()
+}
+func (p *WorldMember) DeviceID() string {
+	return p.peerSession.Peer.DeviceID()
+}
 func (p *WorldMember) SessionID() uuid.UUID {
 	return p.peerSession.PeerSessionID
 }
@@ -24,3 +30,6 @@ func (p *WorldMember) AppendObjects(objects []abyss.ObjectInfo) bool {
 func (p *WorldMember) DeleteObjects(objectIDs []uuid.UUID) bool {
 	return p.peerSession.Peer.TrySendSOD(p.world.session_id, p.peerSession.PeerSessionID, objectIDs)
 }
+func (p *WorldMember) InvalidateAssets(assets []abyss.AssetChange) bool {
+	return p.peerSession.Peer.TrySendAIV(p.world.session_id, p.peerSession.PeerSessionID, assets)
+}
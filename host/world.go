@@ -1,6 +1,8 @@
 package host
 
 import (
+	"sync"
+
 	abyss "github.com/MinwooWebeng/abyss_core/interfaces"
 
 	"github.com/google/uuid"
@@ -11,6 +13,9 @@ type World struct {
 	session_id   uuid.UUID
 	url          string
 	eventChannel chan any
+
+	members     map[string]abyss.ANDPeerSession //peer hash, from member ready to leave
+	members_mtx *sync.Mutex
 }
 
 func NewWorld(origin abyss.INeighborDiscovery, session_id uuid.UUID, url string) *World {
@@ -19,6 +24,8 @@ func NewWorld(origin abyss.INeighborDiscovery, session_id uuid.UUID, url string)
 		session_id:   session_id,
 		url:          url,
 		eventChannel: make(chan any, 4096),
+		members:      make(map[string]abyss.ANDPeerSession),
+		members_mtx:  new(sync.Mutex),
 	}
 }
 
@@ -27,10 +34,37 @@ func (w *World) URL() string          { return w.url }
 func (w *World) GetEventChannel() chan any {
 	return w.eventChannel
 }
+func (w *World) IsMember(peer_hash string) bool {
+	w.members_mtx.Lock()
+	defer w.members_mtx.Unlock()
+
+	_, ok := w.members[peer_hash]
+	return ok
+}
+
+// InvalidateAssets tells every member that assets we serve have changed. false if any send failed.
+func (w *World) InvalidateAssets(assets []abyss.AssetChange) bool {
+	w.members_mtx.Lock()
+	sessions := make([]abyss.ANDPeerSession, 0, len(w.members))
+	for _, peer_session := range w.members {
+		sessions = append(sessions, peer_session)
+	}
+	w.members_mtx.Unlock()
+
+	result := true
+	for _, peer_session := range sessions {
+		if !peer_session.Peer.TrySendAIV(w.session_id, peer_session.PeerSessionID, assets) {
+			result = false
+		}
+	}
+	return result
+}
 
 func (w *World) RaisePeerRequest(peer_session abyss.ANDPeerSession) {
 	w.eventChannel <- abyss.EWorldMemberRequest{
-		MemberHash: peer_session.Peer.IDHash(),
+		MemberHash:      peer_session.Peer.IDHash(),
+		MemberOwnerHash: peer_session.Peer.OwnerHash(),
+		MemberDeviceID:  peer_session.Peer.DeviceID(),
 		Accept: func() {
 			w.origin.AcceptSession(w.session_id, peer_session)
 		},
@@ -40,6 +74,10 @@ func (w *World) RaisePeerRequest(peer_session abyss.ANDPeerSession) {
 	}
 }
 func (w *World) RaisePeerReady(peer_session abyss.ANDPeerSession) {
+	w.members_mtx.Lock()
+	w.members[peer_session.Peer.IDHash()] = peer_session
+	w.members_mtx.Unlock()
+
 	w.eventChannel <- abyss.EWorldMemberReady{
 		Member: &WorldMember{
 			world:       w,
@@ -60,11 +98,25 @@ func (w *World) RaiseObjectDelete(peer_hash string, objectIDs []uuid.UUID) {
 		ObjectIDs: objectIDs,
 	}
 }
+func (w *World) RaiseAssetInvalidate(peer_hash string, assets []abyss.AssetChange) {
+	w.eventChannel <- abyss.EMemberAssetInvalidate{
+		PeerHash: peer_hash,
+		Assets:   assets,
+	}
+}
 func (w *World) RaisePeerLeave(peer_hash string) {
+	w.members_mtx.Lock()
+	delete(w.members, peer_hash)
+	w.members_mtx.Unlock()
+
 	w.eventChannel <- abyss.EWorldMemberLeave{
 		PeerHash: peer_hash,
 	}
 }
 func (w *World) RaiseWorldTerminate() {
+	w.members_mtx.Lock()
+	w.members = make(map[string]abyss.ANDPeerSession)
+	w.members_mtx.Unlock()
+
 	w.eventChannel <- abyss.EWorldTerminate{}
 }
@@ -16,13 +16,14 @@ const (
 	ANDSessionClose
 	ANDJoinSuccess
 	ANDJoinFail
-	ANDWorldLeave //called after WorldLeave
-	ANDConnectRequest
+	ANDWorldLeave     //called after WorldLeave
+	ANDConnectRequest //Peer, if set, is the member that introduced the target
 	ANDTimerRequest
 	ANDPeerRegister
 
 	ANDObjectAppend
 	ANDObjectDelete
+	ANDAssetInvalidate
 	ANDNeighborEventDebug
 )
 
@@ -52,6 +53,7 @@ type INeighborDiscovery interface { // all calls must be thread-safe
 	EventChannel() chan NeighborEvent
 
 	//calls
+	SetPeerFilter(is_blocked func(peer_hash string) bool) //members for which it returns true are never dialed
 	PeerConnected(peer IANDPeer) ANDERROR
 	PeerClose(peer IANDPeer) ANDERROR
 	OpenWorld(local_session_id uuid.UUID, world_url string) ANDERROR
@@ -73,4 +75,5 @@ type INeighborDiscovery interface { // all calls must be thread-safe
 
 	SOA(local_session_id uuid.UUID, peer_session ANDPeerSession, objects []ObjectInfo) ANDERROR
 	SOD(local_session_id uuid.UUID, peer_session ANDPeerSession, objectIDs []uuid.UUID) ANDERROR
+	AIV(local_session_id uuid.UUID, peer_session ANDPeerSession, assets []AssetChange) ANDERROR
 }
@@ -34,6 +34,8 @@ type ANDFullPeerSessionIdentity struct {
 
 type IANDPeer interface {
 	IDHash() string
+	OwnerHash() string //shared by the devices of one owner. IDHash() if the peer is not a device.
+	DeviceID() string  //empty if the peer is not a device.
 	RootCertificateDer() []byte
 	HandshakeKeyCertificateDer() []byte
 
@@ -60,4 +62,5 @@ type IANDPeer interface {
 
 	TrySendSOA(local_session_id uuid.UUID, peer_session_id uuid.UUID, objects []ObjectInfo) bool
 	TrySendSOD(local_session_id uuid.UUID, peer_session_id uuid.UUID, objectIDs []uuid.UUID) bool
+	TrySendAIV(local_session_id uuid.UUID, peer_session_id uuid.UUID, assets []AssetChange) bool
 }
@@ -2,6 +2,7 @@ package interfaces
 
 import (
 	"context"
+	"net/http"
 
 	"github.com/MinwooWebeng/abyss_core/aurl"
 
@@ -19,17 +20,28 @@ type ObjectInfo struct {
 	Transform [7]float32
 }
 
+// an abyst asset that changed on the member that serves it.
+type AssetChange struct {
+	Path string
+	ETag string //empty if the asset was removed.
+}
+
 type IWorldMember interface {
 	Hash() string
+	OwnerHash() string //devices of one owner share it. Hash() if the member is not a device.
+	DeviceID() string  //empty if the member is not a device.
 	SessionID() uuid.UUID
 	AppendObjects(objects []ObjectInfo) bool
 	DeleteObjects(objectIDs []uuid.UUID) bool
+	InvalidateAssets(assets []AssetChange) bool
 }
 
 type EWorldMemberRequest struct {
-	MemberHash string
-	Accept     func()
-	Decline    func(code int, message string)
+	MemberHash      string
+	MemberOwnerHash string
+	MemberDeviceID  string
+	Accept          func()
+	Decline         func(code int, message string)
 }
 type EWorldMemberReady struct {
 	Member IWorldMember
@@ -42,19 +54,38 @@ type EMemberObjectDelete struct {
 	PeerHash  string
 	ObjectIDs []uuid.UUID
 }
+type EMemberAssetInvalidate struct { //re-fetch the listed paths from the member.
+	PeerHash string
+	Assets   []AssetChange
+}
 type EWorldMemberLeave struct { //now, the peer must be closed as soon as possible.
 	PeerHash string
 }
 type EWorldTerminate struct{}
 
+type ELocalAURLChange struct { //local address candidates have changed, e.g. a public address was discovered.
+	LocalAURL *aurl.AURL
+}
+
+type ELANPeerDiscovered struct { //a new peer, or a known one with new addresses, announced itself on the LAN.
+	Peer LANPeer
+}
+
+type EPeerKeyChanged struct { //trust-on-first-use warning. see PeerKeyChange.Accepted.
+	Change PeerKeyChange
+}
+
 type IAbyssWorld interface {
 	SessionID() uuid.UUID
 	URL() string
 	GetEventChannel() chan any
+	IsMember(peer_hash string) bool //a ready member, until it leaves
+	InvalidateAssets(assets []AssetChange) bool
 }
 
 type IAbyssHost interface {
 	GetLocalAbyssURL() *aurl.AURL
+	GetEventChannel() chan any //host-wide events (ELocalAURLChange, ELANPeerDiscovered, EPeerKeyChanged)
 
 	OpenOutboundConnection(abyss_url *aurl.AURL)
 
@@ -65,5 +96,9 @@ type IAbyssHost interface {
 	// Each world should wait for its world termination event.
 
 	//Abyst
-	GetAbystClientConnection(peer_hash string) (*http3.ClientConn, error)
+	GetAbystClientConnection(peer_hash string) (*http3.ClientConn, error) //a new connection each call
+	AbystRoundTripper() http.RoundTripper                                 //pooled connections, reused per peer. the request URL host is the peer hash.
+	AbystClient() *http.Client                                            //takes abyst:<peer hash>/path URLs, and connects to the peer if needed.
+
+	Shutdown(ctx context.Context) error //leaves all worlds, then shuts down the network service. ListenAndServe returns after.
 }
@@ -2,6 +2,8 @@ package interfaces
 
 type IHostIdentity interface {
 	IDHash() string
+	OwnerHash() string               //IDHash() unless this is a device identity
+	DeviceID() string                //empty unless this is a device identity
 	RootCertificate() string         //pem
 	HandshakeKeyCertificate() string //pem
 }
@@ -1,10 +1,13 @@
 package interfaces
 
 import (
+	"context"
 	"net"
+	"time"
 
 	"github.com/MinwooWebeng/abyss_core/aurl"
 
+	"github.com/google/uuid"
 	"github.com/quic-go/quic-go"
 )
 
@@ -22,24 +25,87 @@ type AbystInboundSession struct {
 type INetworkService interface {
 	LocalIdentity() IHostIdentity
 	LocalAURL() *aurl.AURL
+	TLSIdentityExpiry() time.Time //renewed automatically before this
 
 	HandlePreAccept(preaccept_handler IPreAccepter) // if false, return status code and message
 
 	ListenAndServe() error
+	Shutdown(ctx context.Context) error //peers get the pending messages and an ABYSS_SHUTDOWN close. ListenAndServe then returns nil.
 
 	AppendKnownPeer(root_cert string, handshake_key_cert string) error
 	AppendKnownPeerDer(root_cert []byte, handshake_key_cert []byte) error
+	GetPeerKeyChangeChannel() chan PeerKeyChange //handshake key changes of known peers (trust-on-first-use)
+
+	//handshake key rotation. the previous key stays valid for the grace window, for in-flight dials.
+	RotateHandshakeKey(grace time.Duration) error
+	HandleHandshakeKeyRotation(peer_hash string, handshake_key_cert []byte) error
+
+	//devices of an owner identity. a revocation is passed on to connected peers.
+	RevokeDevice(device_hash string) error //only the owner can revoke its devices.
+	HandleDeviceRevocation(revocation DeviceRevocation) error
+
+	//blocklist. banned peers are disconnected, refused, and never dialed.
+	BlockPeer(peer_hash string) error
+	UnblockPeer(peer_hash string) error
+	BlockNetwork(cidr string) error //also takes a single IP address
+	UnblockNetwork(cidr string) error
+	IsPeerBlocked(peer_hash string) bool
 
 	GetAbyssPeerChannel() chan IANDPeer //wait for established abyss mutual connection
 
-	ConnectAbyssAsync(url *aurl.AURL) error                 //may return error if peer information has expired.
-	ConnectAbyst(peer_hash string) (quic.Connection, error) //should take ~2 rtt.
+	ReportObservedAddress(peer_hash string, address *net.UDPAddr) bool //returns true if LocalAURL() has changed.
+
+	ConnectAbyssAsync(url *aurl.AURL) error                                   //may return error if peer information has expired.
+	ConnectAbyssRendezvousAsync(url *aurl.AURL, rendezvous_hash string) error //falls back to hole punching through the rendezvous peer.
+	ConnectAbyst(peer_hash string) (quic.Connection, error)                   //should take ~2 rtt.
+	SetAbystStreamHandler(handler func(stream quic.Stream, peer_hash string)) //non-request streams on accepted abyst connections. needs an abyst server.
+
+	//hole punching
+	HandlePunchRequest(requester_hash string, target_hash string) error
+	HandlePunchNotice(rendezvous_hash string, peer_hash string, root_cert []byte, handshake_key_cert []byte, addresses []*net.UDPAddr, delay time.Duration) error
+
+	//relaying. the relay answers with a punch notice carrying the relay address.
+	HandleRelayRequest(requester_hash string, target_hash string) error
+
+	//LAN discovery (opt-in)
+	StartLANDiscovery(auto_register bool) error
+	GetLANPeerChannel() chan LANPeer
+
+	//DHT. keyed by peer hash, holding signed peer records.
+	ResolvePeer(ctx context.Context, peer_hash string) (*aurl.AURL, error) //also registers the peer as known.
+	HandleDHTQuery(peer_hash string, query_id uuid.UUID, target_hash string) error
+	HandleDHTResponse(peer_hash string, query_id uuid.UUID, records []SignedPeerRecord) error
+	HandleDHTStore(peer_hash string, record SignedPeerRecord) error
+}
+
+type SignedPeerRecord struct { //a peer's AURL and timestamp (Body), signed by its root key.
+	Body                       []byte
+	Signature                  []byte
+	RootCertificateDer         []byte
+	HandshakeKeyCertificateDer []byte
+}
+
+type DeviceRevocation struct { //revoked device hash and timestamp (Body), signed by the owner root key.
+	Body                    []byte
+	Signature               []byte
+	OwnerRootCertificateDer []byte
+}
+
+type LANPeer struct { //announced on the local network. the announcement signature is verified.
+	AURL                       *aurl.AURL
+	RootCertificateDer         []byte
+	HandshakeKeyCertificateDer []byte
 }
 
 type IAddressSelector interface {
 	LocalPrivateIPAddr() net.IP
+	SetPublicIP(ip net.IP)
 	FilterAddressCandidates(addresses []*net.UDPAddr) []*net.UDPAddr
 }
 
 // TLS ALPN code
 const NextProtoAbyss = "abyss"
+
+// TLS ALPN code for the handshake in which the dialer also introduces itself with its certificates.
+// preferred over NextProtoAbyss; peers without it negotiate NextProtoAbyss and the bare handshake.
+const NextProtoAbyss2 = "abyss/2"
@@ -0,0 +1,28 @@
+package interfaces
+
+import (
+	"net"
+	"time"
+)
+
+type KnownPeer struct { //a peer certificate pair, as first learned, and how it was last reached.
+	RootCertificateDer         []byte
+	HandshakeKeyCertificateDer []byte
+	Addresses                  []*net.UDPAddr //last-known, most recent first
+	FirstSeen                  time.Time
+	LastSeen                   time.Time
+}
+
+// IPeerStore persists known peers across restarts. keyed by peer hash.
+type IPeerStore interface {
+	Get(peer_hash string) (KnownPeer, bool)
+	Put(peer_hash string, peer KnownPeer) error
+	All() map[string]KnownPeer
+}
+
+type PeerKeyChange struct { //a known peer presented a different handshake key.
+	PeerHash                           string
+	PreviousHandshakeKeyCertificateDer []byte
+	HandshakeKeyCertificateDer         []byte
+	Accepted                           bool //false if rejected by policy
+}
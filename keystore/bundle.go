@@ -0,0 +1,83 @@
+package keystore
+
+import (
+	"bytes"
+	"encoding/pem"
+	"errors"
+	"net"
+
+	"github.com/MinwooWebeng/abyss_core/aurl"
+	abyss "github.com/MinwooWebeng/abyss_core/interfaces"
+	abyss_net "github.com/MinwooWebeng/abyss_core/net_service"
+)
+
+// PeerBundle is what a peer needs to know and dial an identity: its AURL and certificates.
+// encoded as the two certificates in pem; the root certificate block carries the AURL as a header.
+type PeerBundle struct {
+	AURL                    *aurl.AURL
+	RootCertificate         string //pem
+	HandshakeKeyCertificate string //pem
+}
+
+func NewPeerBundle(identity abyss.IHostIdentity, addresses []*net.UDPAddr) *PeerBundle {
+	return &PeerBundle{
+		AURL: &aurl.AURL{
+			Scheme:    "abyss",
+			Hash:      identity.IDHash(),
+			Addresses: addresses,
+		},
+		RootCertificate:         identity.RootCertificate(),
+		HandshakeKeyCertificate: identity.HandshakeKeyCertificate(),
+	}
+}
+
+func (b *PeerBundle) Encode() ([]byte, error) {
+	root_block, _ := pem.Decode([]byte(b.RootCertificate))
+	handshake_block, _ := pem.Decode([]byte(b.HandshakeKeyCertificate))
+	if root_block == nil || handshake_block == nil {
+		return nil, errors.New("invalid certificate")
+	}
+	root_block.Headers = map[string]string{"AURL": b.AURL.ToString()}
+
+	var result bytes.Buffer
+	if err := pem.Encode(&result, root_block); err != nil {
+		return nil, err
+	}
+	if err := pem.Encode(&result, handshake_block); err != nil {
+		return nil, err
+	}
+	return result.Bytes(), nil
+}
+
+func ParsePeerBundle(data []byte) (*PeerBundle, error) {
+	root_block, rest := pem.Decode(data)
+	handshake_block, _ := pem.Decode(rest)
+	if root_block == nil || handshake_block == nil {
+		return nil, errors.New("invalid peer bundle")
+	}
+	peer_aurl, err := aurl.TryParse(root_block.Headers["AURL"])
+	if err != nil {
+		return nil, err
+	}
+	if peer_aurl.Scheme != "abyss" {
+		return nil, errors.New("invalid peer bundle AURL")
+	}
+	peer_identity, err := abyss_net.NewPeerIdentity(root_block.Bytes, handshake_block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if peer_identity.IDHash() != peer_aurl.Hash {
+		return nil, errors.New("peer bundle hash mismatch")
+	}
+	root_block.Headers = nil
+	return &PeerBundle{
+		AURL:                    peer_aurl,
+		RootCertificate:         string(pem.EncodeToMemory(root_block)),
+		HandshakeKeyCertificate: string(pem.EncodeToMemory(handshake_block)),
+	}, nil
+}
+
+// Register makes the bundled peer known. dial it with ConnectAbyssAsync(b.AURL).
+func (b *PeerBundle) Register(net_service abyss.INetworkService) error {
+	return net_service.AppendKnownPeer(b.RootCertificate, b.HandshakeKeyCertificate)
+}
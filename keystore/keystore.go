@@ -0,0 +1,244 @@
+// Package keystore keeps an abyss identity (root key, certificates and handshake key)
+// in a passphrase-encrypted file.
+package keystore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+
+	"github.com/fxamacker/cbor/v2"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/ssh"
+
+	abyss_net "github.com/MinwooWebeng/abyss_core/net_service"
+)
+
+const PEM_TYPE = "ABYSS ENCRYPTED IDENTITY"
+
+// argon2id parameters for new keystores. the ones used are stored in the file.
+const (
+	ARGON2_TIME    = 3
+	ARGON2_MEMORY  = 64 * 1024 //KiB
+	ARGON2_THREADS = 4
+)
+
+// the largest argon2id parameters a keystore file may ask for, so that a crafted file cannot stall or exhaust the loader.
+const (
+	ARGON2_MAX_TIME    = 16
+	ARGON2_MAX_MEMORY  = 1024 * 1024 //KiB
+	ARGON2_MAX_THREADS = 16
+)
+
+var ErrWrongPassphrase = errors.New("wrong passphrase or corrupted keystore")
+
+type rawKeystore struct { //PEM body
+	KDF        string //"argon2id"
+	Salt       []byte
+	Time       uint32
+	Memory     uint32
+	Threads    uint8
+	Nonce      []byte //AES-256-GCM
+	Ciphertext []byte //rawIdentity
+}
+
+type rawIdentity struct {
+	PrivateKey    []byte //PKCS #8
+	IdentityState []byte //RootSecrets.ExportState
+}
+
+type Keystore struct {
+	PrivateKey abyss_net.PrivateKey
+	Identity   *abyss_net.RootSecrets
+}
+
+// key_type: "ed25519" (default if empty), "P-256", "P-384" or "RSA".
+func GenerateKey(key_type string) (abyss_net.PrivateKey, error) {
+	var key any
+	var err error
+	switch key_type {
+	case "", "ed25519":
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	case "P-256":
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "P-384":
+		key, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "RSA":
+		key, err = rsa.GenerateKey(rand.Reader, 3072)
+	default:
+		return nil, errors.New("unsupported key type: " + key_type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return abyss_net.CheckRootKey(key)
+}
+
+// New makes a fresh identity for the key.
+func New(private_key abyss_net.PrivateKey, scheme abyss_net.HandshakeKeyScheme) (*Keystore, error) {
+	identity, err := abyss_net.NewRootIdentityWithScheme(private_key, scheme)
+	if err != nil {
+		return nil, err
+	}
+	return &Keystore{
+		PrivateKey: private_key,
+		Identity:   identity,
+	}, nil
+}
+
+// ExportPrivateKey returns the root key alone, unencrypted, as a PKCS #8 pem.
+func (k *Keystore) ExportPrivateKey() ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(k.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// ImportPrivateKey reads an unencrypted root key, in PKCS #8, SEC 1, PKCS #1 or OpenSSH pem.
+func ImportPrivateKey(data []byte) (abyss_net.PrivateKey, error) {
+	key, err := ssh.ParseRawPrivateKey(data)
+	if err != nil {
+		return nil, err
+	}
+	return abyss_net.CheckRootKey(key)
+}
+
+// Configure makes the network service use this identity.
+func (k *Keystore) Configure(config *abyss_net.BetaNetServiceConfig) error {
+	state, err := k.Identity.ExportState()
+	if err != nil {
+		return err
+	}
+	config.IdentityState = state
+	return nil
+}
+
+func (k *Keystore) Encrypt(passphrase []byte) ([]byte, error) {
+	private_key_der, err := x509.MarshalPKCS8PrivateKey(k.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	state, err := k.Identity.ExportState()
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := cbor.Marshal(rawIdentity{
+		PrivateKey:    private_key_der,
+		IdentityState: state,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	raw := rawKeystore{
+		KDF:     "argon2id",
+		Salt:    make([]byte, 16),
+		Time:    ARGON2_TIME,
+		Memory:  ARGON2_MEMORY,
+		Threads: ARGON2_THREADS,
+	}
+	if _, err := rand.Read(raw.Salt); err != nil {
+		return nil, err
+	}
+	aesGCM, err := raw.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	raw.Nonce = make([]byte, aesGCM.NonceSize())
+	if _, err := rand.Read(raw.Nonce); err != nil {
+		return nil, err
+	}
+	raw.Ciphertext = aesGCM.Seal(nil, raw.Nonce, plaintext, []byte(PEM_TYPE))
+
+	body, err := cbor.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: PEM_TYPE, Bytes: body}), nil
+}
+
+func Decrypt(data []byte, passphrase []byte) (*Keystore, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != PEM_TYPE {
+		return nil, errors.New("not an abyss keystore")
+	}
+	var raw rawKeystore
+	if err := cbor.Unmarshal(block.Bytes, &raw); err != nil {
+		return nil, err
+	}
+	if raw.KDF != "argon2id" {
+		return nil, errors.New("unsupported key derivation: " + raw.KDF)
+	}
+	aesGCM, err := raw.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw.Nonce) != aesGCM.NonceSize() {
+		return nil, errors.New("invalid nonce")
+	}
+	plaintext, err := aesGCM.Open(nil, raw.Nonce, raw.Ciphertext, []byte(PEM_TYPE))
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+
+	var identity rawIdentity
+	if err := cbor.Unmarshal(plaintext, &identity); err != nil {
+		return nil, err
+	}
+	parsed_key, err := x509.ParsePKCS8PrivateKey(identity.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	private_key, err := abyss_net.CheckRootKey(parsed_key)
+	if err != nil {
+		return nil, err
+	}
+	root_secrets, err := abyss_net.RestoreRootIdentity(private_key, identity.IdentityState)
+	if err != nil {
+		return nil, err
+	}
+	return &Keystore{
+		PrivateKey: private_key,
+		Identity:   root_secrets,
+	}, nil
+}
+
+func (k *Keystore) Save(path string, passphrase []byte) error {
+	data, err := k.Encrypt(passphrase)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+func Load(path string, passphrase []byte) (*Keystore, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Decrypt(data, passphrase)
+}
+
+func (r *rawKeystore) aead(passphrase []byte) (cipher.AEAD, error) {
+	if r.Time == 0 || r.Memory == 0 || r.Threads == 0 {
+		return nil, errors.New("invalid key derivation parameters")
+	}
+	if r.Time > ARGON2_MAX_TIME || r.Memory > ARGON2_MAX_MEMORY || r.Threads > ARGON2_MAX_THREADS {
+		return nil, errors.New("key derivation parameters too large")
+	}
+	key := argon2.IDKey(passphrase, r.Salt, r.Time, r.Memory, r.Threads, 32)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -12,18 +12,23 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
+	"net"
 	"net/http"
 	"os"
-	"path/filepath"
 	"runtime/cgo"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/MinwooWebeng/abyss_core/abyst"
 	"github.com/MinwooWebeng/abyss_core/watchdog"
 
 	"github.com/MinwooWebeng/abyss_core/tools/functional"
 
 	abyss_host "github.com/MinwooWebeng/abyss_core/host"
 
+	"github.com/MinwooWebeng/abyss_core/keystore"
 	abyss_net "github.com/MinwooWebeng/abyss_core/net_service"
 
 	abyss_and "github.com/MinwooWebeng/abyss_core/and"
@@ -39,6 +44,9 @@ import (
 
 const version = "0.9.0"
 
+// how often the content of NewSimpleAbystServer and NewArchiveAbystServer is checked for changes.
+const CONTENT_WATCH_INTERVAL = 2 * time.Second
+
 // return value (C.int)
 const (
 	EOF               = -1
@@ -150,46 +158,202 @@ func SimplePathResolver_DeleteMapping(h C.uintptr_t, path_ptr *C.char, path_len
 	return 0
 }
 
+// serves the directory; "/" is main.aml. no manifest unless AbystServer_SetManifestPath.
+//
 //export NewSimpleAbystServer
 func NewSimpleAbystServer(path_ptr *C.char, path_len C.int) C.uintptr_t {
 	path_buf, ok := TryUnmarshalBytes(path_ptr, path_len)
 	if !ok {
 		return 0
 	}
-	path := string(path_buf)
 	watchdog.CountHandleExport()
 	return C.uintptr_t(cgo.NewHandle(&http3.Server{
-		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if r.URL.Path == "/" {
-				// Serve main.aml for root path
-				http.ServeFile(w, r, filepath.Join(path, "main.aml"))
-				return
-			}
+		Handler: abyst.NewContentServer(os.DirFS(string(path_buf))),
+	}))
+}
 
-			// Construct the full file path
-			fullPath := filepath.Join(path, r.URL.Path)
+// serves a .zip, .tar, .tar.gz or .tgz archive like NewSimpleAbystServer. a zip archive stays open for the process lifetime.
+//
+//export NewArchiveAbystServer
+func NewArchiveAbystServer(path_ptr *C.char, path_len C.int, err_out *C.uintptr_t) C.uintptr_t {
+	path_buf, ok := TryUnmarshalBytes(path_ptr, path_len)
+	if !ok {
+		*err_out = marshalError(errors.New("archive path required"))
+		return 0
+	}
+	source, _, err := abyst.OpenArchiveSource(string(path_buf))
+	if err != nil {
+		*err_out = marshalError(err)
+		return 0
+	}
+	watchdog.CountHandleExport()
+	return C.uintptr_t(cgo.NewHandle(&http3.Server{
+		Handler: abyst.NewContentServer(source),
+	}))
+}
 
-			// Check if it's a directory
-			info, err := os.Stat(fullPath)
-			if err != nil || info.IsDir() {
-				http.NotFound(w, r)
-				return
-			}
+// forwards peer requests to a local HTTP backend, e.g. http://127.0.0.1:8080, with the peer hash in X-Abyss-Peer-Hash.
+// only requests under path_prefix are forwarded, without the prefix; empty for all. h2c: 1 for an unencrypted HTTP/2 backend.
+//
+//export NewProxyAbystServer
+func NewProxyAbystServer(backend_ptr *C.char, backend_len C.int, path_prefix_ptr *C.char, path_prefix_len C.int, h2c C.int, err_out *C.uintptr_t) C.uintptr_t {
+	backend_buf, ok := TryUnmarshalBytes(backend_ptr, backend_len)
+	if !ok {
+		*err_out = marshalError(errors.New("backend required"))
+		return 0
+	}
+	path_prefix_buf, _ := TryUnmarshalBytes(path_prefix_ptr, path_prefix_len)
 
-			// Serve the file normally
-			http.FileServer(http.Dir(path)).ServeHTTP(w, r)
-		}),
+	proxy, err := abyst.NewReverseProxy(abyst.ProxyConfig{
+		Backend:          string(backend_buf),
+		PathPrefix:       string(path_prefix_buf),
+		UnencryptedHTTP2: h2c != 0,
+	})
+	if err != nil {
+		*err_out = marshalError(err)
+		return 0
+	}
+	watchdog.CountHandleExport()
+	return C.uintptr_t(cgo.NewHandle(&http3.Server{
+		Handler: proxy,
 	}))
 }
 
-//export NewHost
-func NewHost(root_priv_key_pem_ptr *C.char, root_priv_key_pem_len C.int, h_path_resolver C.uintptr_t, h_abyst_server C.uintptr_t) C.uintptr_t {
-	abyst_server, ok := cgo.Handle(h_abyst_server).Value().(*http3.Server)
+// the cache downloads over the host's abyst client.
+//
+//export NewAssetCache
+func NewAssetCache(h_host C.uintptr_t, dir_ptr *C.char, dir_len C.int, err_out *C.uintptr_t) C.uintptr_t {
+	host, ok := cgo.Handle(h_host).Value().(*abyss_host.AbyssHost)
 	if !ok {
-		watchdog.Error(errors.New("invalid handle for abyst_server"))
+		*err_out = marshalError(errors.New("invalid handle"))
+		return 0
+	}
+	dir_buf, ok := TryUnmarshalBytes(dir_ptr, dir_len)
+	if !ok {
+		*err_out = marshalError(errors.New("cache directory required"))
 		return 0
 	}
 
+	cache, err := abyst.NewAssetCache(string(dir_buf), host.AbystClient())
+	if err != nil {
+		*err_out = marshalError(err)
+		return 0
+	}
+	watchdog.CountHandleExport()
+	return C.uintptr_t(cgo.NewHandle(cache))
+}
+
+// members share their cached assets through a server from NewSimpleAbystServer or NewArchiveAbystServer.
+//
+//export AbystServer_SetAssetCache
+func AbystServer_SetAssetCache(h_server C.uintptr_t, h_cache C.uintptr_t) C.int {
+	server, ok := cgo.Handle(h_server).Value().(*http3.Server)
+	if !ok {
+		return INVALID_HANDLE
+	}
+	content_server, ok := server.Handler.(*abyst.ContentServer)
+	if !ok {
+		return INVALID_ARGUMENTS
+	}
+	cache, ok := cgo.Handle(h_cache).Value().(*abyst.AssetCache)
+	if !ok {
+		return INVALID_HANDLE
+	}
+
+	content_server.SetCache(cache)
+	return 0
+}
+
+// publishes the manifest of a server from NewSimpleAbystServer or NewArchiveAbystServer at path, e.g. /.abyst/manifest.json.
+// it lists every served file, linked or not. empty path: no manifest.
+//
+//export AbystServer_SetManifestPath
+func AbystServer_SetManifestPath(h_server C.uintptr_t, path_ptr *C.char, path_len C.int) C.int {
+	server, ok := cgo.Handle(h_server).Value().(*http3.Server)
+	if !ok {
+		return INVALID_HANDLE
+	}
+	content_server, ok := server.Handler.(*abyst.ContentServer)
+	if !ok {
+		return INVALID_ARGUMENTS
+	}
+	path_buf, _ := TryUnmarshalBytes(path_ptr, path_len)
+
+	content_server.SetManifestPath(string(path_buf))
+	return 0
+}
+
+// downloads the asset at the abyst URL, if not cached, and writes the local file path to buf.
+// sources_json is a JSON array of member peer hashes that may have the asset cached.
+//
+//export AssetCache_Fetch
+func AssetCache_Fetch(h C.uintptr_t, addr_ptr *C.char, addr_len C.int, sources_json_ptr *C.char, sources_json_len C.int, timeout_ms C.int, buf *C.char, buf_len C.int, err_out *C.uintptr_t) C.int {
+	cache, ok := cgo.Handle(h).Value().(*abyst.AssetCache)
+	if !ok {
+		return INVALID_HANDLE
+	}
+	addr_buf, ok := TryUnmarshalBytes(addr_ptr, addr_len)
+	if !ok {
+		return INVALID_ARGUMENTS
+	}
+	var sources []string
+	if sources_json_buf, ok := TryUnmarshalBytes(sources_json_ptr, sources_json_len); ok {
+		if err := json.Unmarshal(sources_json_buf, &sources); err != nil {
+			return INVALID_ARGUMENTS
+		}
+	}
+
+	ctx, ctx_cancel := context.WithTimeout(context.Background(), time.Duration(timeout_ms)*time.Millisecond)
+	defer ctx_cancel()
+	local_path, err := cache.Fetch(ctx, string(addr_buf), sources)
+	if err != nil {
+		*err_out = marshalError(err)
+		return ERROR
+	}
+	return TryMarshalBytes(buf, buf_len, []byte(local_path))
+}
+
+// one file per host identity. empty: not kept.
+var peer_store_dir = abyss_net.DefaultPeerStoreDir()
+var identity_state_dir string
+var state_dir_mtx = new(sync.Mutex)
+
+// sets the directory where hosts created afterwards keep known peers, device revocations and the blocklist. an empty path disables it.
+// by default, "abyss/peers" in the user config directory.
+//
+//export SetPeerStoreDirectory
+func SetPeerStoreDirectory(path_ptr *C.char, path_len C.int) C.int {
+	path, ok := TryUnmarshalBytes(path_ptr, path_len)
+	if !ok && path_len != 0 {
+		return INVALID_ARGUMENTS
+	}
+
+	state_dir_mtx.Lock()
+	defer state_dir_mtx.Unlock()
+
+	peer_store_dir = string(path)
+	return 0
+}
+
+// sets the directory where hosts created afterwards by NewHost keep their handshake keys, so that a restart reuses them.
+// the keys are written unencrypted; NewHostFromKeystore keeps them under a passphrase instead. empty (default): disabled.
+//
+//export SetIdentityStateDirectory
+func SetIdentityStateDirectory(path_ptr *C.char, path_len C.int) C.int {
+	path, ok := TryUnmarshalBytes(path_ptr, path_len)
+	if !ok && path_len != 0 {
+		return INVALID_ARGUMENTS
+	}
+
+	state_dir_mtx.Lock()
+	defer state_dir_mtx.Unlock()
+
+	identity_state_dir = string(path)
+	return 0
+}
+
+//export NewHost
+func NewHost(root_priv_key_pem_ptr *C.char, root_priv_key_pem_len C.int, h_path_resolver C.uintptr_t, h_abyst_server C.uintptr_t) C.uintptr_t {
 	root_priv_key_pem, ok := TryUnmarshalBytes(root_priv_key_pem_ptr, root_priv_key_pem_len)
 	if !ok {
 		return 0
@@ -200,9 +364,44 @@ func NewHost(root_priv_key_pem_ptr *C.char, root_priv_key_pem_len C.int, h_path_
 		watchdog.Error(err)
 		return 0
 	}
-	root_priv_key_casted, ok := root_priv_key.(abyss_net.PrivateKey)
+	root_priv_key_casted, err := abyss_net.CheckRootKey(root_priv_key)
+	if err != nil {
+		watchdog.Error(err)
+		return 0
+	}
+	return newHost(root_priv_key_casted, abyss_net.NewDefaultBetaNetServiceConfig(), h_path_resolver, h_abyst_server)
+}
+
+// keystore: written by abyss-keytool. the host keeps the stored certificates.
+//
+//export NewHostFromKeystore
+func NewHostFromKeystore(keystore_ptr *C.char, keystore_len C.int, passphrase_ptr *C.char, passphrase_len C.int, h_path_resolver C.uintptr_t, h_abyst_server C.uintptr_t) C.uintptr_t {
+	keystore_buf, ok := TryUnmarshalBytes(keystore_ptr, keystore_len)
+	if !ok {
+		return 0
+	}
+	passphrase, ok := TryUnmarshalBytes(passphrase_ptr, passphrase_len)
+	if !ok {
+		return 0
+	}
+
+	store, err := keystore.Decrypt(keystore_buf, passphrase)
+	if err != nil {
+		watchdog.Error(err)
+		return 0
+	}
+	config := abyss_net.NewDefaultBetaNetServiceConfig()
+	if err := store.Configure(config); err != nil {
+		watchdog.Error(err)
+		return 0
+	}
+	return newHost(store.PrivateKey, config, h_path_resolver, h_abyst_server)
+}
+
+func newHost(root_priv_key abyss_net.PrivateKey, config *abyss_net.BetaNetServiceConfig, h_path_resolver C.uintptr_t, h_abyst_server C.uintptr_t) C.uintptr_t {
+	abyst_server, ok := cgo.Handle(h_abyst_server).Value().(*http3.Server)
 	if !ok {
-		watchdog.Error(errors.New("unsupported private key type"))
+		watchdog.Error(errors.New("invalid handle for abyst_server"))
 		return 0
 	}
 
@@ -212,12 +411,17 @@ func NewHost(root_priv_key_pem_ptr *C.char, root_priv_key_pem_len C.int, h_path_
 		return 0
 	}
 
+	state_dir_mtx.Lock()
+	config.PeerStoreDir = peer_store_dir
+	config.IdentityStateDir = identity_state_dir
+	state_dir_mtx.Unlock()
+
 	addr_selector, err := abyss_net.NewBetaAddressSelector()
 	if err != nil {
 		watchdog.Error(err)
 		return 0
 	}
-	net_service, err := abyss_net.NewBetaNetService(context.Background(), root_priv_key_casted, addr_selector, abyst_server)
+	net_service, err := abyss_net.NewBetaNetServiceWithConfig(context.Background(), root_priv_key, addr_selector, abyst_server, config)
 	if err != nil {
 		watchdog.Error(err)
 		return 0
@@ -228,6 +432,9 @@ func NewHost(root_priv_key_pem_ptr *C.char, root_priv_key_pem_len C.int, h_path_
 		abyss_and.NewAND(net_service.LocalIdentity().IDHash()),
 		path_resolver,
 	)
+	if content_server, ok := abyst_server.Handler.(*abyst.ContentServer); ok {
+		host.WatchContent(content_server, CONTENT_WATCH_INTERVAL)
+	}
 	go host.ListenAndServe(context.Background())
 
 	watchdog.CountHandleExport()
@@ -289,6 +496,101 @@ func Host_AppendKnownPeer(h C.uintptr_t, root_cert_buf_ptr *C.char, root_cert_le
 	}
 }
 
+//export Host_LoadBlocklist
+func Host_LoadBlocklist(h C.uintptr_t, path_ptr *C.char, path_len C.int, err_out *C.uintptr_t) {
+	host, ok := cgo.Handle(h).Value().(*abyss_host.AbyssHost)
+	if !ok {
+		*err_out = marshalError(errors.New("invalid handle"))
+		return
+	}
+	net_service, ok := host.NetworkService.(*abyss_net.BetaNetService)
+	if !ok {
+		*err_out = marshalError(errors.New("unsupported network service"))
+		return
+	}
+
+	path, ok := TryUnmarshalBytes(path_ptr, path_len)
+	if !ok {
+		*err_out = marshalError(errors.New("invalid path"))
+		return
+	}
+	blocklist, err := abyss_net.NewFileBlocklist(string(path))
+	if err != nil {
+		*err_out = marshalError(err)
+		return
+	}
+	net_service.SetBlocklist(blocklist)
+}
+
+// action: 0 block peer, 1 unblock peer, 2 block network, 3 unblock network. target is a peer hash or a CIDR.
+//
+//export Host_Block
+func Host_Block(h C.uintptr_t, action C.int, target_ptr *C.char, target_len C.int, err_out *C.uintptr_t) {
+	host, ok := cgo.Handle(h).Value().(*abyss_host.AbyssHost)
+	if !ok {
+		*err_out = marshalError(errors.New("invalid handle"))
+		return
+	}
+
+	target_buf, ok := TryUnmarshalBytes(target_ptr, target_len)
+	if !ok {
+		*err_out = marshalError(errors.New("invalid target"))
+		return
+	}
+	target := string(target_buf)
+
+	var err error
+	switch action {
+	case 0:
+		err = host.NetworkService.BlockPeer(target)
+	case 1:
+		err = host.NetworkService.UnblockPeer(target)
+	case 2:
+		err = host.NetworkService.BlockNetwork(target)
+	case 3:
+		err = host.NetworkService.UnblockNetwork(target)
+	default:
+		err = errors.New("invalid action")
+	}
+	if err != nil {
+		*err_out = marshalError(err)
+	}
+}
+
+//export Host_IsPeerBlocked
+func Host_IsPeerBlocked(h C.uintptr_t, peer_hash_ptr *C.char, peer_hash_len C.int) C.int {
+	host, ok := cgo.Handle(h).Value().(*abyss_host.AbyssHost)
+	if !ok {
+		return INVALID_HANDLE
+	}
+
+	peer_hash, ok := TryUnmarshalBytes(peer_hash_ptr, peer_hash_len)
+	if !ok {
+		return INVALID_ARGUMENTS
+	}
+	if host.NetworkService.IsPeerBlocked(string(peer_hash)) {
+		return 1
+	}
+	return 0
+}
+
+// leaves all worlds and closes the connections and the socket. the handle must still be closed with CloseAbyssHandle.
+//
+//export Host_Shutdown
+func Host_Shutdown(h C.uintptr_t, timeout_ms C.int, err_out *C.uintptr_t) {
+	host, ok := cgo.Handle(h).Value().(*abyss_host.AbyssHost)
+	if !ok {
+		*err_out = marshalError(errors.New("invalid handle"))
+		return
+	}
+
+	ctx, ctx_cancel := context.WithTimeout(context.Background(), time.Duration(timeout_ms)*time.Millisecond)
+	defer ctx_cancel()
+	if err := host.Shutdown(ctx); err != nil {
+		*err_out = marshalError(err)
+	}
+}
+
 //export Host_OpenOutboundConnection
 func Host_OpenOutboundConnection(h C.uintptr_t, abyss_url_ptr *C.char, abyss_url_len C.int) C.int {
 	host, ok := cgo.Handle(h).Value().(*abyss_host.AbyssHost)
@@ -398,6 +700,10 @@ type ObjectDeleteData struct {
 	peer_hash string
 	body_json string
 }
+type AssetInvalidateData struct {
+	peer_hash string
+	body_json string
+}
 
 //export World_GetURL
 func World_GetURL(h C.uintptr_t, buf_ptr *C.char, buf_len C.int) C.int {
@@ -466,6 +772,14 @@ func World_WaitEvent(h C.uintptr_t, event_type_out *C.int) C.uintptr_t {
 	case abyss.EWorldTerminate:
 		*event_type_out = 6
 		return 0
+	case abyss.EMemberAssetInvalidate:
+		*event_type_out = 7
+		data, _ := json.Marshal(event.Assets)
+		watchdog.CountHandleExport()
+		return C.uintptr_t(cgo.NewHandle(&AssetInvalidateData{
+			peer_hash: event.PeerHash,
+			body_json: string(data),
+		}))
 	default:
 		watchdog.Error(errors.New("internal fault"))
 		*event_type_out = -1
@@ -645,6 +959,29 @@ func WorldPeerObjectDelete_GetBody(h C.uintptr_t, buf *C.char, buf_len C.int) C.
 	return TryMarshalBytes(buf, buf_len, []byte(data.body_json))
 }
 
+//export WorldPeerAssetInvalidate_GetHead
+func WorldPeerAssetInvalidate_GetHead(h C.uintptr_t, peer_hash_out *C.char, body_len *C.int) C.int {
+	data, ok := cgo.Handle(h).Value().(*AssetInvalidateData)
+	if !ok {
+		return INVALID_HANDLE
+	}
+
+	*body_len = C.int(len(data.body_json))
+	return TryMarshalBytes(peer_hash_out, 128, []byte(data.peer_hash))
+}
+
+// JSON array of {"Path", "ETag"}. an empty ETag means the asset was removed.
+//
+//export WorldPeerAssetInvalidate_GetBody
+func WorldPeerAssetInvalidate_GetBody(h C.uintptr_t, buf *C.char, buf_len C.int) C.int {
+	data, ok := cgo.Handle(h).Value().(*AssetInvalidateData)
+	if !ok {
+		return INVALID_HANDLE
+	}
+
+	return TryMarshalBytes(buf, buf_len, []byte(data.body_json))
+}
+
 //export WorldPeerLeave_GetHash
 func WorldPeerLeave_GetHash(h C.uintptr_t, buf *C.char, buf_len C.int) C.int {
 	event, ok := cgo.Handle(h).Value().(*abyss.EWorldMemberLeave)
@@ -702,10 +1039,25 @@ type AbystResponseExport struct {
 	inner *http.Response
 }
 
-func (w *AbystResponseExport) Destruct() {
-	w.inner.Body.Close()
+func (w *AbystResponseExport) Destuct() {
+	if w.inner.Body != nil {
+		w.inner.Body.Close()
+	}
+}
+
+// AbystClient_Request method codes.
+var abyst_methods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodDelete,
+	http.MethodPatch,
+	http.MethodOptions,
 }
 
+// method: 0 GET, 1 HEAD, 2 POST, 3 PUT, 4 DELETE, 5 PATCH, 6 OPTIONS. no request body; see AbystClient_NewRequest.
+//
 //export AbystClient_Request
 func AbystClient_Request(h C.uintptr_t, method C.int, path_ptr *C.char, path_len C.int, err_out *C.uintptr_t) C.uintptr_t {
 	client, ok := cgo.Handle(h).Value().(*AbystClientExport)
@@ -713,11 +1065,7 @@ func AbystClient_Request(h C.uintptr_t, method C.int, path_ptr *C.char, path_len
 		*err_out = marshalError(errors.New("invalid handle"))
 		return 0
 	}
-	var method_string string
-	switch method {
-	case 0:
-		method_string = http.MethodGet
-	default:
+	if method < 0 || int(method) >= len(abyst_methods) {
 		watchdog.CountHandleExport()
 		return C.uintptr_t(cgo.NewHandle(&AbystResponseExport{
 			inner: &http.Response{
@@ -737,7 +1085,7 @@ func AbystClient_Request(h C.uintptr_t, method C.int, path_ptr *C.char, path_len
 		}
 		path_string = string(path_buf)
 	}
-	request, err := http.NewRequest(method_string, "https://a.abyst/"+path_string, nil)
+	request, err := http.NewRequest(abyst_methods[method], "https://a.abyst/"+path_string, nil)
 	if err != nil {
 		*err_out = marshalError(err)
 		return 0
@@ -754,6 +1102,206 @@ func AbystClient_Request(h C.uintptr_t, method C.int, path_ptr *C.char, path_len
 	}))
 }
 
+type abystRoundTrip struct {
+	response *http.Response
+	err      error
+}
+
+// a request built from the native side. the body is streamed through a pipe after AbystRequest_Send.
+type AbystRequestExport struct {
+	client   *http3.ClientConn
+	request  *http.Request
+	body     *io.PipeWriter //nil without a body.
+	result   chan abystRoundTrip
+	received bool
+	mtx      *sync.Mutex
+}
+
+func (r *AbystRequestExport) Destuct() {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if r.body != nil {
+		r.body.CloseWithError(errors.New("abyst request closed"))
+	}
+	if r.result != nil && !r.received {
+		r.received = true
+		go func() {
+			if result := <-r.result; result.response != nil {
+				result.response.Body.Close()
+			}
+		}()
+	}
+}
+
+// method is any HTTP method name. headers are added with AbystRequest_AddHeader before AbystRequest_Send.
+//
+//export AbystClient_NewRequest
+func AbystClient_NewRequest(h C.uintptr_t, method_ptr *C.char, method_len C.int, path_ptr *C.char, path_len C.int, err_out *C.uintptr_t) C.uintptr_t {
+	client, ok := cgo.Handle(h).Value().(*AbystClientExport)
+	if !ok {
+		*err_out = marshalError(errors.New("invalid handle"))
+		return 0
+	}
+
+	method_buf, ok := TryUnmarshalBytes(method_ptr, method_len)
+	if !ok {
+		*err_out = marshalError(errors.New("method required"))
+		return 0
+	}
+	path_buf, _ := TryUnmarshalBytes(path_ptr, path_len)
+	request, err := http.NewRequest(string(method_buf), "https://a.abyst/"+strings.TrimPrefix(string(path_buf), "/"), nil)
+	if err != nil {
+		*err_out = marshalError(err)
+		return 0
+	}
+
+	watchdog.CountHandleExport()
+	return C.uintptr_t(cgo.NewHandle(&AbystRequestExport{
+		client:  client.inner,
+		request: request,
+		mtx:     new(sync.Mutex),
+	}))
+}
+
+//export AbystRequest_AddHeader
+func AbystRequest_AddHeader(h C.uintptr_t, key_ptr *C.char, key_len C.int, value_ptr *C.char, value_len C.int) C.int {
+	request, ok := cgo.Handle(h).Value().(*AbystRequestExport)
+	if !ok {
+		return INVALID_HANDLE
+	}
+
+	key_buf, ok := TryUnmarshalBytes(key_ptr, key_len)
+	if !ok {
+		return INVALID_ARGUMENTS
+	}
+	value_buf, _ := TryUnmarshalBytes(value_ptr, value_len)
+
+	request.mtx.Lock()
+	defer request.mtx.Unlock()
+
+	if request.result != nil {
+		return ERROR //already sent.
+	}
+	request.request.Header.Add(string(key_buf), string(value_buf))
+	return 0
+}
+
+// starts the request. content_length: 0 no body, -1 unknown length, otherwise the exact length.
+// with a body, write it with AbystRequest_WriteBody and finish with AbystRequest_CloseBody.
+//
+//export AbystRequest_Send
+func AbystRequest_Send(h C.uintptr_t, content_length C.longlong) C.int {
+	request, ok := cgo.Handle(h).Value().(*AbystRequestExport)
+	if !ok {
+		return INVALID_HANDLE
+	}
+	if content_length < -1 {
+		return INVALID_ARGUMENTS
+	}
+
+	request.mtx.Lock()
+	defer request.mtx.Unlock()
+
+	if request.result != nil {
+		return ERROR //already sent.
+	}
+	var body_reader *io.PipeReader
+	if content_length != 0 {
+		body_reader, request.body = io.Pipe()
+		request.request.Body = body_reader
+		request.request.ContentLength = int64(content_length)
+	}
+	request.result = make(chan abystRoundTrip, 1)
+
+	go func() {
+		response, err := request.client.RoundTrip(request.request)
+		if err != nil && body_reader != nil {
+			body_reader.CloseWithError(err) //unblocks the writer.
+		}
+		request.result <- abystRoundTrip{response: response, err: err}
+	}()
+	return 0
+}
+
+// blocks until the chunk is taken by the stream. returns the written length.
+//
+//export AbystRequest_WriteBody
+func AbystRequest_WriteBody(h C.uintptr_t, buf_ptr *C.char, buf_len C.int) C.int {
+	request, ok := cgo.Handle(h).Value().(*AbystRequestExport)
+	if !ok {
+		return INVALID_HANDLE
+	}
+	if request.body == nil {
+		return ERROR //not sent, or sent without a body.
+	}
+
+	buf, ok := TryUnmarshalBytes(buf_ptr, buf_len)
+	if !ok {
+		return INVALID_ARGUMENTS
+	}
+	written, err := request.body.Write(buf)
+	if err != nil {
+		watchdog.Error(err)
+		return ERROR
+	}
+	return C.int(written)
+}
+
+//export AbystRequest_CloseBody
+func AbystRequest_CloseBody(h C.uintptr_t) C.int {
+	request, ok := cgo.Handle(h).Value().(*AbystRequestExport)
+	if !ok {
+		return INVALID_HANDLE
+	}
+	if request.body == nil {
+		return ERROR
+	}
+
+	request.body.Close()
+	return 0
+}
+
+// waits for the response headers. returns 0 without an error on timeout; it may be called again.
+// the response can be taken only once.
+//
+//export AbystRequest_GetResponse
+func AbystRequest_GetResponse(h C.uintptr_t, timeout_ms C.int, err_out *C.uintptr_t) C.uintptr_t {
+	request, ok := cgo.Handle(h).Value().(*AbystRequestExport)
+	if !ok {
+		*err_out = marshalError(errors.New("invalid handle"))
+		return 0
+	}
+
+	request.mtx.Lock()
+	result_ch, received := request.result, request.received
+	request.mtx.Unlock()
+	if result_ch == nil || received {
+		*err_out = marshalError(errors.New("request not sent, or response already taken"))
+		return 0
+	}
+
+	var result abystRoundTrip
+	select {
+	case result = <-result_ch:
+	case <-time.After(time.Duration(timeout_ms) * time.Millisecond):
+		return 0
+	}
+
+	request.mtx.Lock()
+	request.received = true
+	request.mtx.Unlock()
+	if result.err != nil {
+		*err_out = marshalError(result.err)
+		return 0
+	}
+
+	watchdog.CountHandleExport()
+	return C.uintptr_t(cgo.NewHandle(&AbystResponseExport{
+		inner: result.response,
+	}))
+}
+
 //export AbyssResponse_GetHeaders
 func AbyssResponse_GetHeaders(h C.uintptr_t, buf *C.char, buf_len C.int) C.int {
 	response, ok := cgo.Handle(h).Value().(*AbystResponseExport)
@@ -848,6 +1396,259 @@ func AbystResponse_ReadBodyAll(h C.uintptr_t, buf_ptr *C.char, buf_len C.int) C.
 	return C.int(readlen)
 }
 
-//TODO: enable some external binding for abyst server. we may expect all abyst local hosts are just available some elsewhere. enable forwarding
+// JSON object of the response trailers. complete only after the body is read to EOF.
+//
+//export AbystResponse_GetTrailers
+func AbystResponse_GetTrailers(h C.uintptr_t, buf *C.char, buf_len C.int) C.int {
+	response, ok := cgo.Handle(h).Value().(*AbystResponseExport)
+	if !ok {
+		return INVALID_HANDLE
+	}
+
+	trailer := response.inner.Trailer
+	if trailer == nil {
+		trailer = http.Header{}
+	}
+	json_bytes, err := json.Marshal(trailer)
+	if err != nil {
+		watchdog.Error(err)
+		return ERROR
+	}
+	return TryMarshalBytes(buf, buf_len, json_bytes)
+}
+
+type AbystGatewayExport struct {
+	inner    *abyst.Gateway
+	server   *http.Server
+	listener net.Listener
+}
+
+func (g *AbystGatewayExport) Destuct() {
+	g.server.Close()
+}
+
+// serves http://127.0.0.1:<port>/<peer hash>/<path> from peers allowed with AbystGateway_Allow. port 0 picks a free port.
+// requests must be addressed to 127.0.0.1:<port> or localhost:<port>; cross-origin requests other than GET, HEAD and OPTIONS are refused.
+//
+//export Host_StartAbystGateway
+func Host_StartAbystGateway(h C.uintptr_t, port C.int, err_out *C.uintptr_t) C.uintptr_t {
+	host, ok := cgo.Handle(h).Value().(*abyss_host.AbyssHost)
+	if !ok {
+		*err_out = marshalError(errors.New("invalid handle"))
+		return 0
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(port))))
+	if err != nil {
+		*err_out = marshalError(err)
+		return 0
+	}
+	gateway := abyst.NewGateway(host.AbystClient().Transport)
+	server := &http.Server{Handler: gateway}
+	go server.Serve(listener)
+
+	watchdog.CountHandleExport()
+	return C.uintptr_t(cgo.NewHandle(&AbystGatewayExport{
+		inner:    gateway,
+		server:   server,
+		listener: listener,
+	}))
+}
+
+//export AbystGateway_GetPort
+func AbystGateway_GetPort(h C.uintptr_t) C.int {
+	gateway, ok := cgo.Handle(h).Value().(*AbystGatewayExport)
+	if !ok {
+		return INVALID_HANDLE
+	}
+	return C.int(gateway.listener.Addr().(*net.TCPAddr).Port)
+}
+
+// allow: 1 allow, 0 disallow.
+//
+//export AbystGateway_Allow
+func AbystGateway_Allow(h C.uintptr_t, peer_hash_ptr *C.char, peer_hash_len C.int, allow C.int) C.int {
+	gateway, ok := cgo.Handle(h).Value().(*AbystGatewayExport)
+	if !ok {
+		return INVALID_HANDLE
+	}
+	peer_hash_buf, ok := TryUnmarshalBytes(peer_hash_ptr, peer_hash_len)
+	if !ok {
+		return INVALID_ARGUMENTS
+	}
+
+	if allow != 0 {
+		gateway.inner.Allow(string(peer_hash_buf))
+	} else {
+		gateway.inner.Disallow(string(peer_hash_buf))
+	}
+	return 0
+}
+
+type AbystStreamListenerExport struct {
+	inner *abyst.StreamListener
+}
+
+func (l *AbystStreamListenerExport) Destuct() {
+	l.inner.Close()
+}
+
+type AbystStreamExport struct {
+	inner *abyst.Stream
+}
+
+func (s *AbystStreamExport) Destuct() {
+	s.inner.CancelRead(0)
+	s.inner.Close()
+}
+
+// accepts bidirectional streams that peers open for the protocol. the host needs an abyst server.
+//
+//export Host_ListenAbystStream
+func Host_ListenAbystStream(h C.uintptr_t, protocol_ptr *C.char, protocol_len C.int, err_out *C.uintptr_t) C.uintptr_t {
+	host, ok := cgo.Handle(h).Value().(*abyss_host.AbyssHost)
+	if !ok {
+		*err_out = marshalError(errors.New("invalid handle"))
+		return 0
+	}
+	protocol_buf, ok := TryUnmarshalBytes(protocol_ptr, protocol_len)
+	if !ok {
+		*err_out = marshalError(errors.New("protocol required"))
+		return 0
+	}
+
+	listener, err := host.ListenAbystStream(string(protocol_buf))
+	if err != nil {
+		*err_out = marshalError(err)
+		return 0
+	}
+	watchdog.CountHandleExport()
+	return C.uintptr_t(cgo.NewHandle(&AbystStreamListenerExport{
+		inner: listener,
+	}))
+}
+
+// returns 0 without an error on timeout; it may be called again.
+//
+//export AbystStreamListener_Accept
+func AbystStreamListener_Accept(h C.uintptr_t, timeout_ms C.int, err_out *C.uintptr_t) C.uintptr_t {
+	listener, ok := cgo.Handle(h).Value().(*AbystStreamListenerExport)
+	if !ok {
+		*err_out = marshalError(errors.New("invalid handle"))
+		return 0
+	}
+
+	ctx, ctx_cancel := context.WithTimeout(context.Background(), time.Duration(timeout_ms)*time.Millisecond)
+	defer ctx_cancel()
+	stream, err := listener.inner.Accept(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return 0
+	}
+	if err != nil {
+		*err_out = marshalError(err)
+		return 0
+	}
+	watchdog.CountHandleExport()
+	return C.uintptr_t(cgo.NewHandle(&AbystStreamExport{
+		inner: stream,
+	}))
+}
+
+//export Host_OpenAbystStream
+func Host_OpenAbystStream(h C.uintptr_t, peer_hash_ptr *C.char, peer_hash_len C.int, protocol_ptr *C.char, protocol_len C.int, timeout_ms C.int, err_out *C.uintptr_t) C.uintptr_t {
+	host, ok := cgo.Handle(h).Value().(*abyss_host.AbyssHost)
+	if !ok {
+		*err_out = marshalError(errors.New("invalid handle"))
+		return 0
+	}
+	peer_hash_buf, ok := TryUnmarshalBytes(peer_hash_ptr, peer_hash_len)
+	if !ok {
+		*err_out = marshalError(errors.New("peer hash required"))
+		return 0
+	}
+	protocol_buf, ok := TryUnmarshalBytes(protocol_ptr, protocol_len)
+	if !ok {
+		*err_out = marshalError(errors.New("protocol required"))
+		return 0
+	}
+
+	ctx, ctx_cancel := context.WithTimeout(context.Background(), time.Duration(timeout_ms)*time.Millisecond)
+	defer ctx_cancel()
+	stream, err := host.OpenAbystStream(ctx, string(peer_hash_buf), string(protocol_buf))
+	if err != nil {
+		*err_out = marshalError(err)
+		return 0
+	}
+	watchdog.CountHandleExport()
+	return C.uintptr_t(cgo.NewHandle(&AbystStreamExport{
+		inner: stream,
+	}))
+}
+
+//export AbystStream_GetPeerHash
+func AbystStream_GetPeerHash(h C.uintptr_t, buf *C.char, buf_len C.int) C.int {
+	stream, ok := cgo.Handle(h).Value().(*AbystStreamExport)
+	if !ok {
+		return INVALID_HANDLE
+	}
+
+	return TryMarshalBytes(buf, buf_len, []byte(stream.inner.PeerHash()))
+}
+
+// blocks until some data arrives. returns EOF once the peer closed its side.
+//
+//export AbystStream_Read
+func AbystStream_Read(h C.uintptr_t, buf_ptr *C.char, buf_len C.int) C.int {
+	stream, ok := cgo.Handle(h).Value().(*AbystStreamExport)
+	if !ok {
+		return INVALID_HANDLE
+	}
+
+	buf, ok := TryUnmarshalBytes(buf_ptr, buf_len)
+	if !ok {
+		return INVALID_ARGUMENTS
+	}
+	read_len, err := stream.inner.Read(buf)
+	if read_len == 0 && err != nil {
+		if err != io.EOF {
+			watchdog.Error(err)
+			return ERROR
+		}
+		return EOF
+	}
+	return C.int(read_len)
+}
+
+//export AbystStream_Write
+func AbystStream_Write(h C.uintptr_t, buf_ptr *C.char, buf_len C.int) C.int {
+	stream, ok := cgo.Handle(h).Value().(*AbystStreamExport)
+	if !ok {
+		return INVALID_HANDLE
+	}
+
+	buf, ok := TryUnmarshalBytes(buf_ptr, buf_len)
+	if !ok {
+		return INVALID_ARGUMENTS
+	}
+	written, err := stream.inner.Write(buf)
+	if err != nil {
+		watchdog.Error(err)
+		return ERROR
+	}
+	return C.int(written)
+}
+
+// closes our side; the peer reads EOF. the stream can still be read.
+//
+//export AbystStream_CloseWrite
+func AbystStream_CloseWrite(h C.uintptr_t) C.int {
+	stream, ok := cgo.Handle(h).Value().(*AbystStreamExport)
+	if !ok {
+		return INVALID_HANDLE
+	}
+
+	stream.inner.Close()
+	return 0
+}
 
 func main() {}
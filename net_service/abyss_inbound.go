@@ -4,12 +4,15 @@ import (
 	"context"
 	"crypto/x509"
 	"errors"
+	"io"
+	"net"
 
 	"github.com/fxamacker/cbor/v2"
 	"github.com/quic-go/quic-go"
 
 	"github.com/MinwooWebeng/abyss_core/aerr"
 	"github.com/MinwooWebeng/abyss_core/ahmp"
+	abyss "github.com/MinwooWebeng/abyss_core/interfaces"
 )
 
 func (h *BetaNetService) PrepareAbyssInbound(listen_ctx context.Context, connection quic.Connection) {
@@ -17,16 +20,22 @@ func (h *BetaNetService) PrepareAbyssInbound(listen_ctx context.Context, connect
 	var target *ContextedPeer
 	var ahmp_decoder *cbor.Decoder
 	var err error
+	var introduced bool //the dialer was unknown, and introduced itself in the handshake
 
 	defer func() {
 		if target == nil { //peer not found.
 			return
 		}
 
+		target.send_mtx.Lock() //the greeting below goes out before any other send.
+		defer target.send_mtx.Unlock()
 		target.mtx.Lock()
 		defer target.mtx.Unlock()
 
 		if err != nil {
+			if target.state == PNCS_INBOUND || target.state == PNCS_CONNECTED {
+				return //redundant attempt. the peer is not affected.
+			}
 			if target.err == nil {
 				target.err = err
 			}
@@ -37,12 +46,21 @@ func (h *BetaNetService) PrepareAbyssInbound(listen_ctx context.Context, connect
 				target.state = PNCS_INBOUND
 				target.inbound_conn = connection
 				target.ahmp_decoder = ahmp_decoder
-				go target.listenAhmp()
+				h.goService(func() { h.serveAhmp(target) })
+				if introduced { //it cannot expect us to dial on our own. dial back.
+					go h.PrepareAbyssOutbound(target, []*net.UDPAddr{connection.RemoteAddr().(*net.UDPAddr)})
+				}
 			case PNCS_OUTBOUND:
 				target.state = PNCS_CONNECTED
 				target.inbound_conn = connection
 				target.ahmp_decoder = ahmp_decoder
-				go target.listenAhmp()
+				h.goService(func() { h.serveAhmp(target) })
+				go h.rememberPeerAddress(target.identity.root_id_hash, target.outbound_conn.RemoteAddr().(*net.UDPAddr))
+				target.sendObservedAddress()
+				if local_record, err := h.newLocalPeerRecord(); err == nil {
+					target.sendPeerRecord(local_record)
+				}
+				target.sendDeviceRevocations(h.deviceRevocations())
 				h.abyssPeerCH <- target
 			case PNCS_INBOUND, PNCS_CONNECTED:
 				connection.CloseWithError(ABYSS_ALREADY_CONNECTED, ABYSS_ALREADY_CONNECTED_M)
@@ -75,12 +93,17 @@ func (h *BetaNetService) PrepareAbyssInbound(listen_ctx context.Context, connect
 		err = aerr.NewConnErr(connection, nil, err)
 		return
 	}
-	var abyss_bind_cert []byte
-	if err = cbor.Unmarshal(handshake_1, &abyss_bind_cert); err != nil {
+	var handshake_1_body RawAbyssHandshake1
+	if tls_info.NegotiatedProtocol == abyss.NextProtoAbyss2 {
+		err = cbor.Unmarshal(handshake_1, &handshake_1_body)
+	} else { //older peers send the binding certificate alone
+		err = cbor.Unmarshal(handshake_1, &handshake_1_body.BindCertificateDer)
+	}
+	if err != nil {
 		err = aerr.NewConnErr(connection, nil, err)
 		return
 	}
-	abyss_bind_cert_x509, err := x509.ParseCertificate(abyss_bind_cert)
+	abyss_bind_cert_x509, err := x509.ParseCertificate(handshake_1_body.BindCertificateDer)
 	if err != nil {
 		err = aerr.NewConnErr(connection, nil, err)
 		return
@@ -90,18 +113,48 @@ func (h *BetaNetService) PrepareAbyssInbound(listen_ctx context.Context, connect
 	//TODO: make sure that only one inbound connection is answered for a peer. use atomic.
 	//retrieve known identity and verify
 	peer_hash := abyss_bind_cert_x509.Issuer.CommonName
+	if h.IsPeerBlocked(peer_hash) {
+		connection.CloseWithError(ABYSS_BLOCKED, ABYSS_BLOCKED_M)
+		return
+	}
+	h.preaccept_mtx.Lock()
+	pre_accepter := h.preAccepter
+	h.preaccept_mtx.Unlock()
+	pre_accepted := false
+	if pre_accepter != nil {
+		ok, code, message := pre_accepter.PreAccept(peer_hash, connection.RemoteAddr().(*net.UDPAddr))
+		if !ok {
+			connection.CloseWithError(quic.ApplicationErrorCode(code), message)
+			return
+		}
+		pre_accepted = true
+	}
+	if len(handshake_1_body.RootCertificateDer) != 0 {
+		//the dialer introduced itself. a known peer keeps its identity;
+		//an unknown one becomes known only if the pre-accepter vouches for it.
+		//(a DHT record proves nothing here: records are self-signed, and any peer can store one.)
+		if peer_identity, id_err := NewPeerIdentity(handshake_1_body.RootCertificateDer, handshake_1_body.HandshakeKeyCertificateDer); id_err == nil && peer_identity.root_id_hash == peer_hash {
+			if _, known := h.peers.Find(peer_hash); known || pre_accepted {
+				introduced, _ = h.appendPeer(peer_identity)
+			}
+		}
+	}
 	target, err = h.peers.Wait(listen_ctx, peer_hash)
 	if err != nil {
 		err = aerr.NewConnErrM(connection, nil, "unknown peer")
 		return
 	}
-	if err = target.identity.VerifyTLSBinding(abyss_bind_cert_x509, client_tls_cert); err != nil {
+	if err = target.identity.VerifyTLSBinding(abyss_bind_cert_x509, client_tls_cert, h.clock()); err != nil {
 		err = aerr.NewConnErr(connection, nil, err)
 		return
 	}
+	if h.isRevokedDevice(&target.identity) {
+		err = aerr.NewConnErr(connection, nil, ErrDeviceRevoked)
+		return
+	}
 
 	//send local tls-abyss binding cert
-	if err = ahmp_encoder.Encode(h.tlsIdentity.abyss_bind_cert); err != nil {
+	if err = ahmp_encoder.Encode(h.currentTLSIdentity().abyss_bind_cert); err != nil {
 		err = aerr.NewConnErr(connection, nil, err)
 		return
 	}
@@ -109,6 +162,7 @@ func (h *BetaNetService) PrepareAbyssInbound(listen_ctx context.Context, connect
 	//return: defer will update the peer.
 }
 
+// p.err is ErrPeerShutdown if the peer ended the stream.
 func (p *AbyssPeer) listenAhmp() {
 	var err error
 	defer func() {
@@ -120,8 +174,10 @@ func (p *AbyssPeer) listenAhmp() {
 
 	for {
 		var ahmp_type int
-		err := p.ahmp_decoder.Decode(&ahmp_type)
-		if err != nil {
+		if err = p.ahmp_decoder.Decode(&ahmp_type); err != nil {
+			if errors.Is(err, io.EOF) { //between messages: the peer shut down, see BetaNetService.Shutdown
+				err = ErrPeerShutdown
+			}
 			return
 		}
 
@@ -267,6 +323,143 @@ func (p *AbyssPeer) listenAhmp() {
 				return
 			}
 			p.ahmp_decoded_ch <- parsed_msg
+		case ahmp.AIV_T:
+			var raw_msg ahmp.RawAIV
+			err = p.ahmp_decoder.Decode(&raw_msg)
+			if err != nil {
+				p.ahmp_decoded_ch <- &ahmp.INVAL{Err: errors.Join(errors.New("parsing AIV"), err)}
+				return
+			}
+			parsed_msg, err := raw_msg.TryParse()
+			if err != nil {
+				p.ahmp_decoded_ch <- &ahmp.INVAL{Err: errors.Join(errors.New("parsing AIV"), err)}
+				return
+			}
+			p.ahmp_decoded_ch <- parsed_msg
+		case ahmp.OBS_T:
+			//fmt.Println("receiving OBS")
+			var raw_msg ahmp.RawOBS
+			err = p.ahmp_decoder.Decode(&raw_msg)
+			if err != nil {
+				p.ahmp_decoded_ch <- &ahmp.INVAL{Err: errors.Join(errors.New("parsing OBS"), err)}
+				return
+			}
+			parsed_msg, err := raw_msg.TryParse()
+			if err != nil {
+				p.ahmp_decoded_ch <- &ahmp.INVAL{Err: errors.Join(errors.New("parsing OBS"), err)}
+				return
+			}
+			p.ahmp_decoded_ch <- parsed_msg
+		case ahmp.PRQ_T:
+			//fmt.Println("receiving PRQ")
+			var raw_msg ahmp.RawPRQ
+			err = p.ahmp_decoder.Decode(&raw_msg)
+			if err != nil {
+				p.ahmp_decoded_ch <- &ahmp.INVAL{Err: errors.Join(errors.New("parsing PRQ"), err)}
+				return
+			}
+			parsed_msg, err := raw_msg.TryParse()
+			if err != nil {
+				p.ahmp_decoded_ch <- &ahmp.INVAL{Err: errors.Join(errors.New("parsing PRQ"), err)}
+				return
+			}
+			p.ahmp_decoded_ch <- parsed_msg
+		case ahmp.PNC_T:
+			//fmt.Println("receiving PNC")
+			var raw_msg ahmp.RawPNC
+			err = p.ahmp_decoder.Decode(&raw_msg)
+			if err != nil {
+				p.ahmp_decoded_ch <- &ahmp.INVAL{Err: errors.Join(errors.New("parsing PNC"), err)}
+				return
+			}
+			parsed_msg, err := raw_msg.TryParse()
+			if err != nil {
+				p.ahmp_decoded_ch <- &ahmp.INVAL{Err: errors.Join(errors.New("parsing PNC"), err)}
+				return
+			}
+			p.ahmp_decoded_ch <- parsed_msg
+		case ahmp.RLQ_T:
+			//fmt.Println("receiving RLQ")
+			var raw_msg ahmp.RawRLQ
+			err = p.ahmp_decoder.Decode(&raw_msg)
+			if err != nil {
+				p.ahmp_decoded_ch <- &ahmp.INVAL{Err: errors.Join(errors.New("parsing RLQ"), err)}
+				return
+			}
+			parsed_msg, err := raw_msg.TryParse()
+			if err != nil {
+				p.ahmp_decoded_ch <- &ahmp.INVAL{Err: errors.Join(errors.New("parsing RLQ"), err)}
+				return
+			}
+			p.ahmp_decoded_ch <- parsed_msg
+		case ahmp.DFQ_T:
+			//fmt.Println("receiving DFQ")
+			var raw_msg ahmp.RawDFQ
+			err = p.ahmp_decoder.Decode(&raw_msg)
+			if err != nil {
+				p.ahmp_decoded_ch <- &ahmp.INVAL{Err: errors.Join(errors.New("parsing DFQ"), err)}
+				return
+			}
+			parsed_msg, err := raw_msg.TryParse()
+			if err != nil {
+				p.ahmp_decoded_ch <- &ahmp.INVAL{Err: errors.Join(errors.New("parsing DFQ"), err)}
+				return
+			}
+			p.ahmp_decoded_ch <- parsed_msg
+		case ahmp.DFR_T:
+			//fmt.Println("receiving DFR")
+			var raw_msg ahmp.RawDFR
+			err = p.ahmp_decoder.Decode(&raw_msg)
+			if err != nil {
+				p.ahmp_decoded_ch <- &ahmp.INVAL{Err: errors.Join(errors.New("parsing DFR"), err)}
+				return
+			}
+			parsed_msg, err := raw_msg.TryParse()
+			if err != nil {
+				p.ahmp_decoded_ch <- &ahmp.INVAL{Err: errors.Join(errors.New("parsing DFR"), err)}
+				return
+			}
+			p.ahmp_decoded_ch <- parsed_msg
+		case ahmp.DST_T:
+			//fmt.Println("receiving DST")
+			var raw_msg ahmp.RawDST
+			err = p.ahmp_decoder.Decode(&raw_msg)
+			if err != nil {
+				p.ahmp_decoded_ch <- &ahmp.INVAL{Err: errors.Join(errors.New("parsing DST"), err)}
+				return
+			}
+			parsed_msg, err := raw_msg.TryParse()
+			if err != nil {
+				p.ahmp_decoded_ch <- &ahmp.INVAL{Err: errors.Join(errors.New("parsing DST"), err)}
+				return
+			}
+			p.ahmp_decoded_ch <- parsed_msg
+		case ahmp.HKR_T:
+			var raw_msg ahmp.RawHKR
+			err = p.ahmp_decoder.Decode(&raw_msg)
+			if err != nil {
+				p.ahmp_decoded_ch <- &ahmp.INVAL{Err: errors.Join(errors.New("parsing HKR"), err)}
+				return
+			}
+			parsed_msg, err := raw_msg.TryParse()
+			if err != nil {
+				p.ahmp_decoded_ch <- &ahmp.INVAL{Err: errors.Join(errors.New("parsing HKR"), err)}
+				return
+			}
+			p.ahmp_decoded_ch <- parsed_msg
+		case ahmp.DRV_T:
+			var raw_msg ahmp.RawDRV
+			err = p.ahmp_decoder.Decode(&raw_msg)
+			if err != nil {
+				p.ahmp_decoded_ch <- &ahmp.INVAL{Err: errors.Join(errors.New("parsing DRV"), err)}
+				return
+			}
+			parsed_msg, err := raw_msg.TryParse()
+			if err != nil {
+				p.ahmp_decoded_ch <- &ahmp.INVAL{Err: errors.Join(errors.New("parsing DRV"), err)}
+				return
+			}
+			p.ahmp_decoded_ch <- parsed_msg
 		default:
 			p.ahmp_decoded_ch <- &ahmp.INVAL{Err: errors.New("unknown AHMP message type")}
 			return
@@ -3,23 +3,49 @@ package net_service
 import (
 	"bytes"
 	"crypto/x509"
+	"errors"
 	"net"
 
 	"github.com/fxamacker/cbor/v2"
 	"github.com/quic-go/quic-go"
+
+	abyss "github.com/MinwooWebeng/abyss_core/interfaces"
 )
 
-func (h *BetaNetService) PrepareAbyssOutbound(target *ContextedPeer, addresses []*net.UDPAddr) {
+// first handshake payload, from the dialer, on abyss.NextProtoAbyss2.
+// the certificates let the accepter verify a dialer it has not heard of.
+// on abyss.NextProtoAbyss, the payload is the binding certificate alone.
+type RawAbyssHandshake1 struct {
+	BindCertificateDer         []byte
+	RootCertificateDer         []byte
+	HandshakeKeyCertificateDer []byte
+}
+
+// returns error if the outbound connection could not be prepared.
+// a failed dial leaves the peer state untouched, so that it can be retried (e.g. by hole punching).
+func (h *BetaNetService) PrepareAbyssOutbound(target *ContextedPeer, addresses []*net.UDPAddr) (err error) {
 	//watchdog.Info("outbound detected")
 	var connection quic.Connection
+	var ahmp_stream quic.Stream
 	var ahmp_encoder *cbor.Encoder
-	var err error
 
 	defer func() {
+		target.send_mtx.Lock() //the greeting below goes out before any other send.
+		defer target.send_mtx.Unlock()
 		target.mtx.Lock()
 		defer target.mtx.Unlock()
 
 		if err != nil {
+			if connection != nil {
+				connection.CloseWithError(0, "")
+			}
+			if connection == nil || target.state == PNCS_OUTBOUND || target.state == PNCS_CONNECTED {
+				return //failed dial, or a redundant attempt. the peer is not affected.
+			}
+			var app_err *quic.ApplicationError
+			if errors.As(err, &app_err) && app_err.Remote && app_err.ErrorCode == ABYSS_ALREADY_CONNECTED {
+				return //a concurrent attempt (e.g. a duplicate punch) got there first.
+			}
 			if target.err == nil {
 				target.err = err
 			}
@@ -30,12 +56,20 @@ func (h *BetaNetService) PrepareAbyssOutbound(target *ContextedPeer, addresses [
 				target.state = PNCS_OUTBOUND
 				target.outbound_conn = connection
 				target.addresses = append(target.addresses, addresses...)
+				target.ahmp_stream = ahmp_stream
 				target.ahmp_encoder = ahmp_encoder
 			case PNCS_INBOUND:
 				target.state = PNCS_CONNECTED
 				target.outbound_conn = connection
 				target.addresses = append(target.addresses, addresses...)
+				target.ahmp_stream = ahmp_stream
 				target.ahmp_encoder = ahmp_encoder
+				go h.rememberPeerAddress(target.identity.root_id_hash, connection.RemoteAddr().(*net.UDPAddr))
+				target.sendObservedAddress()
+				if local_record, err := h.newLocalPeerRecord(); err == nil {
+					target.sendPeerRecord(local_record)
+				}
+				target.sendDeviceRevocations(h.deviceRevocations())
 				h.abyssPeerCH <- target
 			case PNCS_OUTBOUND, PNCS_CONNECTED:
 				connection.CloseWithError(ABYSS_ALREADY_CONNECTED, ABYSS_ALREADY_CONNECTED_M)
@@ -45,8 +79,17 @@ func (h *BetaNetService) PrepareAbyssOutbound(target *ContextedPeer, addresses [
 		}
 	}()
 
-	address_selected := h.addressSelector.FilterAddressCandidates(addresses)
-	connection, err = h.quicTransport.Dial(target.ctx, address_selected[0], h.abyssTlsConf, h.quicConf)
+	address_selected := h.filterBlockedAddresses(h.addressSelector.FilterAddressCandidates(addresses))
+	target.mtx.Lock()
+	if target.state == PNCS_INBOUND { //the inbound source address is known to pass the peer's NAT.
+		address_selected = append([]*net.UDPAddr{target.inbound_conn.RemoteAddr().(*net.UDPAddr)}, address_selected...)
+	}
+	target.mtx.Unlock()
+	if len(address_selected) == 0 {
+		err = errors.New("no valid IP address")
+		return
+	}
+	connection, err = h.transportFor(address_selected[0]).Dial(target.ctx, address_selected[0], h.abyssTlsConf, h.quicConf)
 	if err != nil {
 		return
 	}
@@ -55,20 +98,29 @@ func (h *BetaNetService) PrepareAbyssOutbound(target *ContextedPeer, addresses [
 	tls_info := connection.ConnectionState().TLS
 	client_tls_cert := tls_info.PeerCertificates[0] //*x509.Certificate, validated
 
-	ahmp_stream, err := connection.OpenStreamSync(target.ctx)
+	ahmp_stream, err = connection.OpenStreamSync(target.ctx)
 	if err != nil {
 		return
 	}
 	ahmp_encoder = cbor.NewEncoder(ahmp_stream)
 	ahmp_decoder := cbor.NewDecoder(ahmp_stream)
 
-	//send {local peer_hash, local tls-abyss binding cert} encrypted with remote handshake key.
+	//send {local tls-abyss binding cert, local certificates} encrypted with remote handshake key.
+	//older peers take the binding cert alone.
 	var handshake_1_buf bytes.Buffer
-	err = cbor.MarshalToBuffer(h.tlsIdentity.abyss_bind_cert, &handshake_1_buf)
+	if tls_info.NegotiatedProtocol == abyss.NextProtoAbyss2 {
+		err = cbor.MarshalToBuffer(RawAbyssHandshake1{
+			BindCertificateDer:         h.currentTLSIdentity().abyss_bind_cert,
+			RootCertificateDer:         h.localIdentity.root_self_cert_x509.Raw,
+			HandshakeKeyCertificateDer: h.localIdentity.handshakeKeyCertificateDer(),
+		}, &handshake_1_buf)
+	} else {
+		err = cbor.MarshalToBuffer(h.currentTLSIdentity().abyss_bind_cert, &handshake_1_buf)
+	}
 	if err != nil {
 		return
 	}
-	handshake_1_payload, err := target.identity.EncryptHandshake(handshake_1_buf.Bytes())
+	handshake_1_payload, err := target.handshakeIdentity().EncryptHandshake(handshake_1_buf.Bytes())
 	if err != nil {
 		return
 	}
@@ -79,13 +131,21 @@ func (h *BetaNetService) PrepareAbyssOutbound(target *ContextedPeer, addresses [
 
 	//receive accepter-side self-authentication
 	var handshake_2_payload []byte
-	if err := ahmp_decoder.Decode(&handshake_2_payload); err != nil {
+	if err = ahmp_decoder.Decode(&handshake_2_payload); err != nil {
 		return
 	}
 	handshake_2_payload_x509, err := x509.ParseCertificate(handshake_2_payload)
-	if err := target.identity.VerifyTLSBinding(handshake_2_payload_x509, client_tls_cert); err != nil {
+	if err != nil {
+		return
+	}
+	if err = target.identity.VerifyTLSBinding(handshake_2_payload_x509, client_tls_cert, h.clock()); err != nil {
+		return
+	}
+	if h.isRevokedDevice(&target.identity) {
+		err = ErrDeviceRevoked
 		return
 	}
 
 	//return: defer will update the peer.
+	return
 }
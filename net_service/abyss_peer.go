@@ -28,16 +28,19 @@ const (
 
 type AbyssPeer struct {
 	state           PNCState     //can be checked without entering mtx only after once its state becomes PNCS_CONNECTED
-	identity        PeerIdentity //must be set at creation
+	identity        PeerIdentity //must be set at creation. only the handshake key may change (under mtx), see updateHandshakeKey
 	addresses       []*net.UDPAddr
+	relays          []string //relay candidates the peer advertised
 	inbound_conn    quic.Connection
 	outbound_conn   quic.Connection
+	ahmp_stream     quic.Stream //on outbound_conn. the encoder writes to it
 	ahmp_encoder    *cbor.Encoder
 	ahmp_decoder    *cbor.Decoder //only listenAhmp() reads from this
 	ahmp_decoded_ch chan any
 	err             error
 
-	mtx sync.Mutex //for peer component changes.
+	mtx      sync.Mutex //for peer component changes.
+	send_mtx sync.Mutex //keeps (type, body) pairs of concurrent senders from interleaving. taken before mtx
 }
 
 func NewAbyssPeer(identity PeerIdentity) *AbyssPeer {
@@ -53,11 +56,22 @@ func (p *AbyssPeer) IDHash() string {
 	return p.identity.root_id_hash
 }
 
+func (p *AbyssPeer) OwnerHash() string {
+	return p.identity.owner_id_hash
+}
+
+func (p *AbyssPeer) DeviceID() string {
+	return p.identity.DeviceID()
+}
+
 func (p *AbyssPeer) RootCertificateDer() []byte {
 	return p.identity.root_self_cert_der
 }
 
 func (p *AbyssPeer) HandshakeKeyCertificateDer() []byte {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
 	return p.identity.handshake_key_cert_der
 }
 func (p *AbyssPeer) AURL() *aurl.AURL {
@@ -65,6 +79,7 @@ func (p *AbyssPeer) AURL() *aurl.AURL {
 		Scheme:    "abyss",
 		Hash:      p.identity.root_id_hash,
 		Addresses: p.addresses,
+		Relays:    p.relays,
 		Path:      "/",
 	}
 }
@@ -75,12 +90,71 @@ func (p *AbyssPeer) IsConnected() bool {
 
 	return p.state == PNCS_CONNECTED
 }
+
+// closes both connections. err is kept as the peer error.
+func (p *AbyssPeer) closeWithError(code quic.ApplicationErrorCode, message string, err error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if p.inbound_conn != nil {
+		p.inbound_conn.CloseWithError(code, message)
+	}
+	if p.outbound_conn != nil {
+		p.outbound_conn.CloseWithError(code, message)
+	}
+	if p.err == nil {
+		p.err = err
+	}
+	p.state = PNCS_CLOSED
+}
+
 func (p *AbyssPeer) AhmpCh() chan any {
 	return p.ahmp_decoded_ch
 }
 
+// tell the peer which source address its connection arrived from.
+// called with p.send_mtx and p.mtx held (in that order, as everywhere), as the peer becomes connected.
+func (p *AbyssPeer) sendObservedAddress() {
+	if p.ahmp_encoder.Encode(ahmp.OBS_T) != nil {
+		return
+	}
+	p.ahmp_encoder.Encode(ahmp.RawOBS{
+		Address: p.inbound_conn.RemoteAddr().String(),
+	})
+}
+
+// hand our signed record to the peer (DHT store).
+// like sendObservedAddress, called with p.send_mtx and p.mtx held.
+func (p *AbyssPeer) sendPeerRecord(record abyss.SignedPeerRecord) {
+	if p.ahmp_encoder.Encode(ahmp.DST_T) != nil {
+		return
+	}
+	p.ahmp_encoder.Encode(ahmp.RawDST{
+		Record: ahmp.RawPeerRecord(record),
+	})
+}
+
+// pass on the device revocations we know of; the peer may have missed them while disconnected.
+// like sendObservedAddress, called with p.send_mtx and p.mtx held.
+func (p *AbyssPeer) sendDeviceRevocations(revocations []abyss.DeviceRevocation) {
+	for _, revocation := range revocations {
+		if p.ahmp_encoder.Encode(ahmp.DRV_T) != nil {
+			return
+		}
+		p.ahmp_encoder.Encode(ahmp.RawDRV{
+			Body:                    revocation.Body,
+			Signature:               revocation.Signature,
+			OwnerRootCertificateDer: revocation.OwnerRootCertificateDer,
+		})
+	}
+}
+
+// called with p.send_mtx held.
 func (p *ContextedPeer) _trySend(v any) bool {
-	if p.state != PNCS_CONNECTED {
+	p.mtx.Lock()
+	connected := p.state == PNCS_CONNECTED
+	p.mtx.Unlock()
+	if !connected {
 		return false
 	}
 
@@ -98,6 +172,9 @@ func (p *ContextedPeer) _trySend(v any) bool {
 }
 func (p *ContextedPeer) _trySend2(v int, w any) bool {
 	//fmt.Println(p.inbound_conn.LocalAddr().String() + "->" + p.inbound_conn.RemoteAddr().String() + " " + strconv.Itoa(v))
+	p.send_mtx.Lock()
+	defer p.send_mtx.Unlock()
+
 	type_sent := p._trySend(v)
 	body_sent := p._trySend(w)
 	return type_sent && body_sent
@@ -203,3 +280,15 @@ func (p *ContextedPeer) TrySendSOD(local_session_id uuid.UUID, peer_session_id u
 		ObjectIDs:       functional.Filter(objectIDs, func(u uuid.UUID) string { return u.String() }),
 	})
 }
+func (p *ContextedPeer) TrySendAIV(local_session_id uuid.UUID, peer_session_id uuid.UUID, assets []abyss.AssetChange) bool {
+	return p._trySend2(ahmp.AIV_T, ahmp.RawAIV{
+		SenderSessionID: local_session_id.String(),
+		RecverSessionID: peer_session_id.String(),
+		Assets: functional.Filter(assets, func(u abyss.AssetChange) ahmp.RawAssetChange {
+			return ahmp.RawAssetChange{
+				Path: u.Path,
+				ETag: u.ETag,
+			}
+		}),
+	})
+}
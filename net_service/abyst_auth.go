@@ -0,0 +1,124 @@
+package net_service
+
+import (
+	"context"
+	"crypto"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+
+	"github.com/MinwooWebeng/abyss_core/abyst"
+)
+
+// an accepted abyst connection, with the peer it was authenticated as.
+type abystConnection struct {
+	quic.Connection
+	peer_hash string
+}
+
+// AbystPeerHash finds the abyss peer that an abyst connection comes from.
+// its TLS key must be the one that a peer bound to its abyss connection (see VerifyTLSBinding);
+// the TLS key is kept across renewals. our own key is the local peer (loopback).
+func (h *BetaNetService) AbystPeerHash(connection quic.Connection) (string, bool) {
+	peer_certificates := connection.ConnectionState().TLS.PeerCertificates
+	if len(peer_certificates) == 0 {
+		return "", false
+	}
+	tls_key, ok := peer_certificates[0].PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok {
+		return "", false
+	}
+	if tls_key.Equal(h.currentTLSIdentity().priv_key.(crypto.Signer).Public()) {
+		return h.localIdentity.root_id_hash, true
+	}
+
+	for _, peer := range h.peers.All() {
+		if peer.boundTLSKey(tls_key) {
+			return peer.identity.root_id_hash, true
+		}
+	}
+	return "", false
+}
+
+// the TLS key of either abyss connection.
+func (p *AbyssPeer) boundTLSKey(tls_key interface{ Equal(crypto.PublicKey) bool }) bool {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if p.state == PNCS_CLOSED {
+		return false
+	}
+	for _, connection := range []quic.Connection{p.inbound_conn, p.outbound_conn} {
+		if connection == nil {
+			continue
+		}
+		peer_certificates := connection.ConnectionState().TLS.PeerCertificates
+		if len(peer_certificates) != 0 && tls_key.Equal(peer_certificates[0].PublicKey) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *BetaNetService) serveAbyst(connection quic.Connection, peer_hash string) {
+	tracing_id := connection.Context().Value(quic.ConnectionTracingKey).(quic.ConnectionTracingID)
+	accepted := &abystConnection{Connection: connection, peer_hash: peer_hash}
+	h.abyst_mtx.Lock()
+	h.abyst_conn_peers[tracing_id] = accepted
+	h.abyst_mtx.Unlock()
+	defer func() {
+		h.abyst_mtx.Lock()
+		delete(h.abyst_conn_peers, tracing_id)
+		h.abyst_mtx.Unlock()
+	}()
+
+	h.abystServer.ServeQUICConn(accepted)
+}
+
+func (h *BetaNetService) SetAbystStreamHandler(handler func(stream quic.Stream, peer_hash string)) {
+	h.abyst_mtx.Lock()
+	defer h.abyst_mtx.Unlock()
+
+	h.abystStreamHandler = handler
+}
+
+// takes the bidirectional streams that start with abyst.STREAM_FRAME_TYPE, after the server's own StreamHijacker.
+func (h *BetaNetService) wrapAbystStreamHijacker() {
+	stream_hijacker := h.abystServer.StreamHijacker
+	h.abystServer.StreamHijacker = func(frame_type http3.FrameType, tracing_id quic.ConnectionTracingID, stream quic.Stream, err error) (bool, error) {
+		if stream_hijacker != nil {
+			if hijacked, err := stream_hijacker(frame_type, tracing_id, stream, err); hijacked || err != nil {
+				return hijacked, err
+			}
+		}
+		if err != nil || frame_type != abyst.STREAM_FRAME_TYPE {
+			return false, nil
+		}
+
+		h.abyst_mtx.Lock()
+		accepted, ok := h.abyst_conn_peers[tracing_id]
+		handler := h.abystStreamHandler
+		h.abyst_mtx.Unlock()
+		if !ok || handler == nil {
+			stream.CancelRead(abyst.STREAM_REJECTED)
+			stream.CancelWrite(abyst.STREAM_REJECTED)
+			return true, nil
+		}
+		handler(stream, accepted.peer_hash)
+		return true, nil
+	}
+}
+
+// puts the peer hash of accepted connections in the request context, before the server's own ConnContext.
+func (h *BetaNetService) wrapAbystConnContext() {
+	conn_context := h.abystServer.ConnContext
+	h.abystServer.ConnContext = func(ctx context.Context, connection quic.Connection) context.Context {
+		if accepted, ok := connection.(*abystConnection); ok {
+			ctx = abyst.WithPeerHash(ctx, accepted.peer_hash)
+		}
+		if conn_context != nil {
+			ctx = conn_context(ctx, connection)
+		}
+		return ctx
+	}
+}
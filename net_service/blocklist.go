@@ -0,0 +1,261 @@
+package net_service
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/quic-go/quic-go"
+)
+
+var ErrPeerBlocked = errors.New("peer blocked")
+
+const BLOCKLIST_FILE_EXT = ".blocklist"
+
+type rawBlocklist struct {
+	PeerHashes []string
+	Networks   []string //CIDR
+}
+
+// Blocklist holds banned peer hashes and networks.
+// with a path, the whole file is rewritten on each change, as FilePeerStore does.
+type Blocklist struct {
+	path     string //empty: not persisted
+	peers    map[string]bool
+	networks map[string]*net.IPNet //by CIDR
+
+	mtx *sync.Mutex
+}
+
+func NewBlocklist() *Blocklist {
+	return &Blocklist{
+		peers:    make(map[string]bool),
+		networks: make(map[string]*net.IPNet),
+		mtx:      new(sync.Mutex),
+	}
+}
+
+// a missing file is an empty blocklist; it is created on the first change.
+func NewFileBlocklist(path string) (*Blocklist, error) {
+	result := NewBlocklist()
+	result.path = path
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var raw rawBlocklist
+	if err := cbor.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	for _, peer_hash := range raw.PeerHashes {
+		result.peers[peer_hash] = true
+	}
+	for _, cidr := range raw.Networks {
+		if network, err := parseBlockedNetwork(cidr); err == nil {
+			result.networks[network.String()] = network
+		}
+	}
+	return result, nil
+}
+
+func (b *Blocklist) BlockPeer(peer_hash string) error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.peers[peer_hash] = true
+	return b.save()
+}
+func (b *Blocklist) UnblockPeer(peer_hash string) error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	delete(b.peers, peer_hash)
+	return b.save()
+}
+
+// cidr may also be a single IP address.
+func (b *Blocklist) BlockNetwork(cidr string) error {
+	network, err := parseBlockedNetwork(cidr)
+	if err != nil {
+		return err
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.networks[network.String()] = network
+	return b.save()
+}
+func (b *Blocklist) UnblockNetwork(cidr string) error {
+	network, err := parseBlockedNetwork(cidr)
+	if err != nil {
+		return err
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	delete(b.networks, network.String())
+	return b.save()
+}
+
+func (b *Blocklist) IsPeerBlocked(peer_hash string) bool {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	return b.peers[peer_hash]
+}
+func (b *Blocklist) IsAddressBlocked(ip net.IP) bool {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	for _, network := range b.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseBlockedNetwork(cidr string) (*net.IPNet, error) {
+	if _, network, err := net.ParseCIDR(cidr); err == nil {
+		return network, nil
+	}
+	ip := net.ParseIP(cidr)
+	if ip == nil {
+		return nil, errors.New("invalid network: " + cidr)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// written to a temporary file first, so that a crash never leaves a truncated blocklist.
+func (b *Blocklist) save() error {
+	if b.path == "" {
+		return nil
+	}
+	raw := rawBlocklist{
+		PeerHashes: make([]string, 0, len(b.peers)),
+		Networks:   make([]string, 0, len(b.networks)),
+	}
+	for peer_hash := range b.peers {
+		raw.PeerHashes = append(raw.PeerHashes, peer_hash)
+	}
+	for cidr := range b.networks {
+		raw.Networks = append(raw.Networks, cidr)
+	}
+	data, err := cbor.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	tmp_path := b.path + ".tmp"
+	if err := os.WriteFile(tmp_path, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp_path, b.path)
+}
+
+// SetBlocklist replaces the blocklist, e.g. with one loaded by NewFileBlocklist.
+// peers it bans are disconnected immediately.
+func (h *BetaNetService) SetBlocklist(blocklist *Blocklist) {
+	h.blocklist_mtx.Lock()
+	h.blocklist = blocklist
+	h.blocklist_mtx.Unlock()
+
+	h.enforceBlocklist()
+}
+
+func (h *BetaNetService) getBlocklist() *Blocklist {
+	h.blocklist_mtx.Lock()
+	defer h.blocklist_mtx.Unlock()
+
+	return h.blocklist
+}
+
+func (h *BetaNetService) BlockPeer(peer_hash string) error {
+	err := h.getBlocklist().BlockPeer(peer_hash)
+	h.enforceBlocklist()
+	return err
+}
+func (h *BetaNetService) UnblockPeer(peer_hash string) error {
+	return h.getBlocklist().UnblockPeer(peer_hash)
+}
+func (h *BetaNetService) BlockNetwork(cidr string) error {
+	err := h.getBlocklist().BlockNetwork(cidr)
+	h.enforceBlocklist()
+	return err
+}
+func (h *BetaNetService) UnblockNetwork(cidr string) error {
+	return h.getBlocklist().UnblockNetwork(cidr)
+}
+func (h *BetaNetService) IsPeerBlocked(peer_hash string) bool {
+	return h.getBlocklist().IsPeerBlocked(peer_hash)
+}
+
+func (h *BetaNetService) isAddressBlocked(address net.Addr) bool {
+	udp_address, ok := address.(*net.UDPAddr)
+	return ok && h.getBlocklist().IsAddressBlocked(udp_address.IP)
+}
+
+// banned peers are closed, and forgotten so that they are not dialed again. their abyst connections are closed too.
+func (h *BetaNetService) enforceBlocklist() {
+	blocklist := h.getBlocklist()
+	for _, peer := range h.peers.All() {
+		blocked := blocklist.IsPeerBlocked(peer.IDHash())
+		for _, address := range peer.remoteAddresses() {
+			blocked = blocked || blocklist.IsAddressBlocked(address.IP)
+		}
+		if !blocked {
+			continue
+		}
+		if removed, ok := h.peers.Remove(peer.IDHash()); ok {
+			removed.closeWithError(ABYSS_BLOCKED, ABYSS_BLOCKED_M, ErrPeerBlocked)
+		}
+	}
+
+	h.abyst_mtx.Lock()
+	abyst_connections := make([]*abystConnection, 0, len(h.abyst_conn_peers))
+	for _, connection := range h.abyst_conn_peers {
+		abyst_connections = append(abyst_connections, connection)
+	}
+	h.abyst_mtx.Unlock()
+	for _, connection := range abyst_connections {
+		if blocklist.IsPeerBlocked(connection.peer_hash) || h.isAddressBlocked(connection.RemoteAddr()) {
+			connection.CloseWithError(ABYSS_BLOCKED, ABYSS_BLOCKED_M)
+		}
+	}
+}
+
+// drops candidates in blocked networks.
+func (h *BetaNetService) filterBlockedAddresses(addresses []*net.UDPAddr) []*net.UDPAddr {
+	blocklist := h.getBlocklist()
+	result := make([]*net.UDPAddr, 0, len(addresses))
+	for _, address := range addresses {
+		if !blocklist.IsAddressBlocked(address.IP) {
+			result = append(result, address)
+		}
+	}
+	return result
+}
+
+// addresses of the current connections.
+func (p *AbyssPeer) remoteAddresses() []*net.UDPAddr {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	result := make([]*net.UDPAddr, 0, 2)
+	for _, connection := range []quic.Connection{p.inbound_conn, p.outbound_conn} {
+		if connection != nil {
+			result = append(result, connection.RemoteAddr().(*net.UDPAddr))
+		}
+	}
+	return result
+}
@@ -3,17 +3,19 @@ package net_service
 import (
 	"bytes"
 	"crypto"
-	"crypto/aes"
-	"crypto/cipher"
+	"crypto/ecdsa"
 	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"math/big"
+	"sync"
 	"time"
 
 	"github.com/btcsuite/btcutil/base58"
@@ -25,17 +27,49 @@ type RootSecrets struct {
 	root_self_cert_x509 *x509.Certificate
 	root_self_cert      string //pem
 	root_id_hash        string
+	owner_id_hash       string //the delegating root of a device identity; root_id_hash otherwise
 
-	handshake_priv_key *rsa.PrivateKey //may support others in future
-	handshake_key_cert string          //pem
+	handshake_scheme   HandshakeKeyScheme
+	handshake_secret   *handshakeSecret
+	handshake_key_cert string    //pem
+	handshake_issued   time.Time //NotBefore of handshake_key_cert
+
+	previous_handshake_secret *handshakeSecret //still accepted until previous_handshake_expiry, for in-flight dials
+	previous_handshake_expiry time.Time
+	handshake_mtx             *sync.Mutex
 }
 
 type PrivateKey interface { //stupid but handy interface, golang should change crypto.PrivateKey interface
 	Public() crypto.PublicKey
 }
 
-// To generate root key, use ed25519.GenerateKey(rand.Reader)
+// To generate root key, use ed25519.GenerateKey(rand.Reader).
+// ECDSA P-256/P-384 and RSA (2048 bits or more) root keys are also supported, see CheckRootKey.
 func NewRootIdentity(root_private_key PrivateKey) (*RootSecrets, error) {
+	return NewRootIdentityWithScheme(root_private_key, HANDSHAKE_KEY_MIGRATION)
+}
+
+func NewRootIdentityWithScheme(root_private_key PrivateKey, handshake_scheme HandshakeKeyScheme) (*RootSecrets, error) {
+	return newRootIdentity(root_private_key, handshake_scheme, nil)
+}
+
+// a HANDSHAKE_KEY_MIGRATION identity that starts with the hybrid key only. the RSA key is slow to generate;
+// completeHandshakeKey adds it later. until then, peers that predate the hybrid key cannot dial us.
+func newRootIdentityDeferringRSA(root_private_key PrivateKey) (*RootSecrets, error) {
+	root_secret, err := newRootIdentity(root_private_key, HANDSHAKE_KEY_HYBRID, nil)
+	if err != nil {
+		return nil, err
+	}
+	root_secret.handshake_scheme = HANDSHAKE_KEY_MIGRATION
+	return root_secret, nil
+}
+
+// delegation: the device certificate extension of a device identity. nil otherwise.
+func newRootIdentity(root_private_key PrivateKey, handshake_scheme HandshakeKeyScheme, delegation *deviceDelegation) (*RootSecrets, error) {
+	root_private_key, err := CheckRootKey(root_private_key)
+	if err != nil {
+		return nil, err
+	}
 	root_public_key := root_private_key.Public()
 
 	//root certificate
@@ -61,6 +95,14 @@ func NewRootIdentity(root_private_key PrivateKey) (*RootSecrets, error) {
 		IsCA:                  true,
 		BasicConstraintsValid: true,
 	}
+	owner_hash := peer_hash
+	if delegation != nil {
+		if delegation.device_hash != peer_hash {
+			return nil, errors.New("device certificate is for another key")
+		}
+		r_template.ExtraExtensions = []pkix.Extension{delegation.extension}
+		owner_hash = delegation.owner_id_hash
+	}
 	r_derBytes, err := x509.CreateCertificate(rand.Reader, &r_template, &r_template, root_public_key, root_private_key)
 	if err != nil {
 		return nil, err
@@ -71,38 +113,78 @@ func NewRootIdentity(root_private_key PrivateKey) (*RootSecrets, error) {
 	}
 
 	//handshake key
-	handshake_private_key, err := rsa.GenerateKey(rand.Reader, 2048)
+	handshake_issued := time.Now().Add(time.Duration(-1) * time.Second).Truncate(time.Second) //1-sec backdate, for badly synced peers.
+	handshake_secret, handshake_key_cert, err := newHandshakeKey(root_private_key, r_x509, handshake_scheme, handshake_issued)
 	if err != nil {
 		return nil, err
 	}
-	serialNumber, err = rand.Int(rand.Reader, serialNumberLimit)
+
+	var root_cert_buf bytes.Buffer
+	err = pem.Encode(&root_cert_buf, &pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: r_derBytes,
+	})
 	if err != nil {
 		return nil, err
 	}
+	return &RootSecrets{
+		root_priv_key:       root_private_key,
+		root_self_cert_x509: r_x509,
+		root_self_cert:      root_cert_buf.String(),
+		root_id_hash:        peer_hash,
+		owner_id_hash:       owner_hash,
+
+		handshake_scheme:   handshake_scheme,
+		handshake_secret:   handshake_secret,
+		handshake_key_cert: handshake_key_cert,
+		handshake_issued:   handshake_issued,
+		handshake_mtx:      new(sync.Mutex),
+	}, nil
+}
+
+// a fresh handshake key, certified by the root key. returns the key certificate in pem.
+// NotBefore orders the handshake keys of a root; x509 stores it in whole seconds.
+func newHandshakeKey(root_private_key PrivateKey, root_self_cert_x509 *x509.Certificate, scheme HandshakeKeyScheme, not_before time.Time) (*handshakeSecret, string, error) {
+	handshake_secret, err := newHandshakeSecret(scheme)
+	if err != nil {
+		return nil, "", err
+	}
+	handshake_key_cert, err := certifyHandshakeKey(root_private_key, root_self_cert_x509, handshake_secret, not_before)
+	if err != nil {
+		return nil, "", err
+	}
+	return handshake_secret, handshake_key_cert, nil
+}
+
+func certifyHandshakeKey(root_private_key PrivateKey, root_self_cert_x509 *x509.Certificate, handshake_secret *handshakeSecret, not_before time.Time) (string, error) {
+	peer_hash := root_self_cert_x509.Subject.CommonName
+	subject_suffix, handshake_public_key, hybrid_ext, err := handshake_secret.certificateFields(root_self_cert_x509.PublicKey)
+	if err != nil {
+		return "", err
+	}
+	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128) // 2^128
+	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
+	if err != nil {
+		return "", err
+	}
 	h_template := x509.Certificate{
 		Issuer: pkix.Name{
 			CommonName: peer_hash,
 		},
 		Subject: pkix.Name{
-			CommonName: "H-" + peer_hash + "-OAEP-SHA3-256-AES-256-GCM", //handshake encryption key, RSA OAEP + AES-256 encryption
+			CommonName: "H-" + peer_hash + subject_suffix, //handshake encryption key scheme
 		},
-		NotBefore:             time.Now().Add(time.Duration(-1) * time.Second), //1-sec backdate, for badly synced peers.
+		NotBefore:             not_before,
 		SerialNumber:          serialNumber,
 		KeyUsage:              x509.KeyUsageEncipherOnly,
 		BasicConstraintsValid: true,
 	}
-	h_derBytes, err := x509.CreateCertificate(rand.Reader, &h_template, &r_template, &handshake_private_key.PublicKey, root_private_key)
-	if err != nil {
-		return nil, err
+	if hybrid_ext != nil {
+		h_template.ExtraExtensions = []pkix.Extension{{Id: oidHybridHandshakeKey, Value: hybrid_ext}}
 	}
-
-	var root_cert_buf bytes.Buffer
-	err = pem.Encode(&root_cert_buf, &pem.Block{
-		Type:  "CERTIFICATE",
-		Bytes: r_derBytes,
-	})
+	h_derBytes, err := x509.CreateCertificate(rand.Reader, &h_template, root_self_cert_x509, handshake_public_key, root_private_key)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
 	var handshake_cert_buf bytes.Buffer
@@ -111,19 +193,104 @@ func NewRootIdentity(root_private_key PrivateKey) (*RootSecrets, error) {
 		Bytes: h_derBytes,
 	})
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	return &RootSecrets{
-		root_priv_key:       root_private_key,
-		root_self_cert_x509: r_x509,
-		root_self_cert:      root_cert_buf.String(),
-		root_id_hash:        peer_hash,
+	return handshake_cert_buf.String(), nil
+}
 
-		handshake_priv_key: handshake_private_key,
-		handshake_key_cert: handshake_cert_buf.String(),
-	}, nil
+// RotateHandshakeKey replaces the handshake key.
+// the previous key keeps decrypting handshakes for the grace window, for peers that dial with the old certificate.
+func (r *RootSecrets) RotateHandshakeKey(grace time.Duration) error {
+	r.handshake_mtx.Lock()
+	defer r.handshake_mtx.Unlock()
+
+	handshake_issued := r.nextHandshakeIssued()
+	handshake_secret, handshake_key_cert, err := newHandshakeKey(r.root_priv_key, r.root_self_cert_x509, r.handshake_scheme, handshake_issued)
+	if err != nil {
+		return err
+	}
+
+	r.previous_handshake_secret = r.handshake_secret
+	r.previous_handshake_expiry = time.Now().Add(grace)
+	r.handshake_secret = handshake_secret
+	r.handshake_key_cert = handshake_key_cert
+	r.handshake_issued = handshake_issued
+	return nil
+}
+
+// adds the RSA key to a HANDSHAKE_KEY_MIGRATION handshake key that lacks it, and certifies the result.
+// the hybrid key is kept, so that certificates already handed out stay usable. returns false if there was nothing to add.
+func (r *RootSecrets) completeHandshakeKey() (bool, error) {
+	r.handshake_mtx.Lock()
+	incomplete := r.handshake_scheme == HANDSHAKE_KEY_MIGRATION && r.handshake_secret.rsa == nil
+	r.handshake_mtx.Unlock()
+	if !incomplete {
+		return false, nil
+	}
+
+	rsa_key, err := rsa.GenerateKey(rand.Reader, 2048) //slow; not under the lock.
+	if err != nil {
+		return false, err
+	}
+
+	r.handshake_mtx.Lock()
+	defer r.handshake_mtx.Unlock()
+
+	if r.handshake_secret.rsa != nil || r.handshake_secret.x25519 == nil {
+		return false, nil //rotated meanwhile.
+	}
+	handshake_secret := &handshakeSecret{
+		rsa:    rsa_key,
+		x25519: r.handshake_secret.x25519,
+		mlkem:  r.handshake_secret.mlkem,
+	}
+	handshake_issued := r.nextHandshakeIssued()
+	handshake_key_cert, err := certifyHandshakeKey(r.root_priv_key, r.root_self_cert_x509, handshake_secret, handshake_issued)
+	if err != nil {
+		return false, err
+	}
+	r.handshake_secret = handshake_secret
+	r.handshake_key_cert = handshake_key_cert
+	r.handshake_issued = handshake_issued
+	return true, nil
 }
+
+// strictly newer than the current key, even for rotations within a second. called with handshake_mtx held.
+func (r *RootSecrets) nextHandshakeIssued() time.Time {
+	handshake_issued := time.Now().Add(time.Duration(-1) * time.Second).Truncate(time.Second)
+	if !handshake_issued.After(r.handshake_issued) {
+		handshake_issued = r.handshake_issued.Add(time.Second)
+	}
+	return handshake_issued
+}
+
+// CheckRootKey accepts the supported root key types, as parsed by x509 or ssh.
+// ed25519 keys are normalized to the value type, so that the same key always gives the same ID.
+func CheckRootKey(root_private_key any) (PrivateKey, error) {
+	switch key := root_private_key.(type) {
+	case ed25519.PrivateKey:
+		return key, nil
+	case *ed25519.PrivateKey:
+		return *key, nil
+	case *ecdsa.PrivateKey:
+		if key.Curve != elliptic.P256() && key.Curve != elliptic.P384() {
+			return nil, errors.New("unsupported ECDSA curve")
+		}
+		return key, nil
+	case *rsa.PrivateKey:
+		if key.N.BitLen() < 2048 {
+			return nil, errors.New("RSA root key too short")
+		}
+		return key, nil
+	default:
+		return nil, errors.New("unsupported root key type")
+	}
+}
+
 func AbyssIdFromKey(pub crypto.PublicKey) (string, error) {
+	if key, ok := pub.(*ed25519.PublicKey); ok {
+		pub = *key
+	}
 	derBytes, err := x509.MarshalPKIXPublicKey(pub)
 	if err != nil {
 		return "", fmt.Errorf("unable to marshal public key to DER: %v", err)
@@ -136,43 +303,108 @@ func AbyssIdFromKey(pub crypto.PublicKey) (string, error) {
 func (r *RootSecrets) IDHash() string {
 	return r.root_id_hash
 }
+
+// the identity shared by all devices of the owner. IDHash() if this is not a device identity.
+func (r *RootSecrets) OwnerHash() string {
+	return r.owner_id_hash
+}
+
+// IDHash() of a device identity, empty otherwise.
+func (r *RootSecrets) DeviceID() string {
+	if r.owner_id_hash == r.root_id_hash {
+		return ""
+	}
+	return r.root_id_hash
+}
 func (r *RootSecrets) DecryptHandshake(body []byte) ([]byte, error) {
-	key_block_size := r.handshake_priv_key.Size()
-	aes_key_nonce, err := rsa.DecryptOAEP(sha3.New256(), nil, r.handshake_priv_key, body[:key_block_size], nil)
-	if err != nil {
-		return nil, err
+	r.handshake_mtx.Lock()
+	handshake_secret := r.handshake_secret
+	previous_handshake_secret := r.previous_handshake_secret
+	if time.Now().After(r.previous_handshake_expiry) {
+		previous_handshake_secret = nil
 	}
+	r.handshake_mtx.Unlock()
 
-	block, err := aes.NewCipher(aes_key_nonce[:32])
-	if err != nil {
-		return nil, err
+	plaintext, err := handshake_secret.decrypt(body)
+	if err != nil && previous_handshake_secret != nil {
+		return previous_handshake_secret.decrypt(body)
 	}
-	aesGCM, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, err
+	return plaintext, err
+}
+
+// signs with the root key. verified by PeerIdentity.VerifySignature.
+// the digest matches the signature algorithm of the root certificate.
+func (r *RootSecrets) Sign(payload []byte) ([]byte, error) {
+	signer, ok := r.root_priv_key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("root key cannot sign")
 	}
-	plaintext, err := aesGCM.Open(nil, aes_key_nonce[32:], body[key_block_size:], nil)
+	hash := signatureHash(r.root_self_cert_x509.SignatureAlgorithm)
+	if hash == crypto.Hash(0) { //ed25519 signs the message itself
+		return signer.Sign(rand.Reader, payload, hash)
+	}
+	hasher := hash.New()
+	hasher.Write(payload)
+	return signer.Sign(rand.Reader, hasher.Sum(nil), hash)
+}
 
-	return plaintext, err
+func signatureHash(algorithm x509.SignatureAlgorithm) crypto.Hash {
+	switch algorithm {
+	case x509.ECDSAWithSHA256, x509.SHA256WithRSA:
+		return crypto.SHA256
+	case x509.ECDSAWithSHA384, x509.SHA384WithRSA:
+		return crypto.SHA384
+	case x509.ECDSAWithSHA512, x509.SHA512WithRSA:
+		return crypto.SHA512
+	default:
+		return crypto.Hash(0)
+	}
 }
 func (r *RootSecrets) RootCertificate() string {
 	return r.root_self_cert
 }
 func (r *RootSecrets) HandshakeKeyCertificate() string {
+	r.handshake_mtx.Lock()
+	defer r.handshake_mtx.Unlock()
+
 	return r.handshake_key_cert
 }
+func (r *RootSecrets) handshakeKeyCertificateDer() []byte {
+	block, _ := pem.Decode([]byte(r.HandshakeKeyCertificate()))
+	return block.Bytes
+}
+
+const TLS_IDENTITY_LIFETIME = 7 * 24 * time.Hour
 
 type TLSIdentity struct {
 	priv_key        crypto.PrivateKey
 	tls_self_cert   []byte //der
 	abyss_bind_cert []byte //der
+	not_after       time.Time
 }
 
 func (r *RootSecrets) NewTLSIdentity() (*TLSIdentity, error) {
-	ed25519_public_key, ed25519_private_key, err := ed25519.GenerateKey(rand.Reader)
+	return r.NewTLSIdentityAt(time.Now(), TLS_IDENTITY_LIFETIME)
+}
+
+func (r *RootSecrets) NewTLSIdentityAt(now time.Time, lifetime time.Duration) (*TLSIdentity, error) {
+	_, ed25519_private_key, err := ed25519.GenerateKey(rand.Reader)
 	if err != nil {
 		return nil, err
 	}
+	return r.issueTLSIdentity(ed25519_private_key, now, lifetime)
+}
+
+// RenewTLSIdentity reissues both certificates for the same TLS key.
+// as the key does not change, a binding certificate of either generation matches a TLS certificate of the other,
+// so connections being established across the swap are not affected.
+func (r *RootSecrets) RenewTLSIdentity(identity *TLSIdentity, now time.Time, lifetime time.Duration) (*TLSIdentity, error) {
+	return r.issueTLSIdentity(identity.priv_key.(ed25519.PrivateKey), now, lifetime)
+}
+
+func (r *RootSecrets) issueTLSIdentity(ed25519_private_key ed25519.PrivateKey, now time.Time, lifetime time.Duration) (*TLSIdentity, error) {
+	ed25519_public_key := ed25519_private_key.Public()
+	not_after := now.Add(lifetime)
 
 	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128) // 2^128
 	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
@@ -180,8 +412,8 @@ func (r *RootSecrets) NewTLSIdentity() (*TLSIdentity, error) {
 		return nil, err
 	}
 	self_template := x509.Certificate{
-		NotBefore:             time.Now().Add(time.Duration(-1) * time.Second), //1-sec backdate, for badly synced peers.
-		NotAfter:              time.Now().Add(7 * 24 * time.Hour),              // Valid for 7 days
+		NotBefore:             now.Add(time.Duration(-1) * time.Second), //1-sec backdate, for badly synced peers.
+		NotAfter:              not_after,
 		SerialNumber:          serialNumber,
 		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageCertSign,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
@@ -204,8 +436,8 @@ func (r *RootSecrets) NewTLSIdentity() (*TLSIdentity, error) {
 		Subject: pkix.Name{
 			CommonName: "T-" + r.root_id_hash,
 		},
-		NotBefore:             time.Now().Add(time.Duration(-1) * time.Second), //1-sec backdate, for badly synced peers.
-		NotAfter:              time.Now().Add(7 * 24 * time.Hour),              // Valid for 7 days
+		NotBefore:             now.Add(time.Duration(-1) * time.Second), //1-sec backdate, for badly synced peers.
+		NotAfter:              not_after,
 		SerialNumber:          serialNumber,
 		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
@@ -220,13 +452,27 @@ func (r *RootSecrets) NewTLSIdentity() (*TLSIdentity, error) {
 		priv_key:        ed25519_private_key,
 		tls_self_cert:   self_derBytes,
 		abyss_bind_cert: auth_derBytes,
+		not_after:       not_after,
 	}, nil
 }
 
+func (t *TLSIdentity) NotAfter() time.Time {
+	return t.not_after
+}
+func (t *TLSIdentity) tlsCertificate() *tls.Certificate {
+	return &tls.Certificate{
+		Certificate: [][]byte{t.tls_self_cert},
+		PrivateKey:  t.priv_key,
+	}
+}
+
 type PeerIdentity struct {
 	root_id_hash        string
 	root_self_cert_x509 *x509.Certificate
-	handshake_pub_key   *rsa.PublicKey
+	owner_id_hash       string    //the delegating root of a device; root_id_hash otherwise
+	device_not_after    time.Time //expiry of the device certificate. zero if not a device.
+	handshake_pub_key   *handshakePublicKey
+	handshake_issued    time.Time //NotBefore of the handshake key certificate. only newer keys replace it.
 
 	root_self_cert_der     []byte
 	handshake_key_cert_der []byte
@@ -242,34 +488,36 @@ func NewPeerIdentity(root_self_cert []byte, handshake_key_cert []byte) (*PeerIde
 		return nil, err
 	}
 
-	if root_self_cert_x509.Issuer.CommonName != root_self_cert_x509.Subject.CommonName {
-		return nil, errors.New("invalid root certificate")
-	}
-	peer_hash, err := AbyssIdFromKey(root_self_cert_x509.PublicKey)
+	peer_hash, err := verifyRootCertificate(root_self_cert_x509)
 	if err != nil {
 		return nil, err
 	}
-	if peer_hash != root_self_cert_x509.Issuer.CommonName {
-		return nil, errors.New("invalid root certificate")
+	owner_hash := peer_hash
+	var device_not_after time.Time
+	if delegation, err := parseDeviceDelegation(root_self_cert_x509); err != nil {
+		return nil, err
+	} else if delegation != nil {
+		owner_hash = delegation.owner_id_hash
+		device_not_after = delegation.not_after
 	}
 
 	if handshake_key_cert_x509.Issuer.CommonName != root_self_cert_x509.Issuer.CommonName {
 		return nil, errors.New("issuer mismatch")
 	}
-	if handshake_key_cert_x509.Subject.CommonName != "H-"+root_self_cert_x509.Issuer.CommonName+"-OAEP-SHA3-256-AES-256-GCM" {
-		return nil, errors.New("unsupported public key encryption scheme")
-	}
 	if err := handshake_key_cert_x509.CheckSignatureFrom(root_self_cert_x509); err != nil {
 		return nil, err
 	}
-	pkey, ok := handshake_key_cert_x509.PublicKey.(*rsa.PublicKey)
-	if !ok {
-		return nil, errors.New("unsupported public key")
+	pkey, err := parseHandshakePublicKey(handshake_key_cert_x509, peer_hash)
+	if err != nil {
+		return nil, err
 	}
 	return &PeerIdentity{
 		root_self_cert_x509: root_self_cert_x509,
 		root_id_hash:        peer_hash,
+		owner_id_hash:       owner_hash,
+		device_not_after:    device_not_after,
 		handshake_pub_key:   pkey,
+		handshake_issued:    handshake_key_cert_x509.NotBefore,
 
 		root_self_cert_der:     root_self_cert,
 		handshake_key_cert_der: handshake_key_cert,
@@ -279,35 +527,58 @@ func NewPeerIdentity(root_self_cert []byte, handshake_key_cert []byte) (*PeerIde
 func (p *PeerIdentity) IDHash() string {
 	return p.root_id_hash
 }
-func (p *PeerIdentity) EncryptHandshake(payload []byte) ([]byte, error) {
-	aesKey := make([]byte, 32) //AES-256 key
-	_, err := rand.Read(aesKey)
-	if err != nil {
-		return nil, err
+func (p *PeerIdentity) OwnerHash() string {
+	return p.owner_id_hash
+}
+func (p *PeerIdentity) DeviceID() string {
+	if p.owner_id_hash == p.root_id_hash {
+		return ""
 	}
-	nonce := make([]byte, 12) //AES-GCM nonce
-	_, err = rand.Read(nonce)
-	if err != nil {
-		return nil, err
+	return p.root_id_hash
+}
+
+// self-signed, and named after its key. returns the peer hash.
+func verifyRootCertificate(root_self_cert_x509 *x509.Certificate) (string, error) {
+	if root_self_cert_x509.Issuer.CommonName != root_self_cert_x509.Subject.CommonName {
+		return "", errors.New("invalid root certificate")
 	}
-	block, err := aes.NewCipher(aesKey)
+	peer_hash, err := AbyssIdFromKey(root_self_cert_x509.PublicKey)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	aesGCM, err := cipher.NewGCM(block)
+	if peer_hash != root_self_cert_x509.Issuer.CommonName {
+		return "", errors.New("invalid root certificate")
+	}
+	return peer_hash, nil
+}
+
+// the same identity with a rotated handshake key. the certificate must be signed by the same root, and newer.
+func (p *PeerIdentity) WithHandshakeKey(handshake_key_cert []byte) (*PeerIdentity, error) {
+	next, err := NewPeerIdentity(p.root_self_cert_der, handshake_key_cert)
 	if err != nil {
 		return nil, err
 	}
-
-	encrypted_payload := aesGCM.Seal(nil, nonce, payload, nil)
-
-	encrypted_key_nonce, err := rsa.EncryptOAEP(sha3.New256(), rand.Reader, p.handshake_pub_key, append(aesKey, nonce...), nil)
-	return append(encrypted_key_nonce, encrypted_payload...), err
+	if !next.handshake_issued.After(p.handshake_issued) {
+		return nil, errors.New("handshake key is not newer than the current one")
+	}
+	return next, nil
+}
+func (p *PeerIdentity) VerifySignature(payload []byte, signature []byte) error {
+	return p.root_self_cert_x509.CheckSignature(p.root_self_cert_x509.SignatureAlgorithm, payload, signature)
 }
-func (p *PeerIdentity) VerifyTLSBinding(abyss_bind_cert *x509.Certificate, tls_cert *x509.Certificate) error {
+func (p *PeerIdentity) EncryptHandshake(payload []byte) ([]byte, error) {
+	return p.handshake_pub_key.encrypt(payload)
+}
+func (p *PeerIdentity) VerifyTLSBinding(abyss_bind_cert *x509.Certificate, tls_cert *x509.Certificate, now time.Time) error {
 	if !abyss_bind_cert.PublicKey.(ed25519.PublicKey).Equal(tls_cert.PublicKey) {
 		return errors.New("tls public key mismatch")
 	}
+	if now.After(abyss_bind_cert.NotAfter) {
+		return errors.New("binding certificate expired")
+	}
+	if !p.device_not_after.IsZero() && now.After(p.device_not_after) {
+		return errors.New("device certificate expired")
+	}
 
 	if abyss_bind_cert.Issuer.CommonName != p.root_self_cert_x509.Issuer.CommonName {
 		return errors.New("issuer mismatch")
@@ -183,10 +183,37 @@ func (m *ContextedPeerMap) Find(id string) (*ContextedPeer, bool) {
 	defer m.mtx.Unlock()
 
 	info, ok := m.peers[id]
-	info.Renew()
+	if ok {
+		info.Renew()
+	}
 	return info, ok
 }
 
+// Remove forgets the peer and cancels its context.
+func (m *ContextedPeerMap) Remove(id string) (*ContextedPeer, bool) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	info, ok := m.peers[id]
+	if !ok {
+		return nil, false
+	}
+	delete(m.peers, id)
+	info.cancelfunc()
+	return info, true
+}
+
+func (m *ContextedPeerMap) All() []*ContextedPeer {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	result := make([]*ContextedPeer, 0, len(m.peers))
+	for _, p := range m.peers {
+		result = append(result, p)
+	}
+	return result
+}
+
 func (m *ContextedPeerMap) Wait(ctx context.Context, id string) (*ContextedPeer, error) {
 
 	//***Caution***
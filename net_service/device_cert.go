@@ -0,0 +1,407 @@
+package net_service
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+
+	"github.com/MinwooWebeng/abyss_core/ahmp"
+	abyss "github.com/MinwooWebeng/abyss_core/interfaces"
+)
+
+// A device has its own root key, and thus its own peer hash, so that each device keeps its own connections.
+// The owner root key issues it a device certificate, which the device carries in its root certificate.
+// Peers see one owner identity (OwnerHash) with a device ID.
+const DEVICE_CERTIFICATE_LIFETIME = 365 * 24 * time.Hour
+
+var oidDeviceDelegation = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 59371, 1, 2} //private arc
+
+const device_revocation_sign_prefix = "abyss-device-revocation:"
+
+var ErrDeviceRevoked = errors.New("device revoked")
+var ErrUnknownRevocationOwner = errors.New("device revocation from an unknown owner")
+
+const DEVICE_REVOCATION_MAX_ENTRIES = 1024
+const DEVICE_REVOCATION_MAX_SKEW = time.Minute //revocations further in the future are refused
+
+type rawDeviceDelegation struct { //root certificate extension value
+	DeviceCertificate    []byte
+	OwnerRootCertificate []byte
+}
+
+// body of abyss.DeviceRevocation
+type RawDeviceRevocationBody struct {
+	DeviceHash string
+	TimeStamp  int64 //unix milli
+}
+
+type deviceDelegation struct {
+	owner_id_hash string
+	device_hash   string
+	not_after     time.Time
+	extension     pkix.Extension
+}
+
+// IssueDeviceCertificate certifies a device key as one of this identity's devices.
+// returns the device certificate followed by the owner root certificate, in pem. see NewDeviceIdentity.
+func (r *RootSecrets) IssueDeviceCertificate(device_public_key crypto.PublicKey, lifetime time.Duration) (string, error) {
+	if r.owner_id_hash != r.root_id_hash {
+		return "", errors.New("a device cannot issue device certificates")
+	}
+	device_hash, err := AbyssIdFromKey(device_public_key)
+	if err != nil {
+		return "", err
+	}
+	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128) // 2^128
+	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
+	if err != nil {
+		return "", err
+	}
+	d_template := x509.Certificate{
+		Issuer: pkix.Name{
+			CommonName: r.root_id_hash,
+		},
+		Subject: pkix.Name{
+			CommonName: "D-" + device_hash,
+		},
+		NotBefore:             time.Now().Add(time.Duration(-1) * time.Second), //1-sec backdate, for badly synced peers.
+		NotAfter:              time.Now().Add(lifetime),
+		SerialNumber:          serialNumber,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	d_derBytes, err := x509.CreateCertificate(rand.Reader, &d_template, r.root_self_cert_x509, device_public_key, r.root_priv_key)
+	if err != nil {
+		return "", err
+	}
+
+	var device_cert_buf bytes.Buffer
+	for _, der := range [][]byte{d_derBytes, r.root_self_cert_x509.Raw} {
+		if err := pem.Encode(&device_cert_buf, &pem.Block{Type: "CERTIFICATE", Bytes: der}); err != nil {
+			return "", err
+		}
+	}
+	return device_cert_buf.String(), nil
+}
+
+// NewDeviceIdentity is the identity of a device, certified by IssueDeviceCertificate of the owner.
+func NewDeviceIdentity(device_private_key PrivateKey, device_certificate string, handshake_scheme HandshakeKeyScheme) (*RootSecrets, error) {
+	var raw rawDeviceDelegation
+	device_block, rest := pem.Decode([]byte(device_certificate))
+	owner_block, _ := pem.Decode(rest)
+	if device_block == nil || owner_block == nil {
+		return nil, errors.New("invalid device certificate")
+	}
+	raw.DeviceCertificate = device_block.Bytes
+	raw.OwnerRootCertificate = owner_block.Bytes
+
+	delegation, err := verifyDeviceDelegation(raw, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return newRootIdentity(device_private_key, handshake_scheme, delegation)
+}
+
+// the device certificate carried by a root certificate, verified against the root key. nil if there is none.
+func parseDeviceDelegation(root_self_cert_x509 *x509.Certificate) (*deviceDelegation, error) {
+	for _, ext := range root_self_cert_x509.Extensions {
+		if !ext.Id.Equal(oidDeviceDelegation) {
+			continue
+		}
+		var raw rawDeviceDelegation
+		if _, err := asn1.Unmarshal(ext.Value, &raw); err != nil {
+			return nil, err
+		}
+		delegation, err := verifyDeviceDelegation(raw, time.Time{})
+		if err != nil {
+			return nil, err
+		}
+		if delegation.device_hash != root_self_cert_x509.Subject.CommonName {
+			return nil, errors.New("device certificate is for another key")
+		}
+		return delegation, nil
+	}
+	return nil, nil
+}
+
+// checks that the owner root issued the device certificate. expiry is checked if now is not zero;
+// peers check it in VerifyTLSBinding instead.
+func verifyDeviceDelegation(raw rawDeviceDelegation, now time.Time) (*deviceDelegation, error) {
+	owner_root_x509, err := x509.ParseCertificate(raw.OwnerRootCertificate)
+	if err != nil {
+		return nil, err
+	}
+	device_cert_x509, err := x509.ParseCertificate(raw.DeviceCertificate)
+	if err != nil {
+		return nil, err
+	}
+	owner_hash, err := verifyRootCertificate(owner_root_x509)
+	if err != nil {
+		return nil, err
+	}
+	for _, ext := range owner_root_x509.Extensions {
+		if ext.Id.Equal(oidDeviceDelegation) {
+			return nil, errors.New("device certificate issued by a device")
+		}
+	}
+	if device_cert_x509.Issuer.CommonName != owner_hash {
+		return nil, errors.New("issuer mismatch")
+	}
+	if err := device_cert_x509.CheckSignatureFrom(owner_root_x509); err != nil {
+		return nil, err
+	}
+	device_hash, err := AbyssIdFromKey(device_cert_x509.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if device_cert_x509.Subject.CommonName != "D-"+device_hash {
+		return nil, errors.New("subject mismatch")
+	}
+	if !now.IsZero() && now.After(device_cert_x509.NotAfter) {
+		return nil, errors.New("device certificate expired")
+	}
+
+	ext_value, err := asn1.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &deviceDelegation{
+		owner_id_hash: owner_hash,
+		device_hash:   device_hash,
+		not_after:     device_cert_x509.NotAfter,
+		extension:     pkix.Extension{Id: oidDeviceDelegation, Value: ext_value},
+	}, nil
+}
+
+// RevokeDevice signs a revocation of one of this identity's devices. see BetaNetService.HandleDeviceRevocation.
+func (r *RootSecrets) RevokeDevice(device_hash string) (abyss.DeviceRevocation, error) {
+	if r.owner_id_hash != r.root_id_hash {
+		return abyss.DeviceRevocation{}, errors.New("a device cannot revoke devices")
+	}
+	body, err := cbor.Marshal(RawDeviceRevocationBody{
+		DeviceHash: device_hash,
+		TimeStamp:  time.Now().UnixMilli(),
+	})
+	if err != nil {
+		return abyss.DeviceRevocation{}, err
+	}
+	signature, err := r.Sign(append([]byte(device_revocation_sign_prefix), body...))
+	if err != nil {
+		return abyss.DeviceRevocation{}, err
+	}
+	return abyss.DeviceRevocation{
+		Body:                    body,
+		Signature:               signature,
+		OwnerRootCertificateDer: r.root_self_cert_x509.Raw,
+	}, nil
+}
+
+// returns the owner and the revoked device.
+func VerifyDeviceRevocation(revocation abyss.DeviceRevocation) (string, string, error) {
+	owner_hash, body, err := verifyDeviceRevocation(revocation)
+	return owner_hash, body.DeviceHash, err
+}
+
+func verifyDeviceRevocation(revocation abyss.DeviceRevocation) (string, RawDeviceRevocationBody, error) {
+	owner_root_x509, err := x509.ParseCertificate(revocation.OwnerRootCertificateDer)
+	if err != nil {
+		return "", RawDeviceRevocationBody{}, err
+	}
+	owner_hash, err := verifyRootCertificate(owner_root_x509)
+	if err != nil {
+		return "", RawDeviceRevocationBody{}, err
+	}
+	owner := PeerIdentity{root_self_cert_x509: owner_root_x509}
+	if err := owner.VerifySignature(append([]byte(device_revocation_sign_prefix), revocation.Body...), revocation.Signature); err != nil {
+		return "", RawDeviceRevocationBody{}, err
+	}
+	var body RawDeviceRevocationBody
+	if err := cbor.Unmarshal(revocation.Body, &body); err != nil {
+		return "", RawDeviceRevocationBody{}, err
+	}
+	return owner_hash, body, nil
+}
+
+// RevokeDevice revokes one of the local identity's devices, and tells connected peers.
+func (h *BetaNetService) RevokeDevice(device_hash string) error {
+	revocation, err := h.localIdentity.RevokeDevice(device_hash)
+	if err != nil {
+		return err
+	}
+	return h.HandleDeviceRevocation(revocation)
+}
+
+// HandleDeviceRevocation disconnects the revoked device and refuses it from now on.
+// only revocations by owners we have reason to know are taken: the local owner, a known peer,
+// or the owner of a connected device. anyone can make an owner key, and revocations are kept and passed on.
+// a revocation seen for the first time is persisted, if the service has a PeerStoreDir, and passed on to connected peers.
+// peers that connect later receive it on connection.
+func (h *BetaNetService) HandleDeviceRevocation(revocation abyss.DeviceRevocation) error {
+	owner_hash, body, err := verifyDeviceRevocation(revocation)
+	if err != nil {
+		return err
+	}
+	device_hash := body.DeviceHash
+	issued := time.UnixMilli(body.TimeStamp)
+	if issued.After(time.Now().Add(DEVICE_REVOCATION_MAX_SKEW)) {
+		return errors.New("device revocation from the future")
+	}
+	if isDeviceRevocationExpired(issued) {
+		return nil //the device certificates it could revoke have expired.
+	}
+	if !h.isRevocationOwner(owner_hash) {
+		return ErrUnknownRevocationOwner
+	}
+
+	h.revocation_mtx.Lock()
+	if _, ok := h.revokedDevices[owner_hash+"/"+device_hash]; ok {
+		h.revocation_mtx.Unlock()
+		return nil
+	}
+	h.pruneDeviceRevocations()
+	if len(h.revokedDevices) >= DEVICE_REVOCATION_MAX_ENTRIES {
+		h.revocation_mtx.Unlock()
+		return errors.New("too many device revocations")
+	}
+	h.revokedDevices[owner_hash+"/"+device_hash] = revocation
+	err = h.saveDeviceRevocations()
+	h.revocation_mtx.Unlock()
+
+	for _, peer := range h.peers.All() {
+		if peer.identity.root_id_hash == device_hash && peer.identity.owner_id_hash == owner_hash {
+			peer.closeWithError(ABYSS_DEVICE_REVOKED, ABYSS_DEVICE_REVOKED_M, ErrDeviceRevoked)
+			continue
+		}
+		if peer.IsConnected() {
+			peer._trySend2(ahmp.DRV_T, ahmp.RawDRV{
+				Body:                    revocation.Body,
+				Signature:               revocation.Signature,
+				OwnerRootCertificateDer: revocation.OwnerRootCertificateDer,
+			})
+		}
+	}
+	return err //the revocation is in effect even if it could not be persisted.
+}
+
+// the local owner, a known peer, or the owner of a connected device.
+func (h *BetaNetService) isRevocationOwner(owner_hash string) bool {
+	if owner_hash == h.localIdentity.owner_id_hash {
+		return true
+	}
+	if _, ok := h.peers.Find(owner_hash); ok {
+		return true
+	}
+	h.peer_store_mtx.Lock()
+	store := h.peerStore
+	h.peer_store_mtx.Unlock()
+	if store != nil {
+		if _, ok := store.Get(owner_hash); ok {
+			return true
+		}
+	}
+	for _, peer := range h.peers.All() {
+		if peer.identity.owner_id_hash == owner_hash && peer.IsConnected() {
+			return true
+		}
+	}
+	return false
+}
+
+// a revocation outlives every device certificate issued before it.
+func isDeviceRevocationExpired(issued time.Time) bool {
+	return time.Since(issued) > DEVICE_CERTIFICATE_LIFETIME
+}
+
+// called with revocation_mtx held.
+func (h *BetaNetService) pruneDeviceRevocations() {
+	for key, revocation := range h.revokedDevices {
+		if _, body, err := verifyDeviceRevocation(revocation); err != nil || isDeviceRevocationExpired(time.UnixMilli(body.TimeStamp)) {
+			delete(h.revokedDevices, key)
+		}
+	}
+}
+
+// a revocation applies only to the devices of the owner that signed it.
+func (h *BetaNetService) isRevokedDevice(peer_identity *PeerIdentity) bool {
+	h.revocation_mtx.Lock()
+	defer h.revocation_mtx.Unlock()
+
+	_, ok := h.revokedDevices[peer_identity.owner_id_hash+"/"+peer_identity.root_id_hash]
+	return ok
+}
+
+func (h *BetaNetService) deviceRevocations() []abyss.DeviceRevocation {
+	h.revocation_mtx.Lock()
+	defer h.revocation_mtx.Unlock()
+
+	result := make([]abyss.DeviceRevocation, 0, len(h.revokedDevices))
+	for _, revocation := range h.revokedDevices {
+		result = append(result, revocation)
+	}
+	return result
+}
+
+const REVOCATION_FILE_EXT = ".revocations"
+
+// reads the revocations kept at path, and keeps new ones there. a missing file has none.
+// entries that fail verification, or expired, are dropped.
+func (h *BetaNetService) loadDeviceRevocations(path string) error {
+	h.revocation_mtx.Lock()
+	defer h.revocation_mtx.Unlock()
+
+	h.revocation_path = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var revocations []abyss.DeviceRevocation
+	if err := cbor.Unmarshal(data, &revocations); err != nil {
+		return err
+	}
+	for _, revocation := range revocations {
+		if owner_hash, device_hash, err := VerifyDeviceRevocation(revocation); err == nil {
+			h.revokedDevices[owner_hash+"/"+device_hash] = revocation
+		}
+	}
+	h.pruneDeviceRevocations()
+	for key := range h.revokedDevices { //an edited file cannot lift the cap.
+		if len(h.revokedDevices) <= DEVICE_REVOCATION_MAX_ENTRIES {
+			break
+		}
+		delete(h.revokedDevices, key)
+	}
+	return nil
+}
+
+// called with revocation_mtx held. written to a temporary file first, as the blocklist is.
+func (h *BetaNetService) saveDeviceRevocations() error {
+	if h.revocation_path == "" {
+		return nil
+	}
+	revocations := make([]abyss.DeviceRevocation, 0, len(h.revokedDevices))
+	for _, revocation := range h.revokedDevices {
+		revocations = append(revocations, revocation)
+	}
+	data, err := cbor.Marshal(revocations)
+	if err != nil {
+		return err
+	}
+	tmp_path := h.revocation_path + ".tmp"
+	if err := os.WriteFile(tmp_path, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp_path, h.revocation_path)
+}
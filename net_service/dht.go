@@ -0,0 +1,450 @@
+package net_service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/bits"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/sha3"
+
+	"github.com/MinwooWebeng/abyss_core/ahmp"
+	"github.com/MinwooWebeng/abyss_core/aurl"
+	abyss "github.com/MinwooWebeng/abyss_core/interfaces"
+)
+
+// Kademlia over AHMP. keys are sha3-256 of peer hashes, and the value stored for a key
+// is the signed record of that peer. contacts in the routing table are peer records as well.
+const DHT_K = 8     //bucket size, and the number of closest records returned
+const DHT_ALPHA = 3 //parallel queries per lookup round
+const DHT_RECORD_TTL = time.Hour
+const DHT_REPUBLISH_INTERVAL = time.Minute * 20
+const DHT_QUERY_TIMEOUT = time.Second * 5
+const DHT_LOOKUP_TIMEOUT = time.Second * 15
+const DHT_MAX_RECORDS = 4096
+const DHT_MAX_CLOCK_SKEW = time.Minute //records further in the future are dropped, as they would outlive the TTL
+
+type dhtKey [32]byte
+
+func dhtKeyOf(peer_hash string) dhtKey {
+	return sha3.Sum256([]byte(peer_hash))
+}
+
+func (k dhtKey) distance(o dhtKey) dhtKey {
+	var result dhtKey
+	for i := range k {
+		result[i] = k[i] ^ o[i]
+	}
+	return result
+}
+
+// index of the k-bucket for o: the length of the common prefix with k.
+func (k dhtKey) bucketIndex(o dhtKey) int {
+	d := k.distance(o)
+	for i, b := range d {
+		if b != 0 {
+			return i*8 + bits.LeadingZeros8(b)
+		}
+	}
+	return len(d)*8 - 1 //self. never stored
+}
+
+type dhtPendingQuery struct {
+	peer_hash string
+	ch        chan []abyss.SignedPeerRecord
+}
+
+type DHT struct {
+	local_hash string
+	local_key  dhtKey
+
+	buckets [256][]*PeerRecord     //contacts, least recently seen first
+	records map[string]*PeerRecord //stored values, by peer hash
+	pending map[uuid.UUID]*dhtPendingQuery
+	mtx     *sync.Mutex
+}
+
+func NewDHT(local_hash string) *DHT {
+	return &DHT{
+		local_hash: local_hash,
+		local_key:  dhtKeyOf(local_hash),
+		records:    make(map[string]*PeerRecord),
+		pending:    make(map[uuid.UUID]*dhtPendingQuery),
+		mtx:        new(sync.Mutex),
+	}
+}
+
+// also true for a record stamped too far in the future.
+func isRecordExpired(record *PeerRecord) bool {
+	age := time.Since(record.TimeStamp)
+	return age > DHT_RECORD_TTL || age < -DHT_MAX_CLOCK_SKEW
+}
+
+// updates the routing table. a full bucket drops its least recently seen contact only if
+// that one is gone (expired, or not live); otherwise the newcomer is dropped, as in Kademlia.
+func (d *DHT) touchContact(record *PeerRecord, is_live func(peer_hash string) bool) {
+	if record.AURL.Hash == d.local_hash || isRecordExpired(record) {
+		return
+	}
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	bucket_index := d.local_key.bucketIndex(dhtKeyOf(record.AURL.Hash))
+	bucket := d.buckets[bucket_index]
+	if i := slices.IndexFunc(bucket, func(c *PeerRecord) bool { return c.AURL.Hash == record.AURL.Hash }); i != -1 {
+		if bucket[i].TimeStamp.After(record.TimeStamp) {
+			record = bucket[i]
+		}
+		bucket = slices.Delete(bucket, i, i+1)
+	} else if len(bucket) >= DHT_K {
+		if !isRecordExpired(bucket[0]) && is_live(bucket[0].AURL.Hash) {
+			return
+		}
+		bucket = bucket[1:]
+	}
+	d.buckets[bucket_index] = append(bucket, record)
+}
+
+// keeps the newest record of each peer.
+func (d *DHT) store(record *PeerRecord) bool {
+	if isRecordExpired(record) {
+		return false
+	}
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if prev, ok := d.records[record.AURL.Hash]; ok {
+		if !prev.TimeStamp.Before(record.TimeStamp) {
+			return false
+		}
+	} else if len(d.records) >= DHT_MAX_RECORDS {
+		return false
+	}
+	d.records[record.AURL.Hash] = record
+	return true
+}
+
+// the stored or contact record of the peer, if fresh.
+func (d *DHT) find(peer_hash string) (*PeerRecord, bool) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if record, ok := d.records[peer_hash]; ok && !isRecordExpired(record) {
+		return record, true
+	}
+	bucket := d.buckets[d.local_key.bucketIndex(dhtKeyOf(peer_hash))]
+	if i := slices.IndexFunc(bucket, func(c *PeerRecord) bool { return c.AURL.Hash == peer_hash }); i != -1 && !isRecordExpired(bucket[i]) {
+		return bucket[i], true
+	}
+	return nil, false
+}
+
+// up to n fresh contacts, closest to the target first.
+func (d *DHT) closest(target_hash string, n int) []*PeerRecord {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	result := make([]*PeerRecord, 0, n)
+	for _, bucket := range d.buckets {
+		for _, contact := range bucket {
+			if !isRecordExpired(contact) {
+				result = append(result, contact)
+			}
+		}
+	}
+	sortByDistance(result, dhtKeyOf(target_hash))
+	if len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
+
+func sortByDistance(records []*PeerRecord, target dhtKey) {
+	slices.SortFunc(records, func(a *PeerRecord, b *PeerRecord) int {
+		a_distance := target.distance(dhtKeyOf(a.AURL.Hash))
+		b_distance := target.distance(dhtKeyOf(b.AURL.Hash))
+		return bytes.Compare(a_distance[:], b_distance[:])
+	})
+}
+
+// ResolvePeer finds the signed record of the peer, locally or by an iterative lookup,
+// and registers the peer as known.
+func (h *BetaNetService) ResolvePeer(ctx context.Context, peer_hash string) (*aurl.AURL, error) {
+	record, err := h.lookup(ctx, peer_hash)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := h.appendPeer(record.identity); err != nil {
+		return nil, err
+	}
+	return record.AURL, nil
+}
+
+func (h *BetaNetService) lookup(ctx context.Context, target_hash string) (*PeerRecord, error) {
+	if record, ok := h.dht.find(target_hash); ok {
+		return record, nil
+	}
+
+	target_key := dhtKeyOf(target_hash)
+	shortlist := h.dht.closest(target_hash, DHT_K)
+	queried := make(map[string]bool)
+	for {
+		round := make([]*PeerRecord, 0, DHT_ALPHA)
+		for _, contact := range shortlist {
+			if len(round) == DHT_ALPHA {
+				break
+			}
+			if !queried[contact.AURL.Hash] {
+				queried[contact.AURL.Hash] = true
+				round = append(round, contact)
+			}
+		}
+		if len(round) == 0 {
+			return nil, errors.New("peer not found")
+		}
+
+		results := make(chan []*PeerRecord, len(round))
+		for _, contact := range round {
+			go func() {
+				results <- h.queryContact(ctx, contact, target_hash)
+			}()
+		}
+		for range round {
+			for _, record := range <-results {
+				if record.AURL.Hash == target_hash {
+					h.dht.store(record)
+					return record, nil
+				}
+				h.dht.touchContact(record, h.isContactLive)
+				if record.AURL.Hash != h.dht.local_hash && !slices.ContainsFunc(shortlist, func(c *PeerRecord) bool { return c.AURL.Hash == record.AURL.Hash }) {
+					shortlist = append(shortlist, record)
+				}
+			}
+		}
+		sortByDistance(shortlist, target_key)
+		if len(shortlist) > DHT_K {
+			shortlist = shortlist[:DHT_K]
+		}
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// returns the verified records the contact answered with. nil on any failure.
+func (h *BetaNetService) queryContact(ctx context.Context, contact *PeerRecord, target_hash string) []*PeerRecord {
+	ctx, cancel := context.WithTimeout(ctx, DHT_QUERY_TIMEOUT)
+	defer cancel()
+
+	peer, err := h.connectContact(ctx, contact)
+	if err != nil {
+		return nil
+	}
+
+	query_id := uuid.New()
+	query := &dhtPendingQuery{
+		peer_hash: contact.AURL.Hash,
+		ch:        make(chan []abyss.SignedPeerRecord, 1),
+	}
+	h.dht.mtx.Lock()
+	h.dht.pending[query_id] = query
+	h.dht.mtx.Unlock()
+	defer func() {
+		h.dht.mtx.Lock()
+		delete(h.dht.pending, query_id)
+		h.dht.mtx.Unlock()
+	}()
+
+	if !peer._trySend2(ahmp.DFQ_T, ahmp.RawDFQ{
+		QueryID:    query_id.String(),
+		TargetHash: target_hash,
+	}) {
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case signed_records := <-query.ch:
+		result := make([]*PeerRecord, 0, len(signed_records))
+		for _, signed := range signed_records {
+			if record, err := VerifyPeerRecord(signed); err == nil && !isRecordExpired(record) {
+				result = append(result, record)
+			}
+		}
+		return result
+	}
+}
+
+// dials the contact if needed, and waits until the connection is usable.
+func (h *BetaNetService) connectContact(ctx context.Context, contact *PeerRecord) (*ContextedPeer, error) {
+	peer, err := h.contactPeer(contact.identity)
+	if err != nil {
+		return nil, err
+	}
+	if peer.IsConnected() {
+		return peer, nil
+	}
+
+	candidate_addresses := h.addressSelector.FilterAddressCandidates(contact.AURL.Addresses)
+	if len(candidate_addresses) == 0 {
+		return nil, errors.New("no valid IP address")
+	}
+	go h.PrepareAbyssOutbound(peer, candidate_addresses)
+	for !peer.IsConnected() {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Millisecond * 50):
+		}
+	}
+	return peer, nil
+}
+
+func (h *BetaNetService) isContactLive(peer_hash string) bool {
+	peer, ok := h.peers.Find(peer_hash)
+	return ok && peer.IsConnected()
+}
+
+// a contact joins the session only, so that it can be dialed; it is not written to the peer store.
+// a peer the store knows goes through appendPeer, which checks its handshake key.
+func (h *BetaNetService) contactPeer(identity *PeerIdentity) (*ContextedPeer, error) {
+	if peer, ok := h.peers.Find(identity.root_id_hash); ok {
+		return peer, nil
+	}
+	if h.isRevokedDevice(identity) {
+		return nil, ErrDeviceRevoked
+	}
+	if h.IsPeerBlocked(identity.root_id_hash) {
+		return nil, ErrPeerBlocked
+	}
+
+	h.peer_store_mtx.Lock()
+	store := h.peerStore
+	h.peer_store_mtx.Unlock()
+	if store != nil {
+		if _, known := store.Get(identity.root_id_hash); known {
+			if _, err := h.appendPeer(identity); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if peer, ok := h.peers.Append(h.ctx, identity.root_id_hash, NewAbyssPeer(*identity)); ok {
+		return peer, nil
+	}
+	if peer, ok := h.peers.Find(identity.root_id_hash); ok {
+		return peer, nil
+	}
+	return nil, errors.New("unknown peer")
+}
+
+func (h *BetaNetService) HandleDHTQuery(peer_hash string, query_id uuid.UUID, target_hash string) error {
+	peer, ok := h.peers.Find(peer_hash)
+	if !ok {
+		return errors.New("unknown peer")
+	}
+
+	records := make([]ahmp.RawPeerRecord, 0, DHT_K+1)
+	if target_hash == h.localIdentity.root_id_hash {
+		local_record, err := h.newLocalPeerRecord()
+		if err != nil {
+			return err
+		}
+		records = append(records, ahmp.RawPeerRecord(local_record))
+	} else if record, ok := h.dht.find(target_hash); ok {
+		records = append(records, ahmp.RawPeerRecord(record.signed))
+	}
+	for _, contact := range h.dht.closest(target_hash, DHT_K) {
+		if contact.AURL.Hash != target_hash {
+			records = append(records, ahmp.RawPeerRecord(contact.signed))
+		}
+	}
+
+	if !peer._trySend2(ahmp.DFR_T, ahmp.RawDFR{
+		QueryID: query_id.String(),
+		Records: records,
+	}) {
+		return errors.New("failed to send DHT response")
+	}
+	return nil
+}
+
+func (h *BetaNetService) HandleDHTResponse(peer_hash string, query_id uuid.UUID, records []abyss.SignedPeerRecord) error {
+	h.dht.mtx.Lock()
+	query, ok := h.dht.pending[query_id]
+	h.dht.mtx.Unlock()
+	if !ok || query.peer_hash != peer_hash {
+		return errors.New("unexpected DHT response")
+	}
+
+	select {
+	case query.ch <- records:
+	default:
+	}
+	return nil
+}
+
+// a record from its owner also makes the owner a contact.
+func (h *BetaNetService) HandleDHTStore(peer_hash string, signed abyss.SignedPeerRecord) error {
+	record, err := VerifyPeerRecord(signed)
+	if err != nil {
+		return err
+	}
+	if record.AURL.Hash == peer_hash {
+		h.dht.touchContact(record, h.isContactLive)
+	}
+	h.dht.store(record)
+	return nil
+}
+
+// sends the local record to the contacts closest to us, so that others can find it.
+func (h *BetaNetService) dhtRepublishLoop() {
+	for {
+		select {
+		case <-h.ctx.Done():
+			return
+		case <-time.After(DHT_REPUBLISH_INTERVAL):
+		}
+
+		h.dhtPublishLocalRecord()
+	}
+}
+
+// stores a fresh local record at the closest connected contacts.
+func (h *BetaNetService) dhtPublishLocalRecord() {
+	local_record, err := h.newLocalPeerRecord()
+	if err != nil {
+		return
+	}
+	for _, contact := range h.dht.closest(h.localIdentity.root_id_hash, DHT_K) {
+		if peer, ok := h.peers.Find(contact.AURL.Hash); ok && peer.IsConnected() {
+			peer._trySend2(ahmp.DST_T, ahmp.RawDST{
+				Record: ahmp.RawPeerRecord(local_record),
+			})
+		}
+	}
+}
+
+// resolves the peer in the DHT, then dials it. for AURLs without usable addresses, or of unknown peers.
+func (h *BetaNetService) connectResolvedAsync(url *aurl.AURL) {
+	ctx, cancel := context.WithTimeout(h.ctx, DHT_LOOKUP_TIMEOUT)
+	defer cancel()
+
+	resolved, err := h.ResolvePeer(ctx, url.Hash)
+	if err != nil {
+		return
+	}
+	peer, ok := h.peers.Find(url.Hash)
+	if !ok {
+		return
+	}
+	h.connectKnown(peer, resolved, h.addressSelector.FilterAddressCandidates(resolved.Addresses))
+}
@@ -0,0 +1,293 @@
+package net_service
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/mlkem"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// The dialer encrypts its first handshake payload to the accepter's handshake key.
+// During the migration, a handshake key certificate carries an RSA-OAEP key for older peers,
+// and offers the X25519 + ML-KEM-768 hybrid key in an extension. Dialers that understand it use the hybrid.
+type HandshakeKeyScheme int
+
+const (
+	HANDSHAKE_KEY_MIGRATION HandshakeKeyScheme = iota //RSA-OAEP certificate that also offers the hybrid key
+	HANDSHAKE_KEY_HYBRID                              //X25519 + ML-KEM-768 only. peers that predate it cannot dial us.
+	HANDSHAKE_KEY_RSA                                 //RSA-OAEP only
+)
+
+const HANDSHAKE_RSA_SUFFIX = "-OAEP-SHA3-256-AES-256-GCM"
+const HANDSHAKE_HYBRID_SUFFIX = "-X25519-MLKEM768-HKDF-SHA3-256-AES-256-GCM"
+
+var oidHybridHandshakeKey = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 59371, 1, 1} //private arc
+
+// hybrid encrypted handshakes start with this. RSA ones have no prefix.
+var hybrid_handshake_magic = []byte("AHK2")
+
+const hybrid_handshake_header_size = 4 + 32 + mlkem.CiphertextSize768
+
+type rawHybridHandshakeKey struct { //certificate extension value
+	X25519   []byte
+	MLKEM768 []byte
+}
+
+type handshakeSecret struct { //the keys present depend on the scheme
+	rsa    *rsa.PrivateKey
+	x25519 *ecdh.PrivateKey
+	mlkem  *mlkem.DecapsulationKey768
+}
+
+type handshakePublicKey struct {
+	rsa    *rsa.PublicKey
+	x25519 *ecdh.PublicKey
+	mlkem  *mlkem.EncapsulationKey768
+}
+
+func newHandshakeSecret(scheme HandshakeKeyScheme) (*handshakeSecret, error) {
+	result := new(handshakeSecret)
+	var err error
+	if scheme != HANDSHAKE_KEY_HYBRID {
+		if result.rsa, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			return nil, err
+		}
+	}
+	if scheme != HANDSHAKE_KEY_RSA {
+		if result.x25519, err = ecdh.X25519().GenerateKey(rand.Reader); err != nil {
+			return nil, err
+		}
+		if result.mlkem, err = mlkem.GenerateKey768(); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+type rawHandshakeSecret struct { //absent keys are empty
+	RSA      []byte //PKCS #1
+	X25519   []byte
+	MLKEM768 []byte //seed
+}
+
+func (s *handshakeSecret) marshal() rawHandshakeSecret {
+	var result rawHandshakeSecret
+	if s.rsa != nil {
+		result.RSA = x509.MarshalPKCS1PrivateKey(s.rsa)
+	}
+	if s.x25519 != nil {
+		result.X25519 = s.x25519.Bytes()
+		result.MLKEM768 = s.mlkem.Bytes()
+	}
+	return result
+}
+
+func unmarshalHandshakeSecret(raw rawHandshakeSecret) (*handshakeSecret, error) {
+	result := new(handshakeSecret)
+	var err error
+	if len(raw.RSA) != 0 {
+		if result.rsa, err = x509.ParsePKCS1PrivateKey(raw.RSA); err != nil {
+			return nil, err
+		}
+	}
+	if len(raw.X25519) != 0 {
+		if result.x25519, err = ecdh.X25519().NewPrivateKey(raw.X25519); err != nil {
+			return nil, err
+		}
+		if result.mlkem, err = mlkem.NewDecapsulationKey768(raw.MLKEM768); err != nil {
+			return nil, err
+		}
+	}
+	if result.rsa == nil && result.x25519 == nil {
+		return nil, errors.New("empty handshake key")
+	}
+	return result, nil
+}
+
+// subject suffix, public key and hybrid key extension (nil if none) of the handshake key certificate.
+// x509 cannot carry X25519 keys, so a hybrid-only certificate repeats the root public key, and the extension is its only key.
+func (s *handshakeSecret) certificateFields(root_public_key any) (string, any, []byte, error) {
+	var hybrid_ext []byte
+	if s.x25519 != nil {
+		ext, err := asn1.Marshal(rawHybridHandshakeKey{
+			X25519:   s.x25519.PublicKey().Bytes(),
+			MLKEM768: s.mlkem.EncapsulationKey().Bytes(),
+		})
+		if err != nil {
+			return "", nil, nil, err
+		}
+		hybrid_ext = ext
+	}
+	if s.rsa != nil {
+		return HANDSHAKE_RSA_SUFFIX, &s.rsa.PublicKey, hybrid_ext, nil
+	}
+	return HANDSHAKE_HYBRID_SUFFIX, root_public_key, hybrid_ext, nil
+}
+
+func (s *handshakeSecret) decrypt(body []byte) ([]byte, error) {
+	if s.x25519 != nil && len(body) >= hybrid_handshake_header_size && bytes.HasPrefix(body, hybrid_handshake_magic) {
+		plaintext, err := s.decryptHybrid(body)
+		if err == nil || s.rsa == nil {
+			return plaintext, err
+		}
+		//an RSA handshake may start with the magic by chance.
+	}
+	if s.rsa == nil {
+		return nil, errors.New("unsupported handshake encryption")
+	}
+
+	key_block_size := s.rsa.Size()
+	if len(body) < key_block_size {
+		return nil, errors.New("handshake too short")
+	}
+	aes_key_nonce, err := rsa.DecryptOAEP(sha3.New256(), nil, s.rsa, body[:key_block_size], nil)
+	if err != nil {
+		return nil, err
+	}
+	return openHandshake(aes_key_nonce, body[key_block_size:])
+}
+
+func (s *handshakeSecret) decryptHybrid(body []byte) ([]byte, error) {
+	ephemeral_public_key, err := ecdh.X25519().NewPublicKey(body[4:36])
+	if err != nil {
+		return nil, err
+	}
+	x25519_shared, err := s.x25519.ECDH(ephemeral_public_key)
+	if err != nil {
+		return nil, err
+	}
+	mlkem_ciphertext := body[36:hybrid_handshake_header_size]
+	mlkem_shared, err := s.mlkem.Decapsulate(mlkem_ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	aes_key_nonce, err := hybridHandshakeKey(mlkem_shared, x25519_shared, body[4:36], mlkem_ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	return openHandshake(aes_key_nonce, body[hybrid_handshake_header_size:])
+}
+
+// reads the handshake key from a certificate, already checked to be issued by the peer root.
+func parseHandshakePublicKey(cert *x509.Certificate, peer_hash string) (*handshakePublicKey, error) {
+	result := new(handshakePublicKey)
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidHybridHandshakeKey) {
+			continue
+		}
+		var raw rawHybridHandshakeKey
+		if _, err := asn1.Unmarshal(ext.Value, &raw); err != nil {
+			return nil, err
+		}
+		x25519_public_key, err := ecdh.X25519().NewPublicKey(raw.X25519)
+		if err != nil {
+			return nil, err
+		}
+		mlkem_public_key, err := mlkem.NewEncapsulationKey768(raw.MLKEM768)
+		if err != nil {
+			return nil, err
+		}
+		result.x25519 = x25519_public_key
+		result.mlkem = mlkem_public_key
+	}
+
+	switch cert.Subject.CommonName {
+	case "H-" + peer_hash + HANDSHAKE_RSA_SUFFIX:
+		pkey, ok := cert.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			return nil, errors.New("unsupported public key")
+		}
+		result.rsa = pkey
+	case "H-" + peer_hash + HANDSHAKE_HYBRID_SUFFIX:
+		if result.x25519 == nil {
+			return nil, errors.New("missing hybrid handshake key")
+		}
+	default:
+		return nil, errors.New("unsupported public key encryption scheme")
+	}
+	return result, nil
+}
+
+// the hybrid key is preferred whenever the peer offers it.
+func (k *handshakePublicKey) encrypt(payload []byte) ([]byte, error) {
+	aes_key_nonce := make([]byte, 44) //AES-256 key, AES-GCM nonce
+	var header []byte
+	if k.x25519 != nil {
+		ephemeral_private_key, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		x25519_shared, err := ephemeral_private_key.ECDH(k.x25519)
+		if err != nil {
+			return nil, err
+		}
+		mlkem_shared, mlkem_ciphertext := k.mlkem.Encapsulate()
+		ephemeral_public_key := ephemeral_private_key.PublicKey().Bytes()
+		aes_key_nonce, err = hybridHandshakeKey(mlkem_shared, x25519_shared, ephemeral_public_key, mlkem_ciphertext)
+		if err != nil {
+			return nil, err
+		}
+		header = append(append(bytes.Clone(hybrid_handshake_magic), ephemeral_public_key...), mlkem_ciphertext...)
+	} else {
+		if _, err := rand.Read(aes_key_nonce); err != nil {
+			return nil, err
+		}
+		encrypted_key_nonce, err := rsa.EncryptOAEP(sha3.New256(), rand.Reader, k.rsa, aes_key_nonce, nil)
+		if err != nil {
+			return nil, err
+		}
+		header = encrypted_key_nonce
+	}
+
+	block, err := aes.NewCipher(aes_key_nonce[:32])
+	if err != nil {
+		return nil, err
+	}
+	aesGCM, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return aesGCM.Seal(header, aes_key_nonce[32:], payload, nil), nil
+}
+
+// both shared secrets feed the key; breaking one of X25519 or ML-KEM is not enough.
+func hybridHandshakeKey(mlkem_shared []byte, x25519_shared []byte, ephemeral_public_key []byte, mlkem_ciphertext []byte) ([]byte, error) {
+	secret := append(bytes.Clone(mlkem_shared), x25519_shared...)
+	info := "abyss-handshake" + string(ephemeral_public_key) + string(mlkem_ciphertext)
+	return hkdf.Key(sha3.New256, secret, nil, info, 44)
+}
+
+func openHandshake(aes_key_nonce []byte, ciphertext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(aes_key_nonce[:32])
+	if err != nil {
+		return nil, err
+	}
+	aesGCM, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return aesGCM.Open(nil, aes_key_nonce[32:], ciphertext, nil)
+}
+
+// the public key that this secret decrypts for.
+func (s *handshakeSecret) matches(k *handshakePublicKey) bool {
+	if (s.rsa == nil) != (k.rsa == nil) || (s.x25519 == nil) != (k.x25519 == nil) {
+		return false
+	}
+	if s.rsa != nil && !s.rsa.PublicKey.Equal(k.rsa) {
+		return false
+	}
+	if s.x25519 != nil {
+		return s.x25519.PublicKey().Equal(k.x25519) && bytes.Equal(s.mlkem.EncapsulationKey().Bytes(), k.mlkem.Bytes())
+	}
+	return true
+}
@@ -0,0 +1,85 @@
+package net_service
+
+import (
+	"bytes"
+	"errors"
+	"time"
+
+	"github.com/MinwooWebeng/abyss_core/ahmp"
+)
+
+// RotateHandshakeKey replaces the local handshake key, and tells connected peers and the DHT.
+// peers that cached the old certificate can still dial us during the grace window.
+func (h *BetaNetService) RotateHandshakeKey(grace time.Duration) error {
+	if err := h.localIdentity.RotateHandshakeKey(grace); err != nil {
+		return err
+	}
+	if h.identity_state_path != "" {
+		if err := saveIdentityState(h.localIdentity, h.identity_state_path); err != nil {
+			return err
+		}
+	}
+	h.announceHandshakeKey()
+	return nil
+}
+
+// adds the deferred RSA key of a new HANDSHAKE_KEY_MIGRATION identity, off the start path.
+func (h *BetaNetService) completeHandshakeKey() {
+	if completed, err := h.localIdentity.completeHandshakeKey(); err == nil && completed {
+		h.announceHandshakeKey()
+	}
+}
+
+// sends the current handshake key certificate to connected peers and the DHT.
+func (h *BetaNetService) announceHandshakeKey() {
+	handshake_key_cert := h.localIdentity.handshakeKeyCertificateDer()
+	for _, peer := range h.peers.All() {
+		if peer.IsConnected() {
+			peer._trySend2(ahmp.HKR_T, ahmp.RawHKR{
+				HandshakeKeyCertificateDer: handshake_key_cert,
+			})
+		}
+	}
+	h.dhtPublishLocalRecord()
+}
+
+// HandleHandshakeKeyRotation is called when a connected peer announces its new handshake key.
+func (h *BetaNetService) HandleHandshakeKeyRotation(peer_hash string, handshake_key_cert []byte) error {
+	peer, ok := h.peers.Find(peer_hash)
+	if !ok {
+		return errors.New("unknown peer")
+	}
+	changed, err := peer.updateHandshakeKey(handshake_key_cert)
+	if err != nil || !changed {
+		return err
+	}
+	return h.storeHandshakeKey(peer_hash, handshake_key_cert)
+}
+
+// replaces the handshake key if the certificate is signed by the peer's root, and newer than the current one.
+// returns true if the key has changed.
+func (p *AbyssPeer) updateHandshakeKey(handshake_key_cert []byte) (bool, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if bytes.Equal(p.identity.handshake_key_cert_der, handshake_key_cert) {
+		return false, nil
+	}
+	next, err := p.identity.WithHandshakeKey(handshake_key_cert)
+	if err != nil {
+		return false, err
+	}
+	p.identity.handshake_pub_key = next.handshake_pub_key
+	p.identity.handshake_issued = next.handshake_issued
+	p.identity.handshake_key_cert_der = next.handshake_key_cert_der
+	return true, nil
+}
+
+// a copy of the identity, safe to use while the handshake key rotates.
+func (p *AbyssPeer) handshakeIdentity() *PeerIdentity {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	identity := p.identity
+	return &identity
+}
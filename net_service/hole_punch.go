@@ -0,0 +1,189 @@
+package net_service
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/MinwooWebeng/abyss_core/ahmp"
+	"github.com/MinwooWebeng/abyss_core/aurl"
+)
+
+const HOLE_PUNCH_DELAY_MS = 200   //both sides wait this long after the notice, then dial at once
+const HOLE_PUNCH_PACKET_COUNT = 3 //non-QUIC packets sent ahead of the dial, to open local NAT mappings
+const PUNCH_EXPECTATION_TIMEOUT = time.Minute
+
+// ConnectAbyssRendezvousAsync dials the peer directly, and if that fails,
+// asks the rendezvous peer (connected to both sides) to coordinate a UDP hole punch.
+// a peer that advertises relays is asked for through them instead.
+func (h *BetaNetService) ConnectAbyssRendezvousAsync(url *aurl.AURL, rendezvous_hash string) error {
+	if url.Scheme != "abyss" {
+		return errors.New("url scheme mismatch")
+	}
+	if h.IsPeerBlocked(url.Hash) {
+		return ErrPeerBlocked
+	}
+
+	peer, ok := h.peers.Find(url.Hash)
+	if !ok {
+		return errors.New("unknown peer")
+	}
+
+	peer.setRelays(url.Relays)
+
+	//the other side, introduced by the same rendezvous, may be the one that asks.
+	h.expectPunchNotice(rendezvous_hash, url.Hash)
+	for _, relay_hash := range url.Relays {
+		h.expectPunchNotice(relay_hash, url.Hash)
+	}
+
+	candidate_addresses := h.addressSelector.FilterAddressCandidates(url.Addresses)
+	go func() {
+		if len(candidate_addresses) != 0 && h.PrepareAbyssOutbound(peer, candidate_addresses) == nil {
+			return
+		}
+		if peer.IsConnected() {
+			return
+		}
+		if len(url.Relays) != 0 && h.requestRelay(url.Relays, url.Hash) {
+			return
+		}
+		h.requestHolePunch(rendezvous_hash, url.Hash)
+	}()
+	return nil
+}
+
+func (h *BetaNetService) requestHolePunch(rendezvous_hash string, target_hash string) bool {
+	rendezvous, ok := h.peers.Find(rendezvous_hash)
+	if !ok {
+		return false
+	}
+	h.expectPunchNotice(rendezvous_hash, target_hash)
+	return rendezvous._trySend2(ahmp.PRQ_T, ahmp.RawPRQ{
+		TargetHash: target_hash,
+	})
+}
+
+// HandlePunchRequest is called on the rendezvous side.
+// it tells each side where the other one is, as observed from here.
+func (h *BetaNetService) HandlePunchRequest(requester_hash string, target_hash string) error {
+	requester, ok := h.peers.Find(requester_hash)
+	if !ok || !requester.IsConnected() {
+		return errors.New("punch requester not connected")
+	}
+	target, ok := h.peers.Find(target_hash)
+	if !ok || !target.IsConnected() {
+		return errors.New("punch target not connected")
+	}
+
+	target_sent := target._trySend2(ahmp.PNC_T, requester.rawPunchNotice())
+	requester_sent := requester._trySend2(ahmp.PNC_T, target.rawPunchNotice())
+	if !target_sent || !requester_sent {
+		return errors.New("failed to send punch notice")
+	}
+	return nil
+}
+
+func (p *AbyssPeer) rawPunchNotice() ahmp.RawPNC {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	addresses := make([]string, 0, len(p.addresses)+1)
+	addresses = append(addresses, p.inbound_conn.RemoteAddr().String()) //reflexive address comes first
+	for _, address := range p.addresses {
+		addresses = append(addresses, address.String())
+	}
+	return ahmp.RawPNC{
+		PeerHash:                   p.identity.root_id_hash,
+		Addresses:                  addresses,
+		RootCertificateDer:         p.identity.root_self_cert_der,
+		HandshakeKeyCertificateDer: p.identity.handshake_key_cert_der,
+		DelayMs:                    HOLE_PUNCH_DELAY_MS,
+	}
+}
+
+// a punch notice for target_hash is taken from rendezvous_hash until the timeout.
+func (h *BetaNetService) expectPunchNotice(rendezvous_hash string, target_hash string) {
+	h.relay_mtx.Lock()
+	defer h.relay_mtx.Unlock()
+
+	t_now := time.Now()
+	for key, expiry := range h.punch_expectations {
+		if t_now.After(expiry) {
+			delete(h.punch_expectations, key)
+		}
+	}
+	h.punch_expectations[rendezvous_hash+"/"+target_hash] = t_now.Add(PUNCH_EXPECTATION_TIMEOUT)
+}
+
+// we asked rendezvous_hash for target_hash, or were introduced to it there,
+// or rendezvous_hash is one of our relay candidates, which relays towards us on others' request.
+func (h *BetaNetService) isPunchNoticeExpected(rendezvous_hash string, target_hash string) bool {
+	h.relay_mtx.Lock()
+	expiry, ok := h.punch_expectations[rendezvous_hash+"/"+target_hash]
+	h.relay_mtx.Unlock()
+	if ok && time.Now().Before(expiry) {
+		return true
+	}
+
+	h.local_aurl_mtx.Lock()
+	defer h.local_aurl_mtx.Unlock()
+
+	for _, relay_hash := range h.relay_candidates {
+		if relay_hash == rendezvous_hash {
+			return true
+		}
+	}
+	return false
+}
+
+// HandlePunchNotice is called on both sides of the punch, with the notice from rendezvous_hash.
+// notices that we did not expect from it are dropped.
+// after the delay, it opens the local NAT mapping towards the peer and dials it.
+func (h *BetaNetService) HandlePunchNotice(rendezvous_hash string, peer_hash string, root_cert []byte, handshake_key_cert []byte, addresses []*net.UDPAddr, delay time.Duration) error {
+	if !h.isPunchNoticeExpected(rendezvous_hash, peer_hash) {
+		return errors.New("unexpected punch notice")
+	}
+	peer_identity, err := NewPeerIdentity(root_cert, handshake_key_cert)
+	if err != nil {
+		return err
+	}
+	if peer_identity.root_id_hash != peer_hash {
+		return errors.New("peer hash mismatch")
+	}
+	if _, err := h.appendPeer(peer_identity); err != nil {
+		return err
+	}
+	peer, ok := h.peers.Find(peer_identity.root_id_hash)
+	if !ok {
+		return errors.New("unknown peer")
+	}
+
+	peer.mtx.Lock()
+	state := peer.state
+	peer.mtx.Unlock()
+	if state != PNCS_DISCONNECTED && state != PNCS_INBOUND {
+		return nil //already dialed, or closed
+	}
+
+	candidate_addresses := h.addressSelector.FilterAddressCandidates(addresses)
+	if len(candidate_addresses) == 0 {
+		return errors.New("no valid IP address")
+	}
+
+	go func() {
+		select {
+		case <-peer.ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		for range HOLE_PUNCH_PACKET_COUNT {
+			for _, address := range candidate_addresses {
+				h.transportFor(address).WriteTo([]byte{0}, address)
+			}
+		}
+		h.PrepareAbyssOutbound(peer, candidate_addresses)
+	}()
+	return nil
+}
@@ -0,0 +1,146 @@
+package net_service
+
+import (
+	"bytes"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// everything in RootSecrets but the root private key: the certificates and the handshake key.
+// restoring it keeps the certificates that peers already know across restarts.
+type rawIdentityState struct {
+	RootCertificateDer         []byte
+	HandshakeKeyCertificateDer []byte
+	HandshakeKey               rawHandshakeSecret
+	HandshakeKeyScheme         HandshakeKeyScheme
+}
+
+// ExportState is secret; it holds the handshake private key. see RestoreRootIdentity.
+// a rotated handshake key is not in earlier exports.
+func (r *RootSecrets) ExportState() ([]byte, error) {
+	r.handshake_mtx.Lock()
+	defer r.handshake_mtx.Unlock()
+
+	handshake_key_cert, _ := pem.Decode([]byte(r.handshake_key_cert))
+	return cbor.Marshal(rawIdentityState{
+		RootCertificateDer:         r.root_self_cert_x509.Raw,
+		HandshakeKeyCertificateDer: handshake_key_cert.Bytes,
+		HandshakeKey:               r.handshake_secret.marshal(),
+		HandshakeKeyScheme:         r.handshake_scheme,
+	})
+}
+
+// RestoreRootIdentity rebuilds the RootSecrets that ExportState was called on.
+func RestoreRootIdentity(root_private_key PrivateKey, state []byte) (*RootSecrets, error) {
+	root_private_key, err := CheckRootKey(root_private_key)
+	if err != nil {
+		return nil, err
+	}
+	var raw rawIdentityState
+	if err := cbor.Unmarshal(state, &raw); err != nil {
+		return nil, err
+	}
+	peer_identity, err := NewPeerIdentity(raw.RootCertificateDer, raw.HandshakeKeyCertificateDer)
+	if err != nil {
+		return nil, err
+	}
+	peer_hash, err := AbyssIdFromKey(root_private_key.Public())
+	if err != nil {
+		return nil, err
+	}
+	if peer_hash != peer_identity.root_id_hash {
+		return nil, errors.New("identity state is for another key")
+	}
+	handshake_secret, err := unmarshalHandshakeSecret(raw.HandshakeKey)
+	if err != nil {
+		return nil, err
+	}
+	if !handshake_secret.matches(peer_identity.handshake_pub_key) {
+		return nil, errors.New("handshake key mismatch")
+	}
+
+	var root_cert_buf bytes.Buffer
+	if err := pem.Encode(&root_cert_buf, &pem.Block{Type: "CERTIFICATE", Bytes: raw.RootCertificateDer}); err != nil {
+		return nil, err
+	}
+	var handshake_cert_buf bytes.Buffer
+	if err := pem.Encode(&handshake_cert_buf, &pem.Block{Type: "CERTIFICATE", Bytes: raw.HandshakeKeyCertificateDer}); err != nil {
+		return nil, err
+	}
+	return &RootSecrets{
+		root_priv_key:       root_private_key,
+		root_self_cert_x509: peer_identity.root_self_cert_x509,
+		root_self_cert:      root_cert_buf.String(),
+		root_id_hash:        peer_hash,
+		owner_id_hash:       peer_identity.owner_id_hash,
+
+		handshake_scheme:   raw.HandshakeKeyScheme,
+		handshake_secret:   handshake_secret,
+		handshake_key_cert: handshake_cert_buf.String(),
+		handshake_issued:   peer_identity.handshake_issued,
+		handshake_mtx:      new(sync.Mutex),
+	}, nil
+}
+
+const IDENTITY_STATE_FILE_EXT = ".identity"
+
+// a place for BetaNetServiceConfig.IdentityStateDir, which is off by default: "abyss/identity" in the user config directory.
+// empty if there is none. the handshake private key is written there unencrypted; the keystore package encrypts it.
+func DefaultIdentityStateDir() string {
+	config_dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(config_dir, "abyss", "identity")
+}
+
+// restores the identity of root_private_key kept in dir, or creates and keeps a new one.
+// the handshake key (an RSA key, by default) is costly to generate, and peers keep its certificate.
+// a state of another scheme, or unreadable, is replaced. returns the state file path,
+// empty if the state cannot be written; the identity is then used for this run only.
+func loadIdentityState(root_private_key PrivateKey, scheme HandshakeKeyScheme, dir string) (*RootSecrets, string, error) {
+	root_private_key, err := CheckRootKey(root_private_key)
+	if err != nil {
+		return nil, "", err
+	}
+	peer_hash, err := AbyssIdFromKey(root_private_key.Public())
+	if err != nil {
+		return nil, "", err
+	}
+	path := filepath.Join(dir, peer_hash+IDENTITY_STATE_FILE_EXT)
+
+	if state, err := os.ReadFile(path); err == nil {
+		root_secret, err := RestoreRootIdentity(root_private_key, state)
+		if err == nil && root_secret.handshake_scheme == scheme {
+			return root_secret, path, nil
+		}
+	}
+	root_secret, err := NewRootIdentityWithScheme(root_private_key, scheme)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return root_secret, "", nil
+	}
+	if err := saveIdentityState(root_secret, path); err != nil {
+		return root_secret, "", nil
+	}
+	return root_secret, path, nil
+}
+
+func saveIdentityState(root_secret *RootSecrets, path string) error {
+	state, err := root_secret.ExportState()
+	if err != nil {
+		return err
+	}
+	tmp_path := path + ".tmp"
+	if err := os.WriteFile(tmp_path, state, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp_path, path)
+}
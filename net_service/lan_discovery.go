@@ -0,0 +1,153 @@
+package net_service
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+
+	"github.com/MinwooWebeng/abyss_core/ahmp"
+	abyss "github.com/MinwooWebeng/abyss_core/interfaces"
+)
+
+const LAN_ANNOUNCEMENT_MAX_AGE = time.Minute //older (or further in the future) announcements are dropped, to limit replays
+
+type LANDiscoveryConfig struct {
+	GroupAddress *net.UDPAddr   //multicast group and port
+	Interface    *net.Interface //nil: system default
+	Interval     time.Duration  //between our own announcements
+	AutoRegister bool           //append discovered peers as known peers
+}
+
+func NewDefaultLANDiscoveryConfig() *LANDiscoveryConfig {
+	return &LANDiscoveryConfig{
+		GroupAddress: &net.UDPAddr{IP: net.IPv4(239, 255, 77, 77), Port: 17777},
+		Interval:     time.Second * 5,
+	}
+}
+
+type LANDiscovery struct {
+	config *LANDiscoveryConfig
+
+	announced map[string]string //peer hash -> last surfaced AURL
+	mtx       *sync.Mutex
+}
+
+func (h *BetaNetService) StartLANDiscovery(auto_register bool) error {
+	config := NewDefaultLANDiscoveryConfig()
+	config.AutoRegister = auto_register
+	return h.StartLANDiscoveryWithConfig(config)
+}
+
+// announces the local AURL to the group every interval, and surfaces verified announcements of others on GetLANPeerChannel().
+func (h *BetaNetService) StartLANDiscoveryWithConfig(config *LANDiscoveryConfig) error {
+	h.lan_discovery_mtx.Lock()
+	defer h.lan_discovery_mtx.Unlock()
+
+	if h.lanDiscovery != nil {
+		return errors.New("LAN discovery already started")
+	}
+
+	listen_conn, err := net.ListenMulticastUDP("udp4", config.Interface, config.GroupAddress)
+	if err != nil {
+		return err
+	}
+	send_conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+	if err != nil {
+		listen_conn.Close()
+		return err
+	}
+
+	discovery := &LANDiscovery{
+		config:    config,
+		announced: make(map[string]string),
+		mtx:       new(sync.Mutex),
+	}
+	h.lanDiscovery = discovery
+
+	h.goService(func() {
+		<-h.ctx.Done()
+		listen_conn.Close()
+		send_conn.Close()
+	})
+	h.goService(func() { h.lanAnnounceLoop(discovery, send_conn) })
+	h.goService(func() { h.lanListenLoop(discovery, listen_conn) })
+	return nil
+}
+
+func (h *BetaNetService) GetLANPeerChannel() chan abyss.LANPeer {
+	return h.lanPeerCH
+}
+
+func (h *BetaNetService) lanAnnounceLoop(discovery *LANDiscovery, conn *net.UDPConn) {
+	for {
+		if record, err := h.newLocalPeerRecord(); err == nil {
+			if announcement, err := cbor.Marshal(ahmp.RawPeerRecord(record)); err == nil {
+				conn.WriteToUDP(announcement, discovery.config.GroupAddress)
+			}
+		}
+
+		select {
+		case <-h.ctx.Done():
+			return
+		case <-time.After(discovery.config.Interval):
+		}
+	}
+}
+
+func (h *BetaNetService) lanListenLoop(discovery *LANDiscovery, conn *net.UDPConn) {
+	buf := make([]byte, 8192)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		lan_peer, err := h.parseLANAnnouncement(buf[:n])
+		if err != nil || lan_peer.AURL.Hash == h.localIdentity.root_id_hash {
+			continue
+		}
+
+		discovery.mtx.Lock()
+		aurl_string := lan_peer.AURL.ToString()
+		is_new := discovery.announced[lan_peer.AURL.Hash] != aurl_string
+		discovery.announced[lan_peer.AURL.Hash] = aurl_string
+		discovery.mtx.Unlock()
+		if !is_new {
+			continue
+		}
+
+		if discovery.config.AutoRegister {
+			h.AppendKnownPeerDer(lan_peer.RootCertificateDer, lan_peer.HandshakeKeyCertificateDer)
+		}
+		select {
+		case h.lanPeerCH <- lan_peer:
+		default: //nobody listening. drop it, it will be announced again.
+			discovery.mtx.Lock()
+			delete(discovery.announced, lan_peer.AURL.Hash)
+			discovery.mtx.Unlock()
+		}
+	}
+}
+
+// multicast datagram: a cbor encoded ahmp.RawPeerRecord.
+func (h *BetaNetService) parseLANAnnouncement(datagram []byte) (abyss.LANPeer, error) {
+	var raw ahmp.RawPeerRecord
+	if err := cbor.Unmarshal(datagram, &raw); err != nil {
+		return abyss.LANPeer{}, err
+	}
+	record, err := VerifyPeerRecord(abyss.SignedPeerRecord(raw))
+	if err != nil {
+		return abyss.LANPeer{}, err
+	}
+	age := time.Since(record.TimeStamp)
+	if age > LAN_ANNOUNCEMENT_MAX_AGE || age < -LAN_ANNOUNCEMENT_MAX_AGE {
+		return abyss.LANPeer{}, errors.New("stale LAN announcement")
+	}
+	return abyss.LANPeer{
+		AURL:                       record.AURL,
+		RootCertificateDer:         raw.RootCertificateDer,
+		HandshakeKeyCertificateDer: raw.HandshakeKeyCertificateDer,
+	}, nil
+}
@@ -6,7 +6,9 @@ import (
 	"encoding/pem"
 	"errors"
 	"net"
-	"strconv"
+	"os"
+	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/quic-go/quic-go"
@@ -17,82 +19,235 @@ import (
 )
 
 type BetaNetService struct {
-	ctx context.Context
+	ctx      context.Context
+	cancel   context.CancelFunc //by Shutdown
+	routines *sync.WaitGroup    //see goService
+
+	localIdentity        *RootSecrets
+	identity_state_path  string //rewritten on handshake key rotation. empty: not kept
+	local_aurl           *aurl.AURL
+	local_aurl_mtx       *sync.Mutex
+	relay_candidates     []string //advertised in local_aurl. guarded by local_aurl_mtx
+	advertised_addresses []*net.UDPAddr
+	addressSelector      abyss.IAddressSelector
+
+	reflexiveAddresses *ReflexiveAddressTable
+
+	quicTransports []*quic.Transport //one per socket. the first one is the default for dialing.
+	tlsIdentity    *TLSIdentity      //renewed before expiry. the TLS configs read it on each handshake.
+	tls_renewal    TLSRenewalConfig
+	tls_mtx        *sync.Mutex
+	clock          func() time.Time
+	abyssTlsConf   *tls.Config
+	abystTlsConf   *tls.Config
+	quicConf       *quic.Config
+
+	preAccepter   abyss.IPreAccepter
+	preaccept_mtx *sync.Mutex
 
-	localIdentity   *RootSecrets
-	local_aurl      *aurl.AURL
-	addressSelector abyss.IAddressSelector
+	peers *ContextedPeerMap
 
-	quicTransport *quic.Transport
-	tlsIdentity   *TLSIdentity
-	abyssTlsConf  *tls.Config
-	abystTlsConf  *tls.Config
-	quicConf      *quic.Config
+	peerStore       abyss.IPeerStore //nil: known peers are not persisted
+	tofuPolicy      TOFUPolicy
+	peer_store_mtx  *sync.Mutex
+	peerKeyChangeCH chan abyss.PeerKeyChange
 
-	preAccepter abyss.IPreAccepter
+	revokedDevices  map[string]abyss.DeviceRevocation //owner hash + "/" + device hash. kept to pass on to peers that connect later
+	revocation_path string                            //empty: revocations are not persisted
+	revocation_mtx  *sync.Mutex
 
-	peers *ContextedPeerMap
+	blocklist     *Blocklist
+	blocklist_mtx *sync.Mutex
+
+	relayQuota         *RelayQuota //nil: not relaying for others
+	relaySessionCount  int
+	punch_expectations map[string]time.Time //rendezvous hash + "/" + target hash -> expiry. guarded by relay_mtx
+	relay_mtx          *sync.Mutex
+
+	lanDiscovery      *LANDiscovery //nil until started
+	lan_discovery_mtx *sync.Mutex
+	lanPeerCH         chan abyss.LANPeer
+
+	dht *DHT
 
 	abyssPeerCH chan abyss.IANDPeer //before actually using the peer, each thread must check IsConnected()
 
-	abystServer *http3.Server
+	abystServer        *http3.Server
+	abyst_conn_peers   map[quic.ConnectionTracingID]*abystConnection //accepted abyst connections, while served
+	abystStreamHandler func(stream quic.Stream, peer_hash string)
+	abyst_mtx          *sync.Mutex
+}
+
+type BetaNetServiceConfig struct {
+	ListenAddresses     []*net.UDPAddr     //one socket each. unspecified IP binds all interfaces, port 0 picks a free port.
+	AdvertisedAddresses []*net.UDPAddr     //external addresses (e.g. port forwards), listed first in the local AURL.
+	HandshakeKeyScheme  HandshakeKeyScheme //default: HANDSHAKE_KEY_MIGRATION. a new key gets its RSA half in the background, shortly after start.
+	DeviceCertificate   string             //pem, from the owner's IssueDeviceCertificate. empty: not a device.
+	IdentityState       []byte             //from RootSecrets.ExportState, for the same key. overrides the two above.
+	IdentityStateDir    string             //without IdentityState or DeviceCertificate, the identity state is kept here unencrypted, a file per root key, and reused on restart. empty (default): a new handshake key on each start. the keystore package keeps it encrypted.
+	TLSRenewal          TLSRenewalConfig   //zero fields: defaults
+	Clock               func() time.Time   //time source for certificate validity. nil: time.Now
+	PeerStoreDir        string             //known peers, device revocations and the blocklist are kept here, files per local identity. empty: not persisted.
+	TOFUPolicy          TOFUPolicy         //for the peer store
+}
+
+type TLSRenewalConfig struct {
+	Lifetime      time.Duration //validity of the TLS and binding certificates
+	RenewBefore   time.Duration //renewed this long before expiry
+	CheckInterval time.Duration
+}
+
+func NewDefaultBetaNetServiceConfig() *BetaNetServiceConfig {
+	return &BetaNetServiceConfig{
+		ListenAddresses: []*net.UDPAddr{{IP: net.IPv4zero, Port: 0}},
+		PeerStoreDir:    DefaultPeerStoreDir(),
+		TLSRenewal: TLSRenewalConfig{
+			Lifetime:      TLS_IDENTITY_LIFETIME,
+			RenewBefore:   24 * time.Hour,
+			CheckInterval: time.Minute,
+		},
+	}
 }
 
 func NewBetaNetService(ctx context.Context, local_private_key PrivateKey, address_selector abyss.IAddressSelector, abyst_server *http3.Server) (*BetaNetService, error) {
-	result := new(BetaNetService)
+	return NewBetaNetServiceWithConfig(ctx, local_private_key, address_selector, abyst_server, NewDefaultBetaNetServiceConfig())
+}
+
+func NewBetaNetServiceWithConfig(ctx context.Context, local_private_key PrivateKey, address_selector abyss.IAddressSelector, abyst_server *http3.Server, config *BetaNetServiceConfig) (*BetaNetService, error) {
+	if len(config.ListenAddresses) == 0 {
+		return nil, errors.New("no listen address")
+	}
 
-	result.ctx = ctx
+	conns := make([]net.PacketConn, 0, len(config.ListenAddresses))
+	for _, listen_address := range config.ListenAddresses {
+		network := "udp4"
+		if listen_address.IP != nil && listen_address.IP.To4() == nil {
+			network = "udp6"
+		}
+		udpConn, err := net.ListenUDP(network, listen_address)
+		if err != nil {
+			for _, conn := range conns {
+				conn.Close()
+			}
+			return nil, err
+		}
+		conns = append(conns, udpConn)
+	}
+	return newBetaNetService(ctx, local_private_key, address_selector, abyst_server, conns, config)
+}
 
-	root_secret, err := NewRootIdentity(local_private_key)
+// conn must be bound to a UDP address; it may be wrapped (e.g. NAT emulation in tests).
+func NewBetaNetServiceWithConn(ctx context.Context, local_private_key PrivateKey, address_selector abyss.IAddressSelector, abyst_server *http3.Server, conn net.PacketConn) (*BetaNetService, error) {
+	return newBetaNetService(ctx, local_private_key, address_selector, abyst_server, []net.PacketConn{conn}, NewDefaultBetaNetServiceConfig())
+}
+
+// config.ListenAddresses is ignored; conns are already bound.
+func newBetaNetService(ctx context.Context, local_private_key PrivateKey, address_selector abyss.IAddressSelector, abyst_server *http3.Server, conns []net.PacketConn, config *BetaNetServiceConfig) (*BetaNetService, error) {
+	result := new(BetaNetService)
+
+	result.ctx, result.cancel = context.WithCancel(ctx)
+	result.routines = new(sync.WaitGroup)
+
+	var root_secret *RootSecrets
+	var err error
+	if len(config.IdentityState) != 0 {
+		root_secret, err = RestoreRootIdentity(local_private_key, config.IdentityState)
+	} else if config.DeviceCertificate != "" {
+		root_secret, err = NewDeviceIdentity(local_private_key, config.DeviceCertificate, config.HandshakeKeyScheme)
+	} else if config.IdentityStateDir != "" {
+		root_secret, result.identity_state_path, err = loadIdentityState(local_private_key, config.HandshakeKeyScheme, config.IdentityStateDir)
+	} else if config.HandshakeKeyScheme == HANDSHAKE_KEY_MIGRATION {
+		root_secret, err = newRootIdentityDeferringRSA(local_private_key)
+	} else {
+		root_secret, err = NewRootIdentityWithScheme(local_private_key, config.HandshakeKeyScheme)
+	}
 	if err != nil {
 		return nil, err
 	}
 	result.localIdentity = root_secret
 	result.addressSelector = address_selector
 
-	tls_identity, err := root_secret.NewTLSIdentity()
+	result.clock = config.Clock
+	if result.clock == nil {
+		result.clock = time.Now
+	}
+	result.tls_renewal = config.TLSRenewal.withDefaults()
+	tls_identity, err := root_secret.NewTLSIdentityAt(result.clock(), result.tls_renewal.Lifetime)
 	if err != nil {
 		return nil, err
 	}
 	result.tlsIdentity = tls_identity
-	result.abyssTlsConf = NewDefaultTlsConf(tls_identity)
+	result.tls_mtx = new(sync.Mutex)
+	result.abyssTlsConf = NewDefaultTlsConf(result.currentTLSIdentity, result.clock)
 
-	udpConn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
-	if err != nil {
-		return nil, err
+	result.quicTransports = make([]*quic.Transport, len(conns))
+	for i, conn := range conns {
+		result.quicTransports[i] = &quic.Transport{Conn: conn}
 	}
-	result.quicTransport = &quic.Transport{Conn: udpConn}
 	result.quicConf = NewDefaultQuicConf()
 
-	local_port := strconv.Itoa(udpConn.LocalAddr().(*net.UDPAddr).Port)
-	local_ip := address_selector.LocalPrivateIPAddr().String()
-	local_aurl, err := aurl.TryParse("abyss:" +
-		root_secret.IDHash() +
-		":" + local_ip + ":" + local_port +
-		"|127.0.0.1:" + local_port)
-	if err != nil {
-		return nil, err
-	}
-	result.local_aurl = local_aurl
+	result.reflexiveAddresses = NewReflexiveAddressTable()
+	result.advertised_addresses = config.AdvertisedAddresses
+	result.local_aurl = result.buildLocalAURL()
+	result.local_aurl_mtx = new(sync.Mutex)
 
+	result.preaccept_mtx = new(sync.Mutex)
 	result.peers = NewContextedPeerMap()
+	result.peer_store_mtx = new(sync.Mutex)
+	result.peerKeyChangeCH = make(chan abyss.PeerKeyChange, 16)
+	result.revokedDevices = make(map[string]abyss.DeviceRevocation)
+	result.revocation_mtx = new(sync.Mutex)
+	result.blocklist = NewBlocklist()
+	result.blocklist_mtx = new(sync.Mutex)
+	result.punch_expectations = make(map[string]time.Time)
+	result.relay_mtx = new(sync.Mutex)
+	result.lan_discovery_mtx = new(sync.Mutex)
+	result.lanPeerCH = make(chan abyss.LANPeer, 16)
+	result.dht = NewDHT(root_secret.root_id_hash)
 
 	result.abyssPeerCH = make(chan abyss.IANDPeer, 8)
 
-	result.abystTlsConf = NewDefaultTlsConf(tls_identity)
+	result.abystTlsConf = NewDefaultTlsConf(result.currentTLSIdentity, result.clock)
 	result.abystTlsConf.NextProtos = []string{http3.NextProtoH3} //abyst only.
 	result.abystServer = abyst_server
+	result.abyst_conn_peers = make(map[quic.ConnectionTracingID]*abystConnection)
+	result.abyst_mtx = new(sync.Mutex)
+	if abyst_server != nil {
+		result.wrapAbystConnContext()
+		result.wrapAbystStreamHijacker()
+	}
+
+	if config.PeerStoreDir != "" {
+		if err := os.MkdirAll(config.PeerStoreDir, 0700); err != nil {
+			return nil, err
+		}
+		store, err := NewFilePeerStore(filepath.Join(config.PeerStoreDir, root_secret.IDHash()+PEER_STORE_FILE_EXT))
+		if err != nil {
+			return nil, err
+		}
+		result.SetPeerStore(store, config.TOFUPolicy) //unreadable entries are skipped.
+		if err := result.loadDeviceRevocations(filepath.Join(config.PeerStoreDir, root_secret.IDHash()+REVOCATION_FILE_EXT)); err != nil {
+			return nil, err
+		}
+		blocklist, err := NewFileBlocklist(filepath.Join(config.PeerStoreDir, root_secret.IDHash()+BLOCKLIST_FILE_EXT))
+		if err != nil {
+			return nil, err
+		}
+		result.blocklist = blocklist
+	}
 
+	result.goService(result.completeHandshakeKey)
 	return result, nil
 }
 
-func NewDefaultTlsConf(tls_identity *TLSIdentity) *tls.Config {
+// the certificate is taken from tls_identity() on each handshake, so that it can be renewed in place.
+func NewDefaultTlsConf(tls_identity func() *TLSIdentity, now func() time.Time) *tls.Config {
 	return &tls.Config{
-		Certificates: []tls.Certificate{
-			{
-				Certificate: [][]byte{tls_identity.tls_self_cert},
-				PrivateKey:  tls_identity.priv_key,
-			},
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return tls_identity().tlsCertificate(), nil
+		},
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return tls_identity().tlsCertificate(), nil
 		},
 		VerifyConnection: func(cs tls.ConnectionState) error {
 			if len(cs.PeerCertificates) > 1 {
@@ -102,9 +257,12 @@ func NewDefaultTlsConf(tls_identity *TLSIdentity) *tls.Config {
 			if err := cert.CheckSignatureFrom(cert); err != nil {
 				return errors.Join(errors.New("TLS Verify Failed"), err)
 			}
+			if now().After(cert.NotAfter) {
+				return errors.New("TLS certificate expired")
+			}
 			return nil
 		},
-		NextProtos:         []string{abyss.NextProtoAbyss, http3.NextProtoH3},
+		NextProtos:         []string{abyss.NextProtoAbyss2, abyss.NextProtoAbyss, http3.NextProtoH3},
 		ServerName:         "abyss",
 		ClientAuth:         tls.RequireAnyClientCert,
 		InsecureSkipVerify: true,
@@ -125,30 +283,161 @@ func (h *BetaNetService) LocalIdentity() abyss.IHostIdentity {
 	return h.localIdentity
 }
 func (h *BetaNetService) LocalAURL() *aurl.AURL {
+	h.local_aurl_mtx.Lock()
+	defer h.local_aurl_mtx.Unlock()
+
 	return h.local_aurl
 }
 
+// advertised addresses first, then public (reflexive), then the bound addresses of each socket, then loopback.
+// called with local_aurl_mtx held.
+func (h *BetaNetService) buildLocalAURL() *aurl.AURL {
+	addresses := make([]*net.UDPAddr, 0, len(h.advertised_addresses)+2*len(h.quicTransports)+1)
+	addresses = append(addresses, h.advertised_addresses...)
+	if public_addr := h.reflexiveAddresses.Consensus(); public_addr != nil {
+		addresses = append(addresses, public_addr)
+	}
+	loopback_addresses := make([]*net.UDPAddr, 0, len(h.quicTransports))
+	for _, transport := range h.quicTransports {
+		bound_addr := transport.Conn.LocalAddr().(*net.UDPAddr)
+		switch {
+		case bound_addr.IP.Equal(net.IPv4zero):
+			addresses = append(addresses, &net.UDPAddr{IP: h.addressSelector.LocalPrivateIPAddr(), Port: bound_addr.Port})
+			loopback_addresses = append(loopback_addresses, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: bound_addr.Port})
+		case bound_addr.IP.IsUnspecified():
+			//IPv6 wildcard. we do not know which global address peers can use; advertise it explicitly.
+			loopback_addresses = append(loopback_addresses, &net.UDPAddr{IP: net.IPv6loopback, Port: bound_addr.Port})
+		case bound_addr.IP.IsLoopback():
+			loopback_addresses = append(loopback_addresses, bound_addr)
+		default:
+			addresses = append(addresses, bound_addr)
+		}
+	}
+	addresses = append(addresses, loopback_addresses...)
+	return &aurl.AURL{
+		Scheme:    "abyss",
+		Hash:      h.localIdentity.IDHash(),
+		Addresses: addresses,
+		Relays:    h.relay_candidates,
+	}
+}
+
+func (h *BetaNetService) ReportObservedAddress(peer_hash string, address *net.UDPAddr) bool {
+	public_addr, changed := h.reflexiveAddresses.Report(peer_hash, address)
+	if !changed {
+		return false
+	}
+	h.addressSelector.SetPublicIP(public_addr.IP)
+
+	h.local_aurl_mtx.Lock()
+	h.local_aurl = h.buildLocalAURL()
+	h.local_aurl_mtx.Unlock()
+	return true
+}
+
+// the handler is asked for every inbound abyss connection. it also approves dialers that we do not know,
+// which are refused otherwise.
 func (h *BetaNetService) HandlePreAccept(preaccept_handler abyss.IPreAccepter) {
+	h.preaccept_mtx.Lock()
+	defer h.preaccept_mtx.Unlock()
+
 	h.preAccepter = preaccept_handler
 }
 
+// the transport to send to address from. with a socket per interface, the one whose interface subnet
+// has the address; otherwise a wildcard socket, then any non-loopback socket, of the same IP family.
+func (h *BetaNetService) transportFor(address net.Addr) *quic.Transport {
+	udp_addr, ok := address.(*net.UDPAddr)
+	if !ok {
+		return h.quicTransports[0]
+	}
+	is_v4 := udp_addr.IP.To4() != nil
+	interface_addrs, _ := net.InterfaceAddrs()
+
+	var wildcard, other *quic.Transport
+	for _, transport := range h.quicTransports {
+		bound_addr, ok := transport.Conn.LocalAddr().(*net.UDPAddr)
+		if !ok || (bound_addr.IP.To4() != nil) != is_v4 {
+			continue
+		}
+		switch {
+		case bound_addr.IP.IsUnspecified():
+			if wildcard == nil {
+				wildcard = transport
+			}
+			continue
+		case bound_addr.IP.Equal(udp_addr.IP):
+			return transport
+		}
+		for _, interface_addr := range interface_addrs {
+			subnet, ok := interface_addr.(*net.IPNet)
+			if ok && subnet.IP.Equal(bound_addr.IP) && subnet.Contains(udp_addr.IP) {
+				return transport
+			}
+		}
+		if other == nil && !bound_addr.IP.IsLoopback() {
+			other = transport
+		}
+	}
+	if wildcard != nil {
+		return wildcard
+	}
+	if other != nil {
+		return other
+	}
+	return h.quicTransports[0]
+}
+
+// serves all sockets. returns when any of them fails, or nil after Shutdown.
 func (h *BetaNetService) ListenAndServe() error {
-	listener, err := h.quicTransport.Listen(h.abyssTlsConf, h.quicConf)
-	if err != nil {
-		return err
+	listeners := make([]*quic.Listener, len(h.quicTransports))
+	for i, transport := range h.quicTransports {
+		listener, err := transport.Listen(h.abyssTlsConf, h.quicConf)
+		if err != nil {
+			for _, opened := range listeners[:i] {
+				opened.Close()
+			}
+			return err
+		}
+		listeners[i] = listener
 	}
 	//go h.constructingAbyssPeers(ctx)
+	h.goService(h.dhtRepublishLoop)
+	h.goService(h.tlsRenewalLoop)
+
+	err_ch := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		h.goService(func() {
+			err_ch <- h.serveListener(listener)
+		})
+	}
+	err := <-err_ch
+	if h.ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
 
+func (h *BetaNetService) serveListener(listener *quic.Listener) error {
 	for {
 		connection, err := listener.Accept(h.ctx)
 		if err != nil {
 			return err
 		}
+		if h.isAddressBlocked(connection.RemoteAddr()) {
+			connection.CloseWithError(ABYSS_BLOCKED, ABYSS_BLOCKED_M)
+			continue
+		}
 		switch connection.ConnectionState().TLS.NegotiatedProtocol {
-		case abyss.NextProtoAbyss:
-			go h.PrepareAbyssInbound(h.ctx, connection)
+		case abyss.NextProtoAbyss2, abyss.NextProtoAbyss:
+			h.goService(func() { h.PrepareAbyssInbound(h.ctx, connection) })
 		case http3.NextProtoH3:
-			go h.abystServer.ServeQUICConn(connection)
+			peer_hash, ok := h.AbystPeerHash(connection)
+			if !ok || h.abystServer == nil {
+				connection.CloseWithError(ABYSS_UNAUTHENTICATED, ABYSS_UNAUTHENTICATED_M)
+				continue
+			}
+			h.goService(func() { h.serveAbyst(connection, peer_hash) })
 		default:
 			connection.CloseWithError(0, "unknown TLS ALPN protocol ID")
 		}
@@ -168,14 +457,13 @@ func (h *BetaNetService) AppendKnownPeer(root_cert string, handshake_key_cert st
 	return h.AppendKnownPeerDer(root_cert_block.Bytes, handshake_key_cert_block.Bytes)
 }
 func (h *BetaNetService) AppendKnownPeerDer(root_cert []byte, handshake_key_cert []byte) error {
-	//Future: allow updating handshake key? may not? unsure.
 	peer_identity, err := NewPeerIdentity(root_cert, handshake_key_cert)
 	if err != nil {
 		return err
 	}
 
-	h.peers.Append(h.ctx, peer_identity.root_id_hash, NewAbyssPeer(*peer_identity))
-	return nil
+	_, err = h.appendPeer(peer_identity)
+	return err
 }
 
 func (h *BetaNetService) GetAbyssPeerChannel() chan abyss.IANDPeer {
@@ -186,23 +474,40 @@ func (h *BetaNetService) ConnectAbyssAsync(url *aurl.AURL) error {
 	if url.Scheme != "abyss" {
 		return errors.New("url scheme mismatch")
 	}
+	if h.IsPeerBlocked(url.Hash) {
+		return ErrPeerBlocked
+	}
 
 	candidate_addresses := h.addressSelector.FilterAddressCandidates(url.Addresses)
 	if len(candidate_addresses) == 0 {
-		return errors.New("no valid IP address")
+		candidate_addresses = h.addressSelector.FilterAddressCandidates(h.storedAddresses(url.Hash))
 	}
-
 	peer, ok := h.peers.Find(url.Hash)
-	if !ok {
-		return errors.New("unknown peer")
+	if !ok || (len(candidate_addresses) == 0 && len(url.Relays) == 0) {
+		go h.connectResolvedAsync(url)
+		return nil
 	}
 
-	go h.PrepareAbyssOutbound(peer, candidate_addresses)
+	go h.connectKnown(peer, url, candidate_addresses)
 	return nil
 }
+
+// dials directly, then falls back to the relays in the url.
+func (h *BetaNetService) connectKnown(peer *ContextedPeer, url *aurl.AURL, candidate_addresses []*net.UDPAddr) {
+	peer.setRelays(url.Relays)
+
+	if len(candidate_addresses) != 0 && h.PrepareAbyssOutbound(peer, candidate_addresses) == nil {
+		return
+	}
+	if len(url.Relays) != 0 && !peer.IsConnected() {
+		h.requestRelay(url.Relays, url.Hash)
+	}
+}
 func (h *BetaNetService) ConnectAbyst(peer_hash string) (quic.Connection, error) {
 	if peer_hash == h.localIdentity.root_id_hash { //loopback
-		connection, err := h.quicTransport.Dial(h.ctx, h.local_aurl.Addresses[len(h.local_aurl.Addresses)-1], h.abystTlsConf, h.quicConf)
+		local_addresses := h.LocalAURL().Addresses
+		loopback_address := local_addresses[len(local_addresses)-1]
+		connection, err := h.transportFor(loopback_address).Dial(h.ctx, loopback_address, h.abystTlsConf, h.quicConf)
 		if err != nil {
 			return nil, err
 		}
@@ -210,6 +515,9 @@ func (h *BetaNetService) ConnectAbyst(peer_hash string) (quic.Connection, error)
 		return connection, nil
 	}
 
+	if h.IsPeerBlocked(peer_hash) {
+		return nil, ErrPeerBlocked
+	}
 	peer, ok := h.peers.Find(peer_hash)
 	if !ok {
 		return nil, errors.New("no abyss connection")
@@ -217,7 +525,7 @@ func (h *BetaNetService) ConnectAbyst(peer_hash string) (quic.Connection, error)
 	if peer.state != PNCS_CONNECTED {
 		return nil, errors.New("abyss connection closed and not reconnected")
 	}
-	connection, err := h.quicTransport.Dial(peer.ctx, peer.outbound_conn.RemoteAddr(), h.abystTlsConf, h.quicConf)
+	connection, err := h.transportFor(peer.outbound_conn.RemoteAddr()).Dial(peer.ctx, peer.outbound_conn.RemoteAddr(), h.abystTlsConf, h.quicConf)
 	if err != nil {
 		return nil, err
 	}
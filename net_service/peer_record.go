@@ -0,0 +1,76 @@
+package net_service
+
+import (
+	"errors"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+
+	"github.com/MinwooWebeng/abyss_core/aurl"
+	abyss "github.com/MinwooWebeng/abyss_core/interfaces"
+)
+
+const peer_record_sign_prefix = "abyss-peer-record:"
+
+// body of abyss.SignedPeerRecord
+type RawPeerRecordBody struct {
+	AURL      string
+	TimeStamp int64 //unix milli
+}
+
+// verified abyss.SignedPeerRecord
+type PeerRecord struct {
+	identity  *PeerIdentity
+	AURL      *aurl.AURL
+	TimeStamp time.Time
+	signed    abyss.SignedPeerRecord
+}
+
+func (h *BetaNetService) newLocalPeerRecord() (abyss.SignedPeerRecord, error) {
+	body, err := cbor.Marshal(RawPeerRecordBody{
+		AURL:      h.LocalAURL().ToString(),
+		TimeStamp: time.Now().UnixMilli(),
+	})
+	if err != nil {
+		return abyss.SignedPeerRecord{}, err
+	}
+	signature, err := h.localIdentity.Sign(append([]byte(peer_record_sign_prefix), body...))
+	if err != nil {
+		return abyss.SignedPeerRecord{}, err
+	}
+	return abyss.SignedPeerRecord{
+		Body:                       body,
+		Signature:                  signature,
+		RootCertificateDer:         h.localIdentity.root_self_cert_x509.Raw,
+		HandshakeKeyCertificateDer: h.localIdentity.handshakeKeyCertificateDer(),
+	}, nil
+}
+
+// checks the certificates, the signature, and that the AURL belongs to the signer.
+func VerifyPeerRecord(signed abyss.SignedPeerRecord) (*PeerRecord, error) {
+	peer_identity, err := NewPeerIdentity(signed.RootCertificateDer, signed.HandshakeKeyCertificateDer)
+	if err != nil {
+		return nil, err
+	}
+	if err := peer_identity.VerifySignature(append([]byte(peer_record_sign_prefix), signed.Body...), signed.Signature); err != nil {
+		return nil, err
+	}
+
+	var body RawPeerRecordBody
+	if err := cbor.Unmarshal(signed.Body, &body); err != nil {
+		return nil, err
+	}
+	peer_aurl, err := aurl.TryParse(body.AURL)
+	if err != nil {
+		return nil, err
+	}
+	if peer_aurl.Scheme != "abyss" || peer_aurl.Hash != peer_identity.root_id_hash {
+		return nil, errors.New("peer record hash mismatch")
+	}
+	return &PeerRecord{
+		identity:  peer_identity,
+		AURL:      peer_aurl,
+		TimeStamp: time.UnixMilli(body.TimeStamp),
+		signed:    signed,
+	}, nil
+}
@@ -0,0 +1,328 @@
+package net_service
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+
+	abyss "github.com/MinwooWebeng/abyss_core/interfaces"
+)
+
+const KNOWN_PEER_MAX_ADDRESSES = 4
+
+// what to do when a known peer presents a handshake key other than the stored one.
+type TOFUPolicy int
+
+const (
+	TOFU_WARN   TOFUPolicy = iota //accept and store the new key, and report it on GetPeerKeyChangeChannel()
+	TOFU_REJECT                   //keep the stored key, and refuse the new one
+)
+
+var ErrHandshakeKeyChanged = errors.New("handshake key of a known peer has changed")
+
+type rawKnownPeer struct {
+	RootCertificateDer         []byte
+	HandshakeKeyCertificateDer []byte
+	Addresses                  []string
+	FirstSeen                  int64 //unix milli
+	LastSeen                   int64
+}
+
+const PEER_STORE_FILE_EXT = ".peers"
+const PEER_STORE_SAVE_DELAY = time.Second //changes within this are written at once
+const PEER_STORE_CORRUPT_EXT = ".corrupt" //an undecodable store file is moved aside with this suffix
+
+// the default BetaNetServiceConfig.PeerStoreDir: "abyss/peers" in the user config directory. empty if there is none.
+func DefaultPeerStoreDir() string {
+	config_dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(config_dir, "abyss", "peers")
+}
+
+// FilePeerStore keeps known peers in memory, and rewrites the whole file shortly after changes.
+// connections update peers often (last seen, addresses); the writes are batched.
+type FilePeerStore struct {
+	path  string
+	peers map[string]abyss.KnownPeer
+
+	save_timer *time.Timer //pending save. nil: saved
+	save_err   error       //of the last batched save, returned by Flush
+	mtx        *sync.Mutex
+}
+
+// a missing file is an empty store; it is created on the first save.
+// entries that cannot be decoded are skipped. a file that cannot be decoded at all (e.g. truncated)
+// is moved aside to path+PEER_STORE_CORRUPT_EXT, and the store starts empty.
+func NewFilePeerStore(path string) (*FilePeerStore, error) {
+	result := &FilePeerStore{
+		path:  path,
+		peers: make(map[string]abyss.KnownPeer),
+		mtx:   new(sync.Mutex),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var raw_entries map[string]cbor.RawMessage
+	if err := cbor.Unmarshal(data, &raw_entries); err != nil {
+		if err := os.Rename(path, path+PEER_STORE_CORRUPT_EXT); err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+	for peer_hash, raw_entry := range raw_entries {
+		var raw rawKnownPeer
+		if err := cbor.Unmarshal(raw_entry, &raw); err != nil {
+			continue
+		}
+		addresses := make([]*net.UDPAddr, 0, len(raw.Addresses))
+		for _, address_string := range raw.Addresses {
+			if address, err := net.ResolveUDPAddr("udp", address_string); err == nil {
+				addresses = append(addresses, address)
+			}
+		}
+		result.peers[peer_hash] = abyss.KnownPeer{
+			RootCertificateDer:         raw.RootCertificateDer,
+			HandshakeKeyCertificateDer: raw.HandshakeKeyCertificateDer,
+			Addresses:                  addresses,
+			FirstSeen:                  time.UnixMilli(raw.FirstSeen),
+			LastSeen:                   time.UnixMilli(raw.LastSeen),
+		}
+	}
+	return result, nil
+}
+
+func (s *FilePeerStore) Get(peer_hash string) (abyss.KnownPeer, bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	peer, ok := s.peers[peer_hash]
+	return peer, ok
+}
+
+func (s *FilePeerStore) Put(peer_hash string, peer abyss.KnownPeer) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.peers[peer_hash] = peer
+	if s.save_timer == nil {
+		s.save_timer = time.AfterFunc(PEER_STORE_SAVE_DELAY, func() {
+			s.mtx.Lock()
+			defer s.mtx.Unlock()
+
+			s.save_timer = nil
+			s.save_err = s.save()
+		})
+	}
+	return nil
+}
+
+// Flush writes pending changes now. returns the error of the last write, if any.
+func (s *FilePeerStore) Flush() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.save_timer != nil && s.save_timer.Stop() {
+		s.save_timer = nil
+		s.save_err = s.save()
+	}
+	return s.save_err
+}
+
+func (s *FilePeerStore) All() map[string]abyss.KnownPeer {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	result := make(map[string]abyss.KnownPeer, len(s.peers))
+	for peer_hash, peer := range s.peers {
+		result[peer_hash] = peer
+	}
+	return result
+}
+
+// written to a temporary file first, so that a crash never leaves a truncated store.
+func (s *FilePeerStore) save() error {
+	raw_peers := make(map[string]rawKnownPeer, len(s.peers))
+	for peer_hash, peer := range s.peers {
+		addresses := make([]string, len(peer.Addresses))
+		for i, address := range peer.Addresses {
+			addresses[i] = address.String()
+		}
+		raw_peers[peer_hash] = rawKnownPeer{
+			RootCertificateDer:         peer.RootCertificateDer,
+			HandshakeKeyCertificateDer: peer.HandshakeKeyCertificateDer,
+			Addresses:                  addresses,
+			FirstSeen:                  peer.FirstSeen.UnixMilli(),
+			LastSeen:                   peer.LastSeen.UnixMilli(),
+		}
+	}
+	data, err := cbor.Marshal(raw_peers)
+	if err != nil {
+		return err
+	}
+	tmp_path := s.path + ".tmp"
+	if err := os.WriteFile(tmp_path, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp_path, s.path)
+}
+
+// SetPeerStore loads the stored peers as known peers, and persists peers learned from now on.
+// call before ListenAndServe.
+func (h *BetaNetService) SetPeerStore(store abyss.IPeerStore, policy TOFUPolicy) error {
+	h.peer_store_mtx.Lock()
+	h.peerStore = store
+	h.tofuPolicy = policy
+	h.peer_store_mtx.Unlock()
+
+	var errs []error
+	for peer_hash, known_peer := range store.All() {
+		peer_identity, err := NewPeerIdentity(known_peer.RootCertificateDer, known_peer.HandshakeKeyCertificateDer)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if peer_identity.root_id_hash != peer_hash {
+			errs = append(errs, errors.New("peer hash mismatch"))
+			continue
+		}
+		if h.IsPeerBlocked(peer_hash) {
+			continue
+		}
+		h.peers.Append(h.ctx, peer_hash, NewAbyssPeer(*peer_identity))
+	}
+	return errors.Join(errs...)
+}
+
+func (h *BetaNetService) GetPeerKeyChangeChannel() chan abyss.PeerKeyChange {
+	return h.peerKeyChangeCH
+}
+
+// appendPeer registers a verified peer identity as known, applying the trust-on-first-use policy.
+// returns true if the peer was not known in this session.
+func (h *BetaNetService) appendPeer(peer_identity *PeerIdentity) (bool, error) {
+	if h.isRevokedDevice(peer_identity) {
+		return false, ErrDeviceRevoked
+	}
+	if h.IsPeerBlocked(peer_identity.root_id_hash) {
+		return false, ErrPeerBlocked
+	}
+
+	h.peer_store_mtx.Lock()
+	store, policy := h.peerStore, h.tofuPolicy
+	h.peer_store_mtx.Unlock()
+
+	if store == nil {
+		return h.appendOrUpdatePeer(peer_identity), nil
+	}
+
+	t_now := time.Now()
+	known_peer, known := store.Get(peer_identity.root_id_hash)
+	if known && !bytes.Equal(known_peer.HandshakeKeyCertificateDer, peer_identity.handshake_key_cert_der) {
+		change := abyss.PeerKeyChange{
+			PeerHash:                           peer_identity.root_id_hash,
+			PreviousHandshakeKeyCertificateDer: known_peer.HandshakeKeyCertificateDer,
+			HandshakeKeyCertificateDer:         peer_identity.handshake_key_cert_der,
+			Accepted:                           policy != TOFU_REJECT,
+		}
+		select {
+		case h.peerKeyChangeCH <- change:
+		default: //nobody listening.
+		}
+		if policy == TOFU_REJECT {
+			return false, ErrHandshakeKeyChanged
+		}
+		known_peer.HandshakeKeyCertificateDer = peer_identity.handshake_key_cert_der
+	}
+	if !known {
+		known_peer = abyss.KnownPeer{
+			RootCertificateDer:         peer_identity.root_self_cert_der,
+			HandshakeKeyCertificateDer: peer_identity.handshake_key_cert_der,
+			FirstSeen:                  t_now,
+		}
+	}
+	known_peer.LastSeen = t_now
+
+	return h.appendOrUpdatePeer(peer_identity), store.Put(peer_identity.root_id_hash, known_peer)
+}
+
+// a peer already known in this session takes the handshake key, if it is newer (rotated).
+func (h *BetaNetService) appendOrUpdatePeer(peer_identity *PeerIdentity) bool {
+	if _, ok := h.peers.Append(h.ctx, peer_identity.root_id_hash, NewAbyssPeer(*peer_identity)); ok {
+		return true
+	}
+	if peer, ok := h.peers.Find(peer_identity.root_id_hash); ok {
+		peer.updateHandshakeKey(peer_identity.handshake_key_cert_der)
+	}
+	return false
+}
+
+// a rotation announced by the peer itself is not a trust-on-first-use violation.
+func (h *BetaNetService) storeHandshakeKey(peer_hash string, handshake_key_cert []byte) error {
+	h.peer_store_mtx.Lock()
+	store := h.peerStore
+	h.peer_store_mtx.Unlock()
+
+	if store == nil {
+		return nil
+	}
+	known_peer, ok := store.Get(peer_hash)
+	if !ok {
+		return nil
+	}
+	known_peer.HandshakeKeyCertificateDer = handshake_key_cert
+	return store.Put(peer_hash, known_peer)
+}
+
+// records the address a connected peer was reached at. called on PNCS_CONNECTED.
+func (h *BetaNetService) rememberPeerAddress(peer_hash string, address *net.UDPAddr) {
+	h.peer_store_mtx.Lock()
+	store := h.peerStore
+	h.peer_store_mtx.Unlock()
+
+	if store == nil {
+		return
+	}
+	known_peer, ok := store.Get(peer_hash)
+	if !ok {
+		return
+	}
+	addresses := slices.DeleteFunc(slices.Clone(known_peer.Addresses), func(known_address *net.UDPAddr) bool {
+		return known_address.String() == address.String()
+	})
+	known_peer.Addresses = append([]*net.UDPAddr{address}, addresses...)
+	if len(known_peer.Addresses) > KNOWN_PEER_MAX_ADDRESSES {
+		known_peer.Addresses = known_peer.Addresses[:KNOWN_PEER_MAX_ADDRESSES]
+	}
+	known_peer.LastSeen = time.Now()
+	store.Put(peer_hash, known_peer)
+}
+
+// last-known addresses, for peers dialed without any.
+func (h *BetaNetService) storedAddresses(peer_hash string) []*net.UDPAddr {
+	h.peer_store_mtx.Lock()
+	store := h.peerStore
+	h.peer_store_mtx.Unlock()
+
+	if store == nil {
+		return nil
+	}
+	known_peer, ok := store.Get(peer_hash)
+	if !ok {
+		return nil
+	}
+	return known_peer.Addresses
+}
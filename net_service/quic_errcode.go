@@ -5,4 +5,12 @@ const (
 	ABYSS_ALREADY_CONNECTED_M  = "Alrady Connected"
 	ABYSS_EARLY_RECONNECTION   = 0x0A02
 	ABYSS_EARLY_RECONNECTION_M = "Too Early Reconnection"
+	ABYSS_DEVICE_REVOKED       = 0x0A03
+	ABYSS_DEVICE_REVOKED_M     = "Device Revoked"
+	ABYSS_BLOCKED              = 0x0A04
+	ABYSS_BLOCKED_M            = "Blocked"
+	ABYSS_SHUTDOWN             = 0x0A05
+	ABYSS_SHUTDOWN_M           = "Shutdown"
+	ABYSS_UNAUTHENTICATED      = 0x0A06
+	ABYSS_UNAUTHENTICATED_M    = "Unauthenticated"
 )
@@ -0,0 +1,93 @@
+package net_service
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+const REFLEXIVE_OBSERVATION_TIMEOUT = time.Minute * 30
+const REFLEXIVE_CONSENSUS_QUORUM = 2 //minimum number of distinct peers that must agree
+
+type ReflexiveObservation struct {
+	address   *net.UDPAddr
+	timestamp time.Time
+}
+
+// ReflexiveAddressTable collects the addresses that connected peers observe for our connections.
+// Each peer holds a single vote (its latest observation), so one peer cannot push an address by itself.
+type ReflexiveAddressTable struct {
+	observations map[string]ReflexiveObservation //key: peer hash
+	consensus    *net.UDPAddr
+
+	mtx *sync.Mutex
+}
+
+func NewReflexiveAddressTable() *ReflexiveAddressTable {
+	return &ReflexiveAddressTable{
+		observations: make(map[string]ReflexiveObservation),
+		mtx:          new(sync.Mutex),
+	}
+}
+
+// loopback, private and link-local observations come from peers in the same network, and say nothing about our public address.
+func IsPublicAddress(ip net.IP) bool {
+	return !(ip.IsUnspecified() || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsMulticast())
+}
+
+// Report records the address that peer_hash observed for us.
+// returns (consensus address, true) if the consensus has changed by this report.
+func (t *ReflexiveAddressTable) Report(peer_hash string, address *net.UDPAddr) (*net.UDPAddr, bool) {
+	if !IsPublicAddress(address.IP) {
+		return nil, false
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	t_now := time.Now()
+	t.observations[peer_hash] = ReflexiveObservation{
+		address:   address,
+		timestamp: t_now,
+	}
+
+	votes := make(map[string]int)
+	candidates := make(map[string]*net.UDPAddr)
+	live_count := 0
+	for id, observation := range t.observations {
+		if t_now.Sub(observation.timestamp) > REFLEXIVE_OBSERVATION_TIMEOUT {
+			delete(t.observations, id)
+			continue
+		}
+		live_count++
+		key := observation.address.String()
+		votes[key]++
+		candidates[key] = observation.address
+	}
+
+	best := ""
+	best_votes := 0
+	for key, v := range votes {
+		if v > best_votes {
+			best = key
+			best_votes = v
+		}
+	}
+
+	//requires both quorum and strict majority.
+	if best_votes < REFLEXIVE_CONSENSUS_QUORUM || best_votes*2 <= live_count {
+		return nil, false
+	}
+	if t.consensus != nil && t.consensus.String() == best {
+		return nil, false
+	}
+	t.consensus = candidates[best]
+	return t.consensus, true
+}
+
+func (t *ReflexiveAddressTable) Consensus() *net.UDPAddr {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	return t.consensus
+}
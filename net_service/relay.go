@@ -0,0 +1,295 @@
+package net_service
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/MinwooWebeng/abyss_core/ahmp"
+	"github.com/MinwooWebeng/abyss_core/aurl"
+)
+
+// A relay forwards UDP datagrams between two peers that cannot reach each other.
+// each side gets its own relay port, and sees the other side as that port.
+// QUIC and the abyss handshake run end-to-end on top, so the relay can neither read nor impersonate.
+type RelayQuota struct {
+	MaxSessions    int           //concurrently relayed pairs
+	BytesPerSecond int           //per session, both directions combined. excess packets are dropped.
+	IdleTimeout    time.Duration //a session without traffic for this long is torn down
+}
+
+func NewDefaultRelayQuota() RelayQuota {
+	return RelayQuota{
+		MaxSessions:    8,
+		BytesPerSecond: 1 << 20,
+		IdleTimeout:    time.Second * 30,
+	}
+}
+
+type relaySide struct {
+	conn    *net.UDPConn
+	peer_ip net.IP                      //packets from other IPs are dropped
+	latched atomic.Pointer[net.UDPAddr] //peer source address, learned from its first packet
+}
+
+type RelaySession struct {
+	sides [2]*relaySide
+	quota RelayQuota
+
+	allowance   float64
+	last_refill time.Time
+	last_active atomic.Int64
+	mtx         *sync.Mutex
+}
+
+func NewRelaySession(quota RelayQuota, peer_ip_0 net.IP, peer_ip_1 net.IP) (*RelaySession, error) {
+	result := &RelaySession{
+		quota:       quota,
+		allowance:   float64(quota.BytesPerSecond),
+		last_refill: time.Now(),
+		mtx:         new(sync.Mutex),
+	}
+	result.last_active.Store(time.Now().UnixNano())
+	for i, peer_ip := range []net.IP{peer_ip_0, peer_ip_1} {
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+		if err != nil {
+			result.Close()
+			return nil, err
+		}
+		result.sides[i] = &relaySide{conn: conn, peer_ip: peer_ip}
+	}
+	return result, nil
+}
+
+// the relay port assigned to side i.
+func (s *RelaySession) Port(i int) int {
+	return s.sides[i].conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func (s *RelaySession) Close() {
+	for _, side := range s.sides {
+		if side != nil {
+			side.conn.Close()
+		}
+	}
+}
+
+// blocks until ctx is done or the session goes idle.
+func (s *RelaySession) Serve(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	for i := range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer cancel()
+			s.forward(i)
+		}()
+	}
+	<-ctx.Done()
+	s.Close()
+	wg.Wait()
+}
+
+func (s *RelaySession) forward(from int) {
+	src, dst := s.sides[from], s.sides[1-from]
+	buf := make([]byte, 2048)
+	for {
+		src.conn.SetReadDeadline(time.Now().Add(s.quota.IdleTimeout))
+		n, addr, err := src.conn.ReadFromUDP(buf)
+		if err != nil {
+			var net_err net.Error
+			if errors.As(err, &net_err) && net_err.Timeout() &&
+				time.Since(time.Unix(0, s.last_active.Load())) < s.quota.IdleTimeout {
+				continue //the other direction is active
+			}
+			return
+		}
+		if !addr.IP.Equal(src.peer_ip) {
+			continue
+		}
+		src.latched.Store(addr)
+		s.last_active.Store(time.Now().UnixNano())
+
+		dst_addr := dst.latched.Load()
+		if dst_addr == nil || !s.consume(n) {
+			continue
+		}
+		dst.conn.WriteToUDP(buf[:n], dst_addr)
+	}
+}
+
+// token bucket, holding at most one second worth of bytes.
+func (s *RelaySession) consume(n int) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	now := time.Now()
+	s.allowance = min(s.allowance+now.Sub(s.last_refill).Seconds()*float64(s.quota.BytesPerSecond), float64(s.quota.BytesPerSecond))
+	s.last_refill = now
+	if s.allowance < float64(n) {
+		return false
+	}
+	s.allowance -= float64(n)
+	return true
+}
+
+// nil disables relaying for other peers (default).
+func (h *BetaNetService) SetRelayQuota(quota *RelayQuota) {
+	h.relay_mtx.Lock()
+	defer h.relay_mtx.Unlock()
+
+	h.relayQuota = quota
+}
+
+// HandleRelayRequest is called on the relay side.
+// it allocates a relay session and tells each side to dial the other one through it.
+func (h *BetaNetService) HandleRelayRequest(requester_hash string, target_hash string) error {
+	requester, ok := h.peers.Find(requester_hash)
+	if !ok || !requester.IsConnected() {
+		return errors.New("relay requester not connected")
+	}
+	target, ok := h.peers.Find(target_hash)
+	if !ok || !target.IsConnected() {
+		return errors.New("relay target not connected")
+	}
+	requester_ip, target_ip := requester.observedAddress().IP, target.observedAddress().IP
+	relay_ip, err := h.relayIP(requester_ip, target_ip)
+	if err != nil {
+		return err
+	}
+
+	h.relay_mtx.Lock()
+	if h.relayQuota == nil {
+		h.relay_mtx.Unlock()
+		return errors.New("relay disabled")
+	}
+	if h.relaySessionCount >= h.relayQuota.MaxSessions {
+		h.relay_mtx.Unlock()
+		return errors.New("relay session quota exceeded")
+	}
+	quota := *h.relayQuota
+	h.relaySessionCount++
+	h.relay_mtx.Unlock()
+
+	session, err := NewRelaySession(quota, requester_ip, target_ip)
+	if err != nil {
+		h.releaseRelaySession()
+		return err
+	}
+	h.goService(func() {
+		session.Serve(h.ctx)
+		h.releaseRelaySession()
+	})
+
+	requester_notice := target.rawPunchNotice()
+	requester_notice.Addresses = []string{(&net.UDPAddr{IP: relay_ip, Port: session.Port(0)}).String()}
+	target_notice := requester.rawPunchNotice()
+	target_notice.Addresses = []string{(&net.UDPAddr{IP: relay_ip, Port: session.Port(1)}).String()}
+
+	target_sent := target._trySend2(ahmp.PNC_T, target_notice)
+	requester_sent := requester._trySend2(ahmp.PNC_T, requester_notice)
+	if !target_sent || !requester_sent {
+		session.Close()
+		return errors.New("failed to send relay notice")
+	}
+	return nil
+}
+
+// an address of ours that both peers can reach the relay ports on. the ports are IPv4 only.
+// the public address that peers observe comes first; a private one serves peers in the same network.
+func (h *BetaNetService) relayIP(peer_ips ...net.IP) (net.IP, error) {
+	candidates := h.LocalAURL().Addresses
+	if public_addr := h.reflexiveAddresses.Consensus(); public_addr != nil {
+		candidates = append([]*net.UDPAddr{public_addr}, candidates...)
+	}
+	for _, candidate := range candidates {
+		relay_ip := candidate.IP.To4()
+		if relay_ip == nil {
+			continue
+		}
+		reachable := true
+		for _, peer_ip := range peer_ips {
+			reachable = reachable && canReachRelay(peer_ip, relay_ip)
+		}
+		if reachable {
+			return relay_ip, nil
+		}
+	}
+	return nil, errors.New("no relay address reachable by both peers")
+}
+
+func canReachRelay(peer_ip net.IP, relay_ip net.IP) bool {
+	switch {
+	case peer_ip.To4() == nil:
+		return false
+	case IsPublicAddress(relay_ip):
+		return true
+	case relay_ip.IsLoopback():
+		return peer_ip.IsLoopback()
+	default:
+		return !IsPublicAddress(peer_ip)
+	}
+}
+
+func (h *BetaNetService) releaseRelaySession() {
+	h.relay_mtx.Lock()
+	defer h.relay_mtx.Unlock()
+
+	h.relaySessionCount--
+}
+
+// asks the first reachable relay candidate to relay towards the target.
+func (h *BetaNetService) requestRelay(relays []string, target_hash string) bool {
+	for _, relay_hash := range relays {
+		relay, ok := h.peers.Find(relay_hash)
+		if !ok {
+			continue
+		}
+		h.expectPunchNotice(relay_hash, target_hash)
+		if relay._trySend2(ahmp.RLQ_T, ahmp.RawRLQ{
+			TargetHash: target_hash,
+		}) {
+			return true
+		}
+	}
+	return false
+}
+
+// relay peers advertised in the local AURL, for peers that cannot reach us directly.
+func (h *BetaNetService) SetRelayCandidates(relay_hashes []string) error {
+	for _, relay_hash := range relay_hashes {
+		if !aurl.IsValidPeerID(relay_hash) {
+			return errors.New("invalid peer hash")
+		}
+	}
+
+	h.local_aurl_mtx.Lock()
+	defer h.local_aurl_mtx.Unlock()
+
+	h.relay_candidates = relay_hashes
+	h.local_aurl = h.buildLocalAURL()
+	return nil
+}
+
+func (p *AbyssPeer) observedAddress() *net.UDPAddr {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	return p.inbound_conn.RemoteAddr().(*net.UDPAddr)
+}
+
+func (p *AbyssPeer) setRelays(relays []string) {
+	if len(relays) == 0 {
+		return
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	p.relays = relays
+}
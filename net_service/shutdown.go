@@ -0,0 +1,103 @@
+package net_service
+
+import (
+	"context"
+	"errors"
+)
+
+var ErrNetServiceShutdown = errors.New("network service shut down")
+var ErrPeerShutdown = errors.New("peer shut down")
+
+// Shutdown ends the ahmp stream to every peer, so that messages sent before are delivered,
+// and waits for the peers to close the connections on reading the end.
+// when ctx is done, or all are closed, the remaining connections are closed with ABYSS_SHUTDOWN.
+// then the sockets are released and the service goroutines are waited for.
+// returns ctx.Err() if ctx was done before everything finished. the service cannot be restarted.
+func (h *BetaNetService) Shutdown(ctx context.Context) error {
+	var err error
+
+	peers := h.peers.All()
+	drained := make([]<-chan struct{}, 0, len(peers))
+	for _, peer := range peers {
+		if done := peer.closeAhmpStream(); done != nil {
+			drained = append(drained, done)
+		}
+	}
+	for _, done := range drained {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		if err != nil {
+			break
+		}
+	}
+	for _, peer := range peers {
+		peer.closeWithError(ABYSS_SHUTDOWN, ABYSS_SHUTDOWN_M, ErrNetServiceShutdown)
+	}
+
+	h.cancel()
+	h.peer_store_mtx.Lock()
+	if store, ok := h.peerStore.(interface{ Flush() error }); ok {
+		store.Flush()
+	}
+	h.peer_store_mtx.Unlock()
+	for _, transport := range h.quicTransports {
+		transport.Close()
+		transport.Conn.Close()
+	}
+
+	routines_done := make(chan bool, 1)
+	go func() {
+		h.routines.Wait()
+		routines_done <- true
+	}()
+	select {
+	case <-routines_done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	return err
+}
+
+// runs f in a goroutine that Shutdown waits for. nothing is run after shutdown.
+func (h *BetaNetService) goService(f func()) {
+	if h.ctx.Err() != nil {
+		return
+	}
+	h.routines.Add(1)
+	go func() {
+		defer h.routines.Done()
+		f()
+	}()
+}
+
+// a peer that shut down is forgotten, so that it is not refused as an early reconnection when it comes back.
+func (h *BetaNetService) serveAhmp(peer *ContextedPeer) {
+	peer.listenAhmp()
+
+	peer.mtx.Lock()
+	shut_down := peer.err == ErrPeerShutdown
+	peer.mtx.Unlock()
+	if shut_down {
+		h.peers.Remove(peer.identity.root_id_hash)
+		peer.closeWithError(ABYSS_SHUTDOWN, ABYSS_SHUTDOWN_M, ErrPeerShutdown)
+	}
+}
+
+// ends the outbound ahmp stream after the pending sends.
+// returns a channel closed when the peer closed the connection, or nil if there is no stream.
+func (p *AbyssPeer) closeAhmpStream() <-chan struct{} {
+	p.send_mtx.Lock()
+	defer p.send_mtx.Unlock()
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if p.ahmp_stream == nil || p.state == PNCS_CLOSED {
+		return nil
+	}
+	p.ahmp_stream.Close()
+	return p.outbound_conn.Context().Done()
+}
@@ -0,0 +1,61 @@
+package net_service
+
+import (
+	"time"
+)
+
+// each zero (or negative) field is defaulted on its own; a zero CheckInterval would spin the renewal loop.
+func (c TLSRenewalConfig) withDefaults() TLSRenewalConfig {
+	defaults := NewDefaultBetaNetServiceConfig().TLSRenewal
+	if c.Lifetime <= 0 {
+		c.Lifetime = defaults.Lifetime
+	}
+	if c.RenewBefore <= 0 {
+		c.RenewBefore = defaults.RenewBefore
+	}
+	if c.CheckInterval <= 0 {
+		c.CheckInterval = defaults.CheckInterval
+	}
+	return c
+}
+
+func (h *BetaNetService) currentTLSIdentity() *TLSIdentity {
+	h.tls_mtx.Lock()
+	defer h.tls_mtx.Unlock()
+
+	return h.tlsIdentity
+}
+
+// when the current TLS and binding certificates expire.
+func (h *BetaNetService) TLSIdentityExpiry() time.Time {
+	return h.currentTLSIdentity().NotAfter()
+}
+
+// RenewTLSIdentity reissues the TLS identity now. new handshakes use it right away;
+// established connections are not affected.
+func (h *BetaNetService) RenewTLSIdentity() error {
+	h.tls_mtx.Lock()
+	defer h.tls_mtx.Unlock()
+
+	renewed, err := h.localIdentity.RenewTLSIdentity(h.tlsIdentity, h.clock(), h.tls_renewal.Lifetime)
+	if err != nil {
+		return err
+	}
+	h.tlsIdentity = renewed
+	return nil
+}
+
+func (h *BetaNetService) tlsRenewalLoop() {
+	for {
+		select {
+		case <-h.ctx.Done():
+			return
+		case <-time.After(h.tls_renewal.CheckInterval):
+		}
+
+		if h.clock().Add(h.tls_renewal.RenewBefore).Before(h.TLSIdentityExpiry()) {
+			continue
+		}
+		h.RenewTLSIdentity() //on failure, retried at the next check
+	}
+}
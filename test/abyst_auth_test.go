@@ -0,0 +1,89 @@
+package test
+
+import (
+	"context"
+	"crypto/ed25519"
+	crypto_rand "crypto/rand"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+
+	"github.com/MinwooWebeng/abyss_core/abyst"
+	abyss_net "github.com/MinwooWebeng/abyss_core/net_service"
+)
+
+func TestAbystPeerIdentity(t *testing.T) {
+	guard := abyst.NewGuard(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peer_hash, _ := abyst.PeerHash(r)
+		w.Write([]byte(peer_hash))
+	}))
+	A_host, B_host := newTestAbystHosts(t, &http3.Server{Handler: guard})
+	A_hash := A_host.GetLocalAbyssURL().Hash
+	B_hash := B_host.GetLocalAbyssURL().Hash
+	guard.Restrict("/private", abyst.AllowPeers(A_hash))
+
+	get := func(url string) (int, string, error) {
+		response, err := B_host.AbystClient().Get(url)
+		if err != nil {
+			return 0, "", err
+		}
+		defer response.Body.Close()
+		body, err := io.ReadAll(response.Body)
+		return response.StatusCode, string(body), err
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if _, _, err := get("abyst:" + A_hash + "/"); err == nil {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+	}
+
+	//the handler sees who is asking.
+	if code, body, err := get("abyst:" + A_hash + "/whoami"); err != nil || code != http.StatusOK || body != B_hash {
+		t.Fatal("peer hash not in request context", code, body, err)
+	}
+	for _, restricted := range []string{"/private", "/private/data", "/public/../private/data", "//private/data", "/private/./data"} {
+		if code, _, err := get("abyst:" + A_hash + restricted); err != nil || code != http.StatusForbidden {
+			t.Fatal("restricted path served", restricted, code, err)
+		}
+	}
+	if code, _, err := get("abyst:" + A_hash + "/privateX"); err != nil || code != http.StatusOK {
+		t.Fatal("rule matched another path segment", code, err)
+	}
+
+	//a TLS key that no abyss peer bound is refused.
+	_, stranger_key, _ := ed25519.GenerateKey(crypto_rand.Reader)
+	stranger, err := abyss_net.NewRootIdentity(stranger_key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stranger_tls, err := stranger.NewTLSIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tls_config := abyss_net.NewDefaultTlsConf(func() *abyss_net.TLSIdentity { return stranger_tls }, time.Now)
+	tls_config.NextProtos = []string{http3.NextProtoH3}
+	A_addresses := A_host.GetLocalAbyssURL().Addresses
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	connection, err := quic.DialAddr(ctx, A_addresses[len(A_addresses)-1].String(), tls_config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-connection.Context().Done():
+	case <-ctx.Done():
+		t.Fatal("unauthenticated abyst connection kept")
+	}
+	var app_err *quic.ApplicationError
+	if !errors.As(context.Cause(connection.Context()), &app_err) || app_err.ErrorCode != abyss_net.ABYSS_UNAUTHENTICATED {
+		t.Fatal("unexpected close", context.Cause(connection.Context()))
+	}
+}
@@ -0,0 +1,83 @@
+package test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/MinwooWebeng/abyss_core/abyst"
+)
+
+func TestAbystContentServer(t *testing.T) {
+	memory := abyst.NewMemoryFS()
+	memory.Set("main.aml", []byte("<aml></aml>"))
+	memory.Set("assets/model.glb", []byte("0123456789"))
+
+	var zip_buf bytes.Buffer
+	zip_writer := zip.NewWriter(&zip_buf)
+	for name, content := range map[string]string{"main.aml": "<aml></aml>", "assets/model.glb": "0123456789"} {
+		file, _ := zip_writer.Create(name)
+		file.Write([]byte(content))
+	}
+	zip_writer.Close()
+	zip_reader, err := zip.NewReader(bytes.NewReader(zip_buf.Bytes()), int64(zip_buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, server := range []*abyst.ContentServer{abyst.NewContentServer(memory), abyst.NewContentServer(zip_reader)} {
+		get := func(path string, header http.Header) *httptest.ResponseRecorder {
+			request := httptest.NewRequest(http.MethodGet, path, nil)
+			for key, values := range header {
+				request.Header[key] = values
+			}
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, request)
+			return recorder
+		}
+
+		if response := get("/", nil); response.Code != http.StatusOK || response.Body.String() != "<aml></aml>" {
+			t.Fatal("index not served", response.Code)
+		}
+		response := get("/assets/model.glb", nil)
+		etag := response.Header().Get("ETag")
+		if response.Code != http.StatusOK || etag == "" {
+			t.Fatal("no ETag", response.Code)
+		}
+		if response := get("/assets/model.glb", http.Header{"If-None-Match": {etag}}); response.Code != http.StatusNotModified {
+			t.Fatal("ETag not matched", response.Code)
+		}
+		if response := get("/assets/model.glb", http.Header{"Range": {"bytes=2-4"}}); response.Code != http.StatusPartialContent || response.Body.String() != "234" {
+			t.Fatal("range not served", response.Code, response.Body.String())
+		}
+		if response := get("/assets", nil); response.Code != http.StatusNotFound {
+			t.Fatal("directory served", response.Code)
+		}
+
+		//the manifest is published only when asked.
+		if response := get(abyst.DEFAULT_MANIFEST_PATH, nil); response.Code != http.StatusNotFound {
+			t.Fatal("manifest published by default", response.Code)
+		}
+		server.SetManifestPath(abyst.DEFAULT_MANIFEST_PATH)
+		var manifest abyst.Manifest
+		if err := json.Unmarshal(get(abyst.DEFAULT_MANIFEST_PATH, nil).Body.Bytes(), &manifest); err != nil {
+			t.Fatal(err)
+		}
+		if len(manifest.Files) != 2 || manifest.Files[0].Path != "/assets/model.glb" || manifest.Files[0].Size != 10 ||
+			"\""+manifest.Files[0].SHA256+"\"" != etag {
+			t.Fatal("wrong manifest", manifest)
+		}
+	}
+
+	//a changed file gets a new hash.
+	server := abyst.NewContentServer(memory)
+	before, _ := server.Manifest()
+	memory.Set("assets/model.glb", []byte("9876543210"))
+	after, _ := server.Manifest()
+	if before.Files[0].SHA256 == after.Files[0].SHA256 {
+		t.Fatal("stale hash")
+	}
+}
@@ -0,0 +1,93 @@
+package test
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+
+	"github.com/MinwooWebeng/abyss_core/abyst"
+)
+
+func TestAbystGateway(t *testing.T) {
+	content := abyst.NewMemoryFS()
+	content.Set("assets/texture.png", []byte("0123456789"))
+	A_host, B_host := newTestAbystHosts(t, &http3.Server{Handler: abyst.NewContentServer(content)})
+	A_hash := A_host.GetLocalAbyssURL().Hash
+
+	gateway := abyst.NewGateway(B_host.AbystClient().Transport)
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	do := func(method string, path string, header http.Header) (*http.Response, string, error) {
+		request, _ := http.NewRequest(method, server.URL+path, nil)
+		for key, values := range header {
+			request.Header[key] = values
+		}
+		if host := header.Get("Host"); host != "" {
+			request.Host = host
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			return nil, "", err
+		}
+		defer response.Body.Close()
+		body, err := io.ReadAll(response.Body)
+		return response, string(body), err
+	}
+	get := func(path string, header http.Header) (*http.Response, string, error) {
+		return do(http.MethodGet, path, header)
+	}
+
+	if response, _, err := get("/"+A_hash+"/assets/texture.png", nil); err != nil || response.StatusCode != http.StatusForbidden {
+		t.Fatal("peer served without allowing", err)
+	}
+	gateway.Allow(A_hash)
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		response, body, err := get("/"+A_hash+"/assets/texture.png", nil)
+		if err == nil && response.StatusCode == http.StatusOK {
+			if body != "0123456789" || response.Header.Get("ETag") == "" {
+				t.Fatal("wrong gateway response", body, response.Header)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("gateway timeout", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	//status codes and headers come from the peer.
+	if response, body, err := get("/"+A_hash+"/assets/texture.png", http.Header{"Range": {"bytes=0-3"}}); err != nil || response.StatusCode != http.StatusPartialContent || body != "0123" {
+		t.Fatal("range not forwarded", err)
+	}
+	if response, _, err := get("/"+A_hash+"/missing", nil); err != nil || response.StatusCode != http.StatusNotFound {
+		t.Fatal("status not forwarded", err)
+	}
+
+	//DNS rebinding and cross-origin writes are refused; the peer answers the rest.
+	port := server.Listener.Addr().(*net.TCPAddr).Port
+	if response, _, err := get("/"+A_hash+"/assets/texture.png", http.Header{"Host": {"evil.example:" + strconv.Itoa(port)}}); err != nil || response.StatusCode != http.StatusForbidden {
+		t.Fatal("foreign host served", err)
+	}
+	if response, _, err := get("/"+A_hash+"/assets/texture.png", http.Header{"Host": {"localhost:" + strconv.Itoa(port)}}); err != nil || response.StatusCode != http.StatusOK {
+		t.Fatal("localhost not served", err)
+	}
+	if response, _, err := do(http.MethodPost, "/"+A_hash+"/assets/texture.png", http.Header{"Origin": {"http://evil.example"}}); err != nil || response.StatusCode != http.StatusForbidden {
+		t.Fatal("cross-origin POST forwarded", err)
+	}
+	if response, _, err := do(http.MethodPost, "/"+A_hash+"/assets/texture.png", http.Header{"Origin": {server.URL}}); err != nil || response.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatal("same-origin POST not forwarded", err)
+	}
+
+	gateway.Disallow(A_hash)
+	if response, _, err := get("/"+A_hash+"/assets/texture.png", nil); err != nil || response.StatusCode != http.StatusForbidden {
+		t.Fatal("disallowed peer served", err)
+	}
+}
@@ -0,0 +1,128 @@
+package test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+
+	abyss_host "github.com/MinwooWebeng/abyss_core/host"
+)
+
+func TestAbystPool(t *testing.T) {
+	var dials atomic.Int32
+	A_host, B_host := newTestAbystHosts(t, &http3.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		}),
+		ConnContext: func(ctx context.Context, c quic.Connection) context.Context {
+			dials.Add(1)
+			return ctx
+		},
+	})
+
+	A_hash := A_host.GetLocalAbyssURL().Hash
+	client := &http.Client{Transport: B_host.AbystRoundTripper()}
+	get := func() error {
+		response, err := client.Get("https://" + A_hash + "/asset")
+		if err != nil {
+			return err
+		}
+		defer response.Body.Close()
+		_, err = io.ReadAll(response.Body)
+		return err
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for get() != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("abyst connection timeout")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	time.Sleep(200 * time.Millisecond) //the first connection may be dropped when the host sees the peer connect.
+
+	//sequential and concurrent requests share one connection.
+	dials_before := dials.Load()
+	for range 20 {
+		if err := get(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := get(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if dials.Load()-dials_before > 1 || B_host.AbystPool().ConnectionCount(A_hash) != 1 {
+		t.Fatal("abyst connection not reused")
+	}
+
+	//idle connections are closed, and dialed again on demand.
+	B_host.AbystPool().SetConfig(abyss_host.AbystPoolConfig{
+		IdleTimeout:             100 * time.Millisecond,
+		MaxStreamsPerConnection: 64,
+	})
+	if err := get(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if B_host.AbystPool().ConnectionCount(A_hash) != 0 {
+		t.Fatal("idle abyst connection kept")
+	}
+
+	//concurrent requests without a connection wait for a single dial.
+	dials_before = dials.Load()
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := get(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if dials.Load()-dials_before != 1 {
+		t.Fatal("abyst connection dialed more than once", dials.Load()-dials_before)
+	}
+}
+
+// A serves abyst_server, and is connected with B.
+func newTestAbystHosts(t *testing.T, abyst_server *http3.Server) (*abyss_host.AbyssHost, *abyss_host.AbyssHost) {
+	return newTestAbystHostPair(t, abyst_server, nil)
+}
+
+func newTestAbystHostPair(t *testing.T, A_server *http3.Server, B_server *http3.Server) (*abyss_host.AbyssHost, *abyss_host.AbyssHost) {
+	A_conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	B_conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	A_host, _ := newTestHost(t, A_conn, A_server)
+	B_host, _ := newTestHost(t, B_conn, B_server)
+	go A_host.ListenAndServe(context.Background())
+	go B_host.ListenAndServe(context.Background())
+	for _, pair := range [][2]*abyss_host.AbyssHost{{A_host, B_host}, {B_host, A_host}} {
+		identity := pair[1].NetworkService.LocalIdentity()
+		pair[0].NetworkService.AppendKnownPeer(identity.RootCertificate(), identity.HandshakeKeyCertificate())
+	}
+	A_host.OpenOutboundConnection(B_host.GetLocalAbyssURL())
+	B_host.OpenOutboundConnection(A_host.GetLocalAbyssURL())
+	return A_host, B_host
+}
@@ -0,0 +1,96 @@
+package test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+
+	"github.com/MinwooWebeng/abyss_core/abyst"
+)
+
+func TestAbystProxy(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write([]byte(r.Method + " " + r.URL.RequestURI() + " " + r.Header.Get(abyst.PEER_HASH_HEADER) + " " + string(body)))
+	}))
+	defer backend.Close()
+
+	proxy, err := abyst.NewReverseProxy(abyst.ProxyConfig{
+		Backend:    backend.URL + "/base",
+		PathPrefix: "/app",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	A_host, B_host := newTestAbystHosts(t, &http3.Server{Handler: proxy})
+	A_hash := A_host.GetLocalAbyssURL().Hash
+	B_hash := B_host.GetLocalAbyssURL().Hash
+
+	request := func(method string, path string, header http.Header, body io.Reader) (int, string, error) {
+		req, err := http.NewRequest(method, "abyst:"+A_hash+path, body)
+		if err != nil {
+			return 0, "", err
+		}
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		response, err := B_host.AbystClient().Do(req)
+		if err != nil {
+			return 0, "", err
+		}
+		defer response.Body.Close()
+		response_body, err := io.ReadAll(response.Body)
+		return response.StatusCode, string(response_body), err
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if _, _, err := request(http.MethodGet, "/app/", nil, nil); err == nil {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+	}
+
+	//the prefix is mapped, the peer hash is injected, and a spoofed one is dropped.
+	code, body, err := request(http.MethodGet, "/app/echo?x=1", http.Header{abyst.PEER_HASH_HEADER: {"spoofed"}}, nil)
+	if err != nil || code != http.StatusOK || body != "GET /base/echo?x=1 "+B_hash+" " {
+		t.Fatal("wrong proxied request", code, body, err)
+	}
+
+	//the request body is streamed to the backend.
+	reader, writer := io.Pipe()
+	go func() {
+		for range 3 {
+			writer.Write([]byte("chunk;"))
+			time.Sleep(10 * time.Millisecond)
+		}
+		writer.Close()
+	}()
+	code, body, err = request(http.MethodPost, "/app/upload", nil, reader)
+	if err != nil || code != http.StatusOK || !strings.HasSuffix(body, " chunk;chunk;chunk;") {
+		t.Fatal("wrong proxied body", code, body, err)
+	}
+
+	if code, _, err := request(http.MethodGet, "/other", nil, nil); err != nil || code != http.StatusNotFound {
+		t.Fatal("request outside the prefix proxied", code, err)
+	}
+
+	//dot segments are resolved before the prefix check; nothing leaves the backend base path.
+	for _, outside := range []string{"/app/../admin", "/app/x/../../admin"} {
+		if code, _, err := request(http.MethodGet, outside, nil, nil); err != nil || code != http.StatusNotFound {
+			t.Fatal("request outside the prefix proxied", outside, code, err)
+		}
+	}
+	if code, _, err := request(http.MethodGet, "/app/%2e%2e/admin", nil, nil); err != nil || code != http.StatusBadRequest {
+		t.Fatal("escaped dot segments proxied", code, err)
+	}
+	code, body, err = request(http.MethodGet, "/app/x/../a%2Fb/", nil, nil)
+	if err != nil || code != http.StatusOK || !strings.HasPrefix(body, "GET /base/a%2Fb/ ") {
+		t.Fatal("wrong proxied path", code, body, err)
+	}
+}
@@ -0,0 +1,86 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+
+	"github.com/MinwooWebeng/abyss_core/abyst"
+)
+
+func TestAbystStream(t *testing.T) {
+	A_host, B_host := newTestAbystHosts(t, &http3.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		}),
+	})
+	A_hash := A_host.GetLocalAbyssURL().Hash
+	B_hash := B_host.GetLocalAbyssURL().Hash
+
+	listener, err := A_host.ListenAbystStream("test/echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	if _, err := A_host.ListenAbystStream("test/echo"); !errors.Is(err, abyst.ErrProtocolInUse) {
+		t.Fatal("protocol listened twice")
+	}
+	accepted := make(chan string, 1)
+	go func() {
+		stream, err := listener.Accept(context.Background())
+		if err != nil {
+			accepted <- err.Error()
+			return
+		}
+		accepted <- stream.PeerHash()
+		io.Copy(stream, stream)
+		stream.Close()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var stream *abyst.Stream
+	for {
+		if stream, err = B_host.OpenAbystStream(ctx, A_hash, "test/echo"); err == nil {
+			break
+		} else if ctx.Err() != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if peer_hash := <-accepted; peer_hash != B_hash {
+		t.Fatal("wrong peer on the accepting side", peer_hash)
+	}
+
+	//both directions stay open until closed.
+	for _, message := range [][]byte{[]byte("ping"), []byte("pong")} {
+		stream.Write(message)
+		echo := make([]byte, len(message))
+		if _, err := io.ReadFull(stream, echo); err != nil || !bytes.Equal(echo, message) {
+			t.Fatal("wrong echo", string(echo), err)
+		}
+	}
+	stream.Close()
+	if rest, err := io.ReadAll(stream); err != nil || len(rest) != 0 {
+		t.Fatal("stream not closed", err)
+	}
+
+	//requests share the connection, and an unknown protocol is refused.
+	if response, err := B_host.AbystClient().Get("abyst:" + A_hash + "/"); err != nil || response.StatusCode != http.StatusOK {
+		t.Fatal("abyst request failed", err)
+	} else {
+		response.Body.Close()
+	}
+	if _, err := B_host.OpenAbystStream(ctx, A_hash, "test/unknown"); !errors.Is(err, abyst.ErrStreamRejected) {
+		t.Fatal("unknown protocol accepted", err)
+	}
+	if B_host.AbystPool().ConnectionCount(A_hash) != 1 {
+		t.Fatal("stream connection not pooled", B_host.AbystPool().ConnectionCount(A_hash))
+	}
+}
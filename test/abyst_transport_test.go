@@ -0,0 +1,71 @@
+package test
+
+import (
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+func TestAbystTransport(t *testing.T) {
+	A_host, B_host := newTestAbystHosts(t, &http3.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.URL.EscapedPath() + "?" + r.URL.RawQuery))
+		}),
+	})
+	A_hash := A_host.GetLocalAbyssURL().Hash
+
+	get := func(client *http.Client, url string) (string, error) {
+		response, err := client.Get(url)
+		if err != nil {
+			return "", err
+		}
+		defer response.Body.Close()
+		body, err := io.ReadAll(response.Body)
+		return string(body), err
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if _, err := get(B_host.AbystClient(), "abyst:"+A_hash+"/"); err == nil {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+	}
+
+	for _, url := range []string{
+		"abyst:" + A_hash + "/assets/a.png?v=1",
+		"abyst://" + A_hash + "/assets/a.png?v=1",
+		"https://" + A_hash + ".abyst/assets/a.png?v=1",
+	} {
+		body, err := get(B_host.AbystClient(), url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body != "/assets/a.png?v=1" {
+			t.Fatal(url + ": unexpected response " + body)
+		}
+	}
+
+	//escaped paths arrive as they were sent.
+	for _, url := range []string{
+		"abyst:" + A_hash + "/a%20b/c%2Fd",
+		"abyst://" + A_hash + "/a%20b/c%2Fd",
+		"https://" + A_hash + ".abyst/a%20b/c%2Fd",
+	} {
+		if body, err := get(B_host.AbystClient(), url); err != nil || body != "/a%20b/c%2Fd?" {
+			t.Fatal(url+": wrong escaping", body, err)
+		}
+	}
+
+	//our own content, over loopback.
+	if body, err := get(A_host.AbystClient(), "abyst:"+A_hash+"/self"); err != nil || body != "/self?" {
+		t.Fatal("loopback failed", err)
+	}
+
+	if _, err := get(B_host.AbystClient(), "https://example.com/"); err == nil {
+		t.Fatal("non-abyst URL accepted")
+	}
+}
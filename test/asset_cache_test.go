@@ -0,0 +1,142 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	crypto_rand "crypto/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+
+	"github.com/MinwooWebeng/abyss_core/abyst"
+)
+
+func TestAssetCache(t *testing.T) {
+	asset := make([]byte, 10*1024)
+	crypto_rand.Read(asset)
+	A_content := abyst.NewMemoryFS()
+	A_content.Set("asset.bin", asset)
+	A_server := abyst.NewContentServer(A_content)
+	B_server := abyst.NewContentServer(abyst.NewMemoryFS())
+
+	var A_origin_hits, B_cache_hits atomic.Int32
+	counting := func(hits *atomic.Int32, prefix string, next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, prefix) {
+				hits.Add(1)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	//while A_corrupts is set, A's cache serves altered chunks.
+	var A_corrupts atomic.Bool
+	var A_corrupted_hits atomic.Int32
+	corrupting := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if A_corrupts.Load() && strings.HasPrefix(r.URL.Path, abyst.CACHE_PATH) {
+				A_corrupted_hits.Add(1)
+				w = corruptingResponseWriter{w}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	A_host, B_host := newTestAbystHostPair(t,
+		&http3.Server{Handler: corrupting(counting(&A_origin_hits, "/asset.bin", A_server))},
+		&http3.Server{Handler: counting(&B_cache_hits, abyst.CACHE_PATH, B_server)})
+	A_hash := A_host.GetLocalAbyssURL().Hash
+	B_hash := B_host.GetLocalAbyssURL().Hash
+
+	newCache := func(dir string, client *http.Client) *abyst.AssetCache {
+		cache, err := abyst.NewAssetCache(dir, client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cache.ChunkSize = 1024
+		return cache
+	}
+	A_cache := newCache(t.TempDir(), A_host.AbystClient())
+	B_cache := newCache(t.TempDir(), B_host.AbystClient())
+	A_server.SetCache(A_cache)
+	B_server.SetCache(B_cache)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	addr := "abyst:" + A_hash + "/asset.bin"
+	var ref abyst.AssetRef
+	for {
+		var err error
+		if ref, err = B_cache.Resolve(ctx, addr); err == nil {
+			break
+		} else if ctx.Err() != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if ref.Size != int64(len(asset)) {
+		t.Fatal("wrong asset size", ref)
+	}
+
+	//B downloads from the origin; A has nothing cached.
+	B_path, err := B_cache.Fetch(ctx, addr, []string{A_hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(B_path); !bytes.Equal(data, asset) {
+		t.Fatal("wrong asset content")
+	}
+
+	//A can get it from B's cache alone.
+	A_path, err := A_cache.FetchRef(ctx, ref, "", []string{B_hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(A_path); !bytes.Equal(data, asset) || B_cache_hits.Load() != 10 {
+		t.Fatal("asset not fetched from member cache", B_cache_hits.Load())
+	}
+
+	//a partial download resumes with the missing chunks.
+	C_dir := t.TempDir()
+	C_cache := newCache(C_dir, B_host.AbystClient())
+	part := make([]byte, len(asset))
+	copy(part[:5*1024], asset[:5*1024])
+	os.WriteFile(filepath.Join(C_dir, ref.SHA256+".part"), part, 0644)
+	os.WriteFile(filepath.Join(C_dir, ref.SHA256+".chunks"), []byte{1, 1, 1, 1, 1, 0, 0, 0, 0, 0}, 0644)
+	origin_hits := A_origin_hits.Load()
+	C_path, err := C_cache.FetchRef(ctx, ref, addr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(C_path); !bytes.Equal(data, asset) || A_origin_hits.Load()-origin_hits != 5 {
+		t.Fatal("partial download not resumed", A_origin_hits.Load()-origin_hits)
+	}
+
+	//a member serving bad chunks is dropped; its chunks come from the origin, checked by the origin's chunk hashes.
+	A_corrupts.Store(true)
+	D_cache := newCache(t.TempDir(), B_host.AbystClient())
+	D_path, err := D_cache.FetchRef(ctx, ref, addr, []string{A_hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//workers that started at A before it was dropped ask it once each.
+	if data, _ := os.ReadFile(D_path); !bytes.Equal(data, asset) || A_corrupted_hits.Load() == 0 || A_corrupted_hits.Load() > abyst.ASSET_FETCH_WORKERS {
+		t.Fatal("bad member chunk not refetched", A_corrupted_hits.Load())
+	}
+}
+
+type corruptingResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w corruptingResponseWriter) Write(p []byte) (int, error) {
+	corrupted := bytes.Clone(p)
+	for i := range corrupted {
+		corrupted[i] ^= 0xff
+	}
+	return w.ResponseWriter.Write(corrupted)
+}
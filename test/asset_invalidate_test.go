@@ -0,0 +1,109 @@
+package test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+
+	"github.com/MinwooWebeng/abyss_core/abyst"
+	abyss_host "github.com/MinwooWebeng/abyss_core/host"
+	abyss "github.com/MinwooWebeng/abyss_core/interfaces"
+)
+
+func TestAssetInvalidate(t *testing.T) {
+	M_conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	A_conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := abyst.NewMemoryFS()
+	content.Set("main.aml", []byte("<aml></aml>"))
+	content.Set("assets/model.glb", []byte("v1"))
+	content_server := abyst.NewContentServer(content)
+	M_host, M_pathmap := newTestHost(t, M_conn, &http3.Server{Handler: content_server})
+	A_host, _ := newTestHost(t, A_conn, nil)
+	M_host.WatchContent(content_server, 50*time.Millisecond)
+
+	go M_host.ListenAndServe(context.Background())
+	go A_host.ListenAndServe(context.Background())
+
+	M_world, _ := M_host.OpenWorld("http://m.world.com")
+	M_pathmap.TrySetMapping("/home", M_world.SessionID())
+	world_aurl := M_host.GetLocalAbyssURL()
+	world_aurl.Path = "/home"
+	A_ready := make(chan bool, 1)
+	go func() {
+		ev_ch := M_world.GetEventChannel()
+		for {
+			switch event := (<-ev_ch).(type) {
+			case abyss.EWorldMemberRequest:
+				event.Accept()
+			case abyss.EWorldMemberReady:
+				A_ready <- true
+			}
+		}
+	}()
+
+	for _, pair := range [][2]*abyss_host.AbyssHost{{M_host, A_host}, {A_host, M_host}} {
+		identity := pair[1].NetworkService.LocalIdentity()
+		pair[0].NetworkService.AppendKnownPeer(identity.RootCertificate(), identity.HandshakeKeyCertificate())
+	}
+	A_host.OpenOutboundConnection(M_host.GetLocalAbyssURL())
+	M_host.OpenOutboundConnection(A_host.GetLocalAbyssURL())
+
+	join_ctx, join_ctx_cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer join_ctx_cancel()
+	A_world, err := A_host.JoinWorld(join_ctx, world_aurl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	invalidated := make(chan abyss.EMemberAssetInvalidate, 4)
+	go func() {
+		ev_ch := A_world.GetEventChannel()
+		for {
+			switch event := (<-ev_ch).(type) {
+			case abyss.EWorldMemberRequest:
+				event.Accept()
+			case abyss.EMemberAssetInvalidate:
+				invalidated <- event
+			}
+		}
+	}()
+	select {
+	case <-A_ready:
+	case <-time.After(5 * time.Second):
+		t.Fatal("A did not become a member")
+	}
+
+	//only the changed and removed paths are listed, with the ETag that is now served.
+	content.Set("assets/model.glb", []byte("v2"))
+	content.Set("main.aml", []byte("<aml>v2</aml>"))
+	var event abyss.EMemberAssetInvalidate
+	select {
+	case event = <-invalidated:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no invalidation")
+	}
+	manifest, _ := content_server.Manifest()
+	etag := "\"" + manifest.Files[0].SHA256 + "\""
+	if event.PeerHash != M_host.GetLocalAbyssURL().Hash || len(event.Assets) != 3 ||
+		event.Assets[0].Path != "/" || event.Assets[1] != (abyss.AssetChange{Path: "/assets/model.glb", ETag: etag}) || event.Assets[2].Path != "/main.aml" {
+		t.Fatal("wrong invalidation", event)
+	}
+
+	content.Delete("assets/model.glb")
+	select {
+	case event = <-invalidated:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no invalidation")
+	}
+	if len(event.Assets) != 1 || event.Assets[0] != (abyss.AssetChange{Path: "/assets/model.glb"}) {
+		t.Fatal("wrong removal", event)
+	}
+}
@@ -0,0 +1,133 @@
+package test
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+
+	abyss "github.com/MinwooWebeng/abyss_core/interfaces"
+	abyss_net "github.com/MinwooWebeng/abyss_core/net_service"
+)
+
+func TestBlocklist(t *testing.T) {
+	services := make([]*abyss_net.BetaNetService, 2)
+	for i := range services {
+		services[i] = newTestNetService(t, nil, nil)
+		go services[i].ListenAndServe()
+	}
+	A, B := services[0], services[1]
+	blocklist_path := filepath.Join(t.TempDir(), "blocklist")
+	blocklist, err := abyss_net.NewFileBlocklist(blocklist_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	A.SetBlocklist(blocklist)
+
+	A.AppendKnownPeer(B.LocalIdentity().RootCertificate(), B.LocalIdentity().HandshakeKeyCertificate())
+	B.AppendKnownPeer(A.LocalIdentity().RootCertificate(), A.LocalIdentity().HandshakeKeyCertificate())
+	A.ConnectAbyssAsync(B.LocalAURL())
+	B.ConnectAbyssAsync(A.LocalAURL())
+	waitAbyssPeer(t, B)
+	var B_at_A abyss.IANDPeer
+	select {
+	case B_at_A = <-A.GetAbyssPeerChannel():
+	case <-time.After(5 * time.Second):
+		t.Fatal("abyss connection timeout")
+	}
+
+	//existing connections are closed at once.
+	if err := A.BlockPeer(B.LocalIdentity().IDHash()); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for B_at_A.IsConnected() {
+		if time.Now().After(deadline) {
+			t.Fatal("blocked peer still connected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	//not dialed, not known, and not accepted.
+	if err := A.ConnectAbyssAsync(B.LocalAURL()); !errors.Is(err, abyss_net.ErrPeerBlocked) {
+		t.Fatal("blocked peer dialed")
+	}
+	if err := A.AppendKnownPeer(B.LocalIdentity().RootCertificate(), B.LocalIdentity().HandshakeKeyCertificate()); !errors.Is(err, abyss_net.ErrPeerBlocked) {
+		t.Fatal("blocked peer registered")
+	}
+	B.ConnectAbyssAsync(A.LocalAURL())
+	select {
+	case <-A.GetAbyssPeerChannel():
+		t.Fatal("blocked peer accepted")
+	case <-time.After(time.Second):
+	}
+
+	//persisted.
+	if err := A.BlockNetwork("192.0.2.0/24"); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := abyss_net.NewFileBlocklist(blocklist_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reloaded.IsPeerBlocked(B.LocalIdentity().IDHash()) || !reloaded.IsAddressBlocked(net.ParseIP("192.0.2.7")) || reloaded.IsAddressBlocked(net.ParseIP("192.0.3.7")) {
+		t.Fatal("blocklist not persisted")
+	}
+	if err := A.UnblockPeer(B.LocalIdentity().IDHash()); err != nil {
+		t.Fatal(err)
+	}
+	if A.IsPeerBlocked(B.LocalIdentity().IDHash()) {
+		t.Fatal("peer still blocked")
+	}
+}
+
+// abyst connections of a peer are closed when it is blocked, not only its abyss connections.
+func TestBlocklistAbyst(t *testing.T) {
+	A_host, B_host := newTestAbystHosts(t, &http3.Server{Handler: http.NotFoundHandler()})
+	A_hash := A_host.GetLocalAbyssURL().Hash
+	get := func() error {
+		response, err := B_host.AbystClient().Get("abyst:" + A_hash + "/")
+		if err != nil {
+			return err
+		}
+		return response.Body.Close()
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if err := get(); err == nil {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+	}
+
+	if err := A_host.NetworkService.(*abyss_net.BetaNetService).BlockPeer(B_host.GetLocalAbyssURL().Hash); err != nil {
+		t.Fatal(err)
+	}
+	if err := get(); err == nil {
+		t.Fatal("blocked peer served over its abyst connection")
+	}
+}
+
+// with a PeerStoreDir, the blocklist is kept there by default.
+func TestDefaultBlocklist(t *testing.T) {
+	config := abyss_net.NewDefaultBetaNetServiceConfig()
+	config.PeerStoreDir = t.TempDir()
+	netserv := newTestNetService(t, nil, config)
+	defer netserv.Shutdown(t.Context())
+
+	if err := netserv.BlockPeer("blocked"); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := abyss_net.NewFileBlocklist(filepath.Join(config.PeerStoreDir, netserv.LocalIdentity().IDHash()+abyss_net.BLOCKLIST_FILE_EXT))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reloaded.IsPeerBlocked("blocked") {
+		t.Fatal("blocklist not kept in PeerStoreDir")
+	}
+}
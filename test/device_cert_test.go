@@ -0,0 +1,143 @@
+package test
+
+import (
+	"crypto/ed25519"
+	crypto_rand "crypto/rand"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/MinwooWebeng/abyss_core/ahmp"
+	abyss "github.com/MinwooWebeng/abyss_core/interfaces"
+	abyss_net "github.com/MinwooWebeng/abyss_core/net_service"
+)
+
+func TestDeviceCertificate(t *testing.T) {
+	_, owner_key, _ := ed25519.GenerateKey(crypto_rand.Reader)
+	owner, err := abyss_net.NewRootIdentity(owner_key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	devices := make([]*abyss_net.BetaNetService, 2)
+	device_keys := make([]ed25519.PrivateKey, 2)
+	for i := range devices {
+		_, device_keys[i], _ = ed25519.GenerateKey(crypto_rand.Reader)
+		device_cert, err := owner.IssueDeviceCertificate(device_keys[i].Public(), abyss_net.DEVICE_CERTIFICATE_LIFETIME)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config := abyss_net.NewDefaultBetaNetServiceConfig()
+		config.DeviceCertificate = device_cert
+		devices[i] = newTestNetService(t, device_keys[i], config)
+		go devices[i].ListenAndServe()
+		if devices[i].LocalIdentity().OwnerHash() != owner.IDHash() || devices[i].LocalIdentity().DeviceID() != devices[i].LocalIdentity().IDHash() {
+			t.Fatal("device identity mismatch")
+		}
+	}
+
+	//a device certificate only works for its own key, and devices cannot delegate further.
+	_, other_key, _ := ed25519.GenerateKey(crypto_rand.Reader)
+	device_cert, _ := owner.IssueDeviceCertificate(device_keys[0].Public(), abyss_net.DEVICE_CERTIFICATE_LIFETIME)
+	if _, err := abyss_net.NewDeviceIdentity(other_key, device_cert, abyss_net.HANDSHAKE_KEY_MIGRATION); err == nil {
+		t.Fatal("device certificate accepted for another key")
+	}
+	device_identity, err := abyss_net.NewDeviceIdentity(device_keys[0], device_cert, abyss_net.HANDSHAKE_KEY_MIGRATION)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := device_identity.IssueDeviceCertificate(other_key.Public(), time.Hour); err == nil {
+		t.Fatal("device issued a device certificate")
+	}
+
+	//a peer sees both devices as the owner.
+	_, peer_key, _ := ed25519.GenerateKey(crypto_rand.Reader)
+	P := newTestNetService(t, peer_key, nil)
+	go P.ListenAndServe()
+	device_peers := make(map[string]abyss.IANDPeer)
+	for _, device := range devices {
+		P.AppendKnownPeer(device.LocalIdentity().RootCertificate(), device.LocalIdentity().HandshakeKeyCertificate())
+		device.AppendKnownPeer(P.LocalIdentity().RootCertificate(), P.LocalIdentity().HandshakeKeyCertificate())
+		P.ConnectAbyssAsync(device.LocalAURL())
+		device.ConnectAbyssAsync(P.LocalAURL())
+		select {
+		case peer := <-P.GetAbyssPeerChannel():
+			if peer.OwnerHash() != owner.IDHash() || peer.DeviceID() != peer.IDHash() {
+				t.Fatal("device not presented as its owner")
+			}
+			device_peers[peer.DeviceID()] = peer
+		case <-time.After(5 * time.Second):
+			t.Fatal("abyss connection timeout")
+		}
+	}
+	revoked, kept := devices[1].LocalIdentity().DeviceID(), devices[0].LocalIdentity().DeviceID()
+
+	//only the owner can revoke its devices, and revocations by unknown owners are not taken at all.
+	_, forger_key, _ := ed25519.GenerateKey(crypto_rand.Reader)
+	forger, _ := abyss_net.NewRootIdentity(forger_key)
+	forged, err := forger.RevokeDevice(kept)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := P.HandleDeviceRevocation(forged); !errors.Is(err, abyss_net.ErrUnknownRevocationOwner) {
+		t.Fatal("revocation by an unknown owner taken", err)
+	}
+
+	revocation, err := owner.RevokeDevice(revoked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := P.HandleDeviceRevocation(revocation); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for device_peers[revoked].IsConnected() {
+		if time.Now().After(deadline) {
+			t.Fatal("revoked device still connected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !device_peers[kept].IsConnected() {
+		t.Fatal("forged revocation disconnected a device")
+	}
+	if err := P.AppendKnownPeer(devices[1].LocalIdentity().RootCertificate(), devices[1].LocalIdentity().HandshakeKeyCertificate()); err == nil {
+		t.Fatal("revoked device accepted")
+	}
+
+	//the revocation survives a restart of P, and reaches peers that connect later.
+	P.Shutdown(t.Context())
+	P2 := newTestNetService(t, peer_key, nil)
+	go P2.ListenAndServe()
+	if err := P2.AppendKnownPeer(devices[1].LocalIdentity().RootCertificate(), devices[1].LocalIdentity().HandshakeKeyCertificate()); err == nil {
+		t.Fatal("revoked device accepted after restart")
+	}
+	_, late_key, _ := ed25519.GenerateKey(crypto_rand.Reader)
+	L := newTestNetService(t, late_key, nil)
+	go L.ListenAndServe()
+	L.AppendKnownPeer(P2.LocalIdentity().RootCertificate(), P2.LocalIdentity().HandshakeKeyCertificate())
+	P2.AppendKnownPeer(L.LocalIdentity().RootCertificate(), L.LocalIdentity().HandshakeKeyCertificate())
+	L.ConnectAbyssAsync(P2.LocalAURL())
+	P2.ConnectAbyssAsync(L.LocalAURL())
+	var P2_peer abyss.IANDPeer
+	select {
+	case P2_peer = <-L.GetAbyssPeerChannel():
+	case <-time.After(5 * time.Second):
+		t.Fatal("abyss connection timeout")
+	}
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case message := <-P2_peer.AhmpCh():
+			drv, ok := message.(*ahmp.DRV)
+			if !ok {
+				continue
+			}
+			if owner_hash, device_hash, err := abyss_net.VerifyDeviceRevocation(drv.Revocation); err != nil || owner_hash != owner.IDHash() || device_hash != revoked {
+				t.Fatal("unexpected revocation")
+			}
+			return
+		case <-timeout:
+			t.Fatal("revocation not sent on connection")
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/MinwooWebeng/abyss_core/aurl"
+	abyss_host "github.com/MinwooWebeng/abyss_core/host"
+	abyss "github.com/MinwooWebeng/abyss_core/interfaces"
+)
+
+func TestDHTJoinByHash(t *testing.T) {
+	const node_count = 6
+	hosts := make([]*abyss_host.AbyssHost, node_count)
+	path_resolvers := make([]*abyss_host.SimplePathResolver, node_count)
+	for i := range node_count {
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+		if err != nil {
+			t.Fatal(err)
+		}
+		hosts[i], path_resolvers[i] = newTestHost(t, conn, nil)
+		go hosts[i].ListenAndServe(context.Background())
+	}
+
+	//everyone bootstraps from node 0. the others meet through the DHT.
+	bootstrap := hosts[0].NetworkService.LocalIdentity()
+	for _, host := range hosts[1:] {
+		identity := host.NetworkService.LocalIdentity()
+		if err := hosts[0].NetworkService.AppendKnownPeer(identity.RootCertificate(), identity.HandshakeKeyCertificate()); err != nil {
+			t.Fatal(err)
+		}
+		if err := host.NetworkService.AppendKnownPeer(bootstrap.RootCertificate(), bootstrap.HandshakeKeyCertificate()); err != nil {
+			t.Fatal(err)
+		}
+		host.OpenOutboundConnection(hosts[0].GetLocalAbyssURL())
+		hosts[0].OpenOutboundConnection(host.GetLocalAbyssURL())
+	}
+	<-time.After(1500 * time.Millisecond) //the hosts also generate their RSA handshake keys in the background meanwhile.
+
+	//the world host has never met the joiner; only its pre-accepter lets the stranger in.
+	world_host := hosts[3]
+	world_host.NetworkService.HandlePreAccept(testPreAccepter{accept: true})
+	world, _ := world_host.OpenWorld("http://dht.world.com")
+	path_resolvers[3].TrySetMapping("home", world.SessionID())
+	go func() {
+		ev_ch := world.GetEventChannel()
+		for {
+			if request, ok := (<-ev_ch).(abyss.EWorldMemberRequest); ok {
+				request.Accept()
+			}
+		}
+	}()
+
+	//hash only: no addresses, and the joiner never registered the world host.
+	world_aurl, err := aurl.TryParse("abyss:" + world_host.GetLocalAbyssURL().Hash + "/home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	join_ctx, join_ctx_cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer join_ctx_cancel()
+	joined, err := hosts[5].JoinWorld(join_ctx, world_aurl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if joined.URL() != "http://dht.world.com" {
+		t.Fatal("joined wrong world: " + joined.URL())
+	}
+
+	resolved, err := hosts[4].NetworkService.ResolvePeer(context.Background(), hosts[1].GetLocalAbyssURL().Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved.ToString() != hosts[1].GetLocalAbyssURL().ToString() {
+		t.Fatal("resolved AURL mismatch: " + resolved.ToString())
+	}
+}
+
+type testPreAccepter struct {
+	accept bool
+}
+
+func (a testPreAccepter) PreAccept(peer_hash string, address *net.UDPAddr) (bool, int, string) {
+	return a.accept, 0x0B01, "not accepted"
+}
+
+func TestSelfIntroduction(t *testing.T) {
+	A_conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	A_host, _ := newTestHost(t, A_conn, nil)
+	go A_host.ListenAndServe(context.Background())
+	A_identity := A_host.NetworkService.LocalIdentity()
+
+	//X knows A; A has never heard of X.
+	dial := func() *abyss_host.AbyssHost {
+		X_conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+		if err != nil {
+			t.Fatal(err)
+		}
+		X_host, _ := newTestHost(t, X_conn, nil)
+		go X_host.ListenAndServe(context.Background())
+		if err := X_host.NetworkService.AppendKnownPeer(A_identity.RootCertificate(), A_identity.HandshakeKeyCertificate()); err != nil {
+			t.Fatal(err)
+		}
+		X_host.OpenOutboundConnection(A_host.GetLocalAbyssURL())
+		return X_host
+	}
+	isConnected := func(X_host *abyss_host.AbyssHost, timeout time.Duration) bool {
+		deadline := time.Now().Add(timeout)
+		for time.Now().Before(deadline) {
+			if connection, err := X_host.NetworkService.ConnectAbyst(A_identity.IDHash()); err == nil {
+				connection.CloseWithError(0, "")
+				return true
+			}
+			<-time.After(100 * time.Millisecond)
+		}
+		return false
+	}
+
+	//without a pre-accepter, the stranger is not taken.
+	if isConnected(dial(), 2*time.Second) {
+		t.Fatal("unsolicited stranger accepted")
+	}
+	A_host.NetworkService.HandlePreAccept(testPreAccepter{accept: false})
+	if isConnected(dial(), 2*time.Second) {
+		t.Fatal("stranger accepted against the pre-accepter")
+	}
+	A_host.NetworkService.HandlePreAccept(testPreAccepter{accept: true})
+	if !isConnected(dial(), 10*time.Second) {
+		t.Fatal("pre-accepted stranger not connected")
+	}
+}
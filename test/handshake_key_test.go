@@ -0,0 +1,111 @@
+package test
+
+import (
+	"crypto/ed25519"
+	crypto_rand "crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+
+	abyss_net "github.com/MinwooWebeng/abyss_core/net_service"
+)
+
+// every pair of handshake key schemes can connect, in both dial directions.
+func TestHandshakeKeySchemes(t *testing.T) {
+	schemes := []abyss_net.HandshakeKeyScheme{abyss_net.HANDSHAKE_KEY_MIGRATION, abyss_net.HANDSHAKE_KEY_HYBRID, abyss_net.HANDSHAKE_KEY_RSA}
+	for _, dialer_scheme := range schemes {
+		for _, accepter_scheme := range schemes {
+			dialer := newTestNetService(t, nil, handshakeSchemeConfig(dialer_scheme))
+			accepter := newTestNetService(t, nil, handshakeSchemeConfig(accepter_scheme))
+			go dialer.ListenAndServe()
+			go accepter.ListenAndServe()
+			dialer.AppendKnownPeer(accepter.LocalIdentity().RootCertificate(), accepter.LocalIdentity().HandshakeKeyCertificate())
+			accepter.AppendKnownPeer(dialer.LocalIdentity().RootCertificate(), dialer.LocalIdentity().HandshakeKeyCertificate())
+
+			dialer.ConnectAbyssAsync(accepter.LocalAURL())
+			accepter.ConnectAbyssAsync(dialer.LocalAURL())
+			waitAbyssPeer(t, dialer)
+			waitAbyssPeer(t, accepter)
+		}
+	}
+}
+
+// a new HANDSHAKE_KEY_MIGRATION key starts hybrid-only, and gets its RSA half in the background.
+// the hybrid key is kept, so that a peer given the first certificate can still dial.
+func TestHandshakeKeyDeferredRSA(t *testing.T) {
+	accepter := newTestNetService(t, nil, handshakeSchemeConfig(abyss_net.HANDSHAKE_KEY_MIGRATION))
+	go accepter.ListenAndServe()
+	first := accepter.LocalIdentity().HandshakeKeyCertificate()
+	if !strings.HasSuffix(handshakeKeySubject(t, first), abyss_net.HANDSHAKE_HYBRID_SUFFIX) {
+		t.Fatal("RSA key generated on the start path")
+	}
+
+	completed := first
+	for deadline := time.Now().Add(10 * time.Second); completed == first; completed = accepter.LocalIdentity().HandshakeKeyCertificate() {
+		if time.Now().After(deadline) {
+			t.Fatal("RSA key not added")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if !strings.HasSuffix(handshakeKeySubject(t, completed), abyss_net.HANDSHAKE_RSA_SUFFIX) {
+		t.Fatal("completed handshake key has no RSA key")
+	}
+
+	dialer := newTestNetService(t, nil, handshakeSchemeConfig(abyss_net.HANDSHAKE_KEY_HYBRID))
+	go dialer.ListenAndServe()
+	dialer.AppendKnownPeer(accepter.LocalIdentity().RootCertificate(), first)
+	accepter.AppendKnownPeer(dialer.LocalIdentity().RootCertificate(), dialer.LocalIdentity().HandshakeKeyCertificate())
+	dialer.ConnectAbyssAsync(accepter.LocalAURL())
+	accepter.ConnectAbyssAsync(dialer.LocalAURL())
+	waitAbyssPeer(t, dialer)
+	waitAbyssPeer(t, accepter)
+}
+
+func handshakeKeySubject(t *testing.T, handshake_key_cert string) string {
+	block, _ := pem.Decode([]byte(handshake_key_cert))
+	if block == nil {
+		t.Fatal("invalid handshake key certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert.Subject.CommonName
+}
+
+// a restart reuses the kept handshake key, unless the scheme changes or it is not kept.
+func TestIdentityStateDir(t *testing.T) {
+	_, privkey, _ := ed25519.GenerateKey(crypto_rand.Reader)
+	handshakeKeyCertificate := func(config *abyss_net.BetaNetServiceConfig) string {
+		netserv := newTestNetService(t, privkey, config)
+		defer netserv.Shutdown(t.Context())
+		return netserv.LocalIdentity().HandshakeKeyCertificate()
+	}
+
+	config := abyss_net.NewDefaultBetaNetServiceConfig()
+	config.IdentityStateDir = t.TempDir()
+	first := handshakeKeyCertificate(config)
+	if handshakeKeyCertificate(config) != first {
+		t.Fatal("handshake key not reused")
+	}
+	config.HandshakeKeyScheme = abyss_net.HANDSHAKE_KEY_HYBRID
+	hybrid := handshakeKeyCertificate(config)
+	if hybrid == first {
+		t.Fatal("handshake key of another scheme reused")
+	}
+	if handshakeKeyCertificate(config) != hybrid {
+		t.Fatal("replaced handshake key not kept")
+	}
+	config.IdentityStateDir = ""
+	if handshakeKeyCertificate(config) == hybrid {
+		t.Fatal("handshake key reused without IdentityStateDir")
+	}
+}
+
+func handshakeSchemeConfig(scheme abyss_net.HandshakeKeyScheme) *abyss_net.BetaNetServiceConfig {
+	config := abyss_net.NewDefaultBetaNetServiceConfig()
+	config.HandshakeKeyScheme = scheme
+	return config
+}
@@ -0,0 +1,78 @@
+package test
+
+import (
+	"bytes"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/MinwooWebeng/abyss_core/ahmp"
+	abyss "github.com/MinwooWebeng/abyss_core/interfaces"
+	abyss_net "github.com/MinwooWebeng/abyss_core/net_service"
+)
+
+func TestHandshakeKeyRotation(t *testing.T) {
+	services := make([]*abyss_net.BetaNetService, 3)
+	for i := range services {
+		services[i] = newTestNetService(t, nil, nil)
+		go services[i].ListenAndServe()
+	}
+	A, B, C := services[0], services[1], services[2]
+	A_root, A_old_handshake := A.LocalIdentity().RootCertificate(), A.LocalIdentity().HandshakeKeyCertificate()
+
+	A.AppendKnownPeer(B.LocalIdentity().RootCertificate(), B.LocalIdentity().HandshakeKeyCertificate())
+	B.AppendKnownPeer(A_root, A_old_handshake)
+	A.ConnectAbyssAsync(B.LocalAURL())
+	B.ConnectAbyssAsync(A.LocalAURL())
+	waitAbyssPeer(t, A)
+	var A_at_B abyss.IANDPeer
+	select {
+	case A_at_B = <-B.GetAbyssPeerChannel():
+	case <-time.After(5 * time.Second):
+		t.Fatal("abyss connection timeout")
+	}
+
+	if err := A.RotateHandshakeKey(time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	A_new_handshake, _ := pem.Decode([]byte(A.LocalIdentity().HandshakeKeyCertificate()))
+
+	//B takes the new key from the announcement.
+	timeout := time.After(5 * time.Second)
+	for rotated := false; !rotated; {
+		select {
+		case message := <-A_at_B.AhmpCh():
+			if hkr, ok := message.(*ahmp.HKR); ok {
+				if err := B.HandleHandshakeKeyRotation(A_at_B.IDHash(), hkr.HandshakeKeyCertificateDer); err != nil {
+					t.Fatal(err)
+				}
+				rotated = true
+			}
+		case <-timeout:
+			t.Fatal("handshake key rotation not announced")
+		}
+	}
+	if !bytes.Equal(A_at_B.HandshakeKeyCertificateDer(), A_new_handshake.Bytes) {
+		t.Fatal("handshake key not replaced")
+	}
+
+	//C cached the old certificate. within the grace window, it can still dial.
+	C.AppendKnownPeer(A_root, A_old_handshake)
+	A.AppendKnownPeer(C.LocalIdentity().RootCertificate(), C.LocalIdentity().HandshakeKeyCertificate())
+	C.ConnectAbyssAsync(A.LocalAURL())
+	A.ConnectAbyssAsync(C.LocalAURL())
+	waitAbyssPeer(t, C)
+
+	//an older certificate never replaces a newer one.
+	if err := B.HandleHandshakeKeyRotation(A_at_B.IDHash(), mustDecodePem(t, A_old_handshake)); err == nil {
+		t.Fatal("handshake key rolled back")
+	}
+}
+
+func mustDecodePem(t *testing.T, pem_string string) []byte {
+	block, _ := pem.Decode([]byte(pem_string))
+	if block == nil {
+		t.Fatal("invalid pem")
+	}
+	return block.Bytes
+}
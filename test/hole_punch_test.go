@@ -0,0 +1,187 @@
+package test
+
+import (
+	"context"
+	"crypto/ed25519"
+	crypto_rand "crypto/rand"
+	"encoding/pem"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+
+	abyss_and "github.com/MinwooWebeng/abyss_core/and"
+	abyss_host "github.com/MinwooWebeng/abyss_core/host"
+	abyss "github.com/MinwooWebeng/abyss_core/interfaces"
+	abyss_net "github.com/MinwooWebeng/abyss_core/net_service"
+)
+
+// address-restricted cone NAT with port translation.
+// LocalAddr() reports the internal port, which is never read from; traffic goes through the external port.
+// inbound packets are dropped unless we have sent to that address before.
+type NATEmulatedConn struct {
+	net.PacketConn //external
+	internal       net.PacketConn
+	opened         map[string]bool
+	mtx            *sync.Mutex
+}
+
+func NewNATEmulatedConn() *NATEmulatedConn {
+	internal, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+	if err != nil {
+		panic(err)
+	}
+	external, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+	if err != nil {
+		panic(err)
+	}
+	return &NATEmulatedConn{
+		PacketConn: external,
+		internal:   internal,
+		opened:     make(map[string]bool),
+		mtx:        new(sync.Mutex),
+	}
+}
+
+func (c *NATEmulatedConn) LocalAddr() net.Addr {
+	return c.internal.LocalAddr()
+}
+
+func (c *NATEmulatedConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	c.mtx.Lock()
+	c.opened[addr.String()] = true
+	c.mtx.Unlock()
+	return c.PacketConn.WriteTo(p, addr)
+}
+
+func (c *NATEmulatedConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(p)
+		if err != nil {
+			return n, addr, err
+		}
+		c.mtx.Lock()
+		is_open := c.opened[addr.String()]
+		c.mtx.Unlock()
+		if is_open {
+			return n, addr, nil
+		}
+	}
+}
+
+func (c *NATEmulatedConn) Close() error {
+	c.internal.Close()
+	return c.PacketConn.Close()
+}
+
+func newTestHost(t *testing.T, conn net.PacketConn, abyst_server *http3.Server) (*abyss_host.AbyssHost, *abyss_host.SimplePathResolver) {
+	_, privkey, err := ed25519.GenerateKey(crypto_rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	address_selector, err := abyss_net.NewBetaAddressSelector()
+	if err != nil {
+		t.Fatal(err)
+	}
+	netserv, err := abyss_net.NewBetaNetServiceWithConn(context.Background(), &privkey, address_selector, abyst_server, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path_resolver := abyss_host.NewSimplePathResolver()
+	return abyss_host.NewAbyssHost(netserv, abyss_and.NewAND(netserv.LocalAURL().Hash), path_resolver), path_resolver
+}
+
+func TestHolePunch(t *testing.T) {
+	M_conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	M_host, M_pathmap := newTestHost(t, M_conn, nil)
+	A_host, _ := newTestHost(t, NewNATEmulatedConn(), nil)
+	B_host, _ := newTestHost(t, NewNATEmulatedConn(), nil)
+
+	go M_host.ListenAndServe(context.Background())
+	go A_host.ListenAndServe(context.Background())
+	go B_host.ListenAndServe(context.Background())
+
+	//A and B only know M; M knows both.
+	for _, pair := range [][2]*abyss_host.AbyssHost{{M_host, A_host}, {M_host, B_host}, {A_host, M_host}, {B_host, M_host}} {
+		identity := pair[1].NetworkService.LocalIdentity()
+		if err := pair[0].NetworkService.AppendKnownPeer(identity.RootCertificate(), identity.HandshakeKeyCertificate()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	M_world, _ := M_host.OpenWorld("http://m.world.com")
+	M_pathmap.TrySetMapping("/home", M_world.SessionID())
+	world_aurl := M_host.GetLocalAbyssURL()
+	world_aurl.Path = "/home"
+	go func() {
+		ev_ch := M_world.GetEventChannel()
+		for {
+			if request, ok := (<-ev_ch).(abyss.EWorldMemberRequest); ok {
+				request.Accept()
+			}
+		}
+	}()
+
+	//M cannot reach A or B unless they dial M first.
+	//(the hosts generate their RSA handshake keys in the background meanwhile, so this may take a while on a busy machine.)
+	A_host.OpenOutboundConnection(M_host.GetLocalAbyssURL())
+	B_host.OpenOutboundConnection(M_host.GetLocalAbyssURL())
+	<-time.After(500 * time.Millisecond)
+	M_host.OpenOutboundConnection(A_host.GetLocalAbyssURL())
+	M_host.OpenOutboundConnection(B_host.GetLocalAbyssURL())
+
+	join_ctx, join_ctx_cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer join_ctx_cancel()
+	A_world, err := A_host.JoinWorld(join_ctx, world_aurl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	B_ready := make(chan bool, 1)
+	go func() {
+		ev_ch := A_world.GetEventChannel()
+		for {
+			switch event := (<-ev_ch).(type) {
+			case abyss.EWorldMemberRequest:
+				event.Accept()
+			case abyss.EWorldMemberReady:
+				if event.Member.Hash() == B_host.GetLocalAbyssURL().Hash {
+					B_ready <- true
+				}
+			}
+		}
+	}()
+	B_world, err := B_host.JoinWorld(join_ctx, world_aurl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		ev_ch := B_world.GetEventChannel()
+		for {
+			if request, ok := (<-ev_ch).(abyss.EWorldMemberRequest); ok {
+				request.Accept()
+			}
+		}
+	}()
+
+	//A and B meet through M: the direct dial is dropped by the emulated NAT, so they must punch through.
+	select {
+	case <-B_ready:
+	case <-time.After(20 * time.Second):
+		t.Fatal("A and B did not connect")
+	}
+
+	//a peer cannot make B punch towards a stranger that B never asked for.
+	X_host, _ := newTestHost(t, NewNATEmulatedConn(), nil)
+	X_identity := X_host.NetworkService.LocalIdentity()
+	X_root_cert, _ := pem.Decode([]byte(X_identity.RootCertificate()))
+	X_handshake_key_cert, _ := pem.Decode([]byte(X_identity.HandshakeKeyCertificate()))
+	if err := B_host.NetworkService.HandlePunchNotice(A_host.GetLocalAbyssURL().Hash, X_identity.IDHash(), X_root_cert.Bytes, X_handshake_key_cert.Bytes,
+		X_host.GetLocalAbyssURL().Addresses, 0); err == nil {
+		t.Fatal("unexpected punch notice accepted")
+	}
+}
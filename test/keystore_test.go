@@ -0,0 +1,126 @@
+package test
+
+import (
+	"crypto/ed25519"
+	crypto_rand "crypto/rand"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/MinwooWebeng/abyss_core/keystore"
+	abyss_net "github.com/MinwooWebeng/abyss_core/net_service"
+	"github.com/fxamacker/cbor/v2"
+)
+
+func TestKeystore(t *testing.T) {
+	private_key, err := keystore.GenerateKey("ed25519")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := keystore.New(private_key, abyss_net.HANDSHAKE_KEY_MIGRATION)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "identity.aks")
+	if err := store.Save(path, []byte("correct horse")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keystore.Load(path, []byte("wrong horse")); !errors.Is(err, keystore.ErrWrongPassphrase) {
+		t.Fatal("wrong passphrase accepted")
+	}
+	loaded, err := keystore.Load(path, []byte("correct horse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Identity.IDHash() != store.Identity.IDHash() ||
+		loaded.Identity.RootCertificate() != store.Identity.RootCertificate() ||
+		loaded.Identity.HandshakeKeyCertificate() != store.Identity.HandshakeKeyCertificate() {
+		t.Fatal("identity not restored")
+	}
+
+	//the root key alone, for other tools.
+	private_key_pem, err := loaded.ExportPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported, err := keystore.ImportPrivateKey(private_key_pem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := abyss_net.AbyssIdFromKey(imported.Public()); id != store.Identity.IDHash() {
+		t.Fatal("imported key mismatch")
+	}
+
+	//a host started from the keystore keeps the certificates handed out in the bundle.
+	config := abyss_net.NewDefaultBetaNetServiceConfig()
+	if err := loaded.Configure(config); err != nil {
+		t.Fatal(err)
+	}
+	A := newTestNetService(t, loaded.PrivateKey, config)
+	go A.ListenAndServe()
+	if A.LocalIdentity().HandshakeKeyCertificate() != store.Identity.HandshakeKeyCertificate() {
+		t.Fatal("host did not keep the stored handshake key")
+	}
+
+	bundle_pem, err := keystore.NewPeerBundle(store.Identity, A.LocalAURL().Addresses).Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle, err := keystore.ParsePeerBundle(bundle_pem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, B_key, _ := ed25519.GenerateKey(crypto_rand.Reader)
+	B := newTestNetService(t, B_key, nil)
+	go B.ListenAndServe()
+	if err := bundle.Register(B); err != nil {
+		t.Fatal(err)
+	}
+	A.AppendKnownPeer(B.LocalIdentity().RootCertificate(), B.LocalIdentity().HandshakeKeyCertificate())
+	B.ConnectAbyssAsync(bundle.AURL)
+	A.ConnectAbyssAsync(B.LocalAURL())
+	waitAbyssPeer(t, A)
+	waitAbyssPeer(t, B)
+}
+
+// a keystore asking for more argon2id work than the maxima is refused before the key derivation.
+func TestKeystoreKDFLimits(t *testing.T) {
+	private_key, err := keystore.GenerateKey("ed25519")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := keystore.New(private_key, abyss_net.HANDSHAKE_KEY_HYBRID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "identity.aks")
+	if err := store.Save(path, []byte("correct horse")); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(data)
+	for field, value := range map[string]uint64{
+		"Time":    keystore.ARGON2_MAX_TIME + 1,
+		"Memory":  keystore.ARGON2_MAX_MEMORY + 1,
+		"Threads": keystore.ARGON2_MAX_THREADS + 1,
+	} {
+		var raw map[string]any
+		if err := cbor.Unmarshal(block.Bytes, &raw); err != nil {
+			t.Fatal(err)
+		}
+		raw[field] = value
+		body, err := cbor.Marshal(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = keystore.Decrypt(pem.EncodeToMemory(&pem.Block{Type: keystore.PEM_TYPE, Bytes: body}), []byte("correct horse"))
+		if err == nil || errors.Is(err, keystore.ErrWrongPassphrase) {
+			t.Fatal(field, "above the maximum not refused:", err)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	abyss_net "github.com/MinwooWebeng/abyss_core/net_service"
+)
+
+func TestLANDiscovery(t *testing.T) {
+	A := newTestNetService(t, nil, nil)
+	B := newTestNetService(t, nil, nil)
+
+	config := abyss_net.NewDefaultLANDiscoveryConfig()
+	config.GroupAddress = &net.UDPAddr{IP: net.IPv4(239, 255, 77, 78), Port: 17778}
+	config.Interval = 100 * time.Millisecond
+	config.AutoRegister = true
+	for _, netserv := range []*abyss_net.BetaNetService{A, B} {
+		if err := netserv.StartLANDiscoveryWithConfig(config); err != nil {
+			t.Skip("multicast unavailable: " + err.Error())
+		}
+	}
+
+	for _, pair := range [][2]*abyss_net.BetaNetService{{A, B}, {B, A}} {
+		select {
+		case lan_peer := <-pair[0].GetLANPeerChannel():
+			if lan_peer.AURL.Hash != pair[1].LocalAURL().Hash {
+				t.Fatal("unexpected LAN peer: " + lan_peer.AURL.ToString())
+			}
+			if lan_peer.AURL.ToString() != pair[1].LocalAURL().ToString() {
+				t.Fatal("AURL mismatch: " + lan_peer.AURL.ToString())
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("LAN peer not discovered")
+		}
+	}
+
+	//announced again, but not surfaced twice.
+	select {
+	case lan_peer := <-A.GetLANPeerChannel():
+		t.Fatal("duplicate LAN peer: " + lan_peer.AURL.ToString())
+	case <-time.After(500 * time.Millisecond):
+	}
+}
@@ -0,0 +1,49 @@
+package test
+
+import (
+	"crypto/ed25519"
+	crypto_rand "crypto/rand"
+	"os"
+	"testing"
+
+	abyss_net "github.com/MinwooWebeng/abyss_core/net_service"
+)
+
+// services keep known peers in the user config directory by default; tests get their own.
+func TestMain(m *testing.M) {
+	config_home, err := os.MkdirTemp("", "abyss-test-config")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("XDG_CONFIG_HOME", config_home)
+	os.Setenv("HOME", config_home)
+	os.Setenv("AppData", config_home)
+	code := m.Run()
+	os.RemoveAll(config_home)
+	os.Exit(code)
+}
+
+// a network service that lives until the test ends. root_key nil: a new ed25519 key. config nil: the default.
+// it is not serving yet, so that the caller can set it up first.
+func newTestNetService(t *testing.T, root_key abyss_net.PrivateKey, config *abyss_net.BetaNetServiceConfig) *abyss_net.BetaNetService {
+	t.Helper()
+	if root_key == nil {
+		_, privkey, err := ed25519.GenerateKey(crypto_rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		root_key = privkey
+	}
+	if config == nil {
+		config = abyss_net.NewDefaultBetaNetServiceConfig()
+	}
+	address_selector, err := abyss_net.NewBetaAddressSelector()
+	if err != nil {
+		t.Fatal(err)
+	}
+	netserv, err := abyss_net.NewBetaNetServiceWithConfig(t.Context(), root_key, address_selector, nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return netserv
+}
@@ -0,0 +1,82 @@
+package test
+
+import (
+	"context"
+	"crypto/ed25519"
+	crypto_rand "crypto/rand"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+
+	"github.com/MinwooWebeng/abyss_core/aurl"
+	abyss_host "github.com/MinwooWebeng/abyss_core/host"
+	abyss_net "github.com/MinwooWebeng/abyss_core/net_service"
+)
+
+func TestNetServiceConfig(t *testing.T) {
+	//find a free port to bind on purpose.
+	probe, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixed_port := probe.LocalAddr().(*net.UDPAddr).Port
+	probe.Close()
+
+	advertised := &net.UDPAddr{IP: net.IPv4(203, 0, 113, 7), Port: 4433}
+	_, A_privkey, err := ed25519.GenerateKey(crypto_rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	A_host, _, err := abyss_host.NewBetaAbyssHostWithConfig(context.Background(), &A_privkey, &http3.Server{Handler: http.NewServeMux()}, &abyss_net.BetaNetServiceConfig{
+		ListenAddresses:     []*net.UDPAddr{{IP: net.IPv4zero, Port: 0}, {IP: net.IPv4(127, 0, 0, 1), Port: fixed_port}},
+		AdvertisedAddresses: []*net.UDPAddr{advertised},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	A_aurl := A_host.GetLocalAbyssURL()
+	if !A_aurl.Addresses[0].IP.Equal(advertised.IP) || A_aurl.Addresses[0].Port != advertised.Port {
+		t.Fatal("advertised address must come first: " + A_aurl.ToString())
+	}
+	if !containsPort(A_aurl.Addresses, fixed_port) {
+		t.Fatal("fixed port missing: " + A_aurl.ToString())
+	}
+
+	B_conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	B_host, _ := newTestHost(t, B_conn, nil)
+	go A_host.ListenAndServe(context.Background())
+	go B_host.ListenAndServe(context.Background())
+	for _, pair := range [][2]*abyss_host.AbyssHost{{A_host, B_host}, {B_host, A_host}} {
+		identity := pair[1].NetworkService.LocalIdentity()
+		if err := pair[0].NetworkService.AppendKnownPeer(identity.RootCertificate(), identity.HandshakeKeyCertificate()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//B reaches A only through the fixed port socket.
+	B_host.OpenOutboundConnection(&aurl.AURL{
+		Scheme:    "abyss",
+		Hash:      A_aurl.Hash,
+		Addresses: []*net.UDPAddr{{IP: net.IPv4(127, 0, 0, 1), Port: fixed_port}},
+	})
+	<-time.After(100 * time.Millisecond)
+	A_host.OpenOutboundConnection(B_host.GetLocalAbyssURL())
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		<-time.After(200 * time.Millisecond)
+		connection, err := B_host.NetworkService.ConnectAbyst(A_aurl.Hash)
+		if err == nil {
+			connection.CloseWithError(0, "")
+			return
+		}
+	}
+	t.Fatal("B could not reach A on the fixed port")
+}
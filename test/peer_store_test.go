@@ -0,0 +1,194 @@
+package test
+
+import (
+	"context"
+	"crypto/ed25519"
+	crypto_rand "crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+
+	"github.com/MinwooWebeng/abyss_core/aurl"
+	abyss "github.com/MinwooWebeng/abyss_core/interfaces"
+	abyss_net "github.com/MinwooWebeng/abyss_core/net_service"
+)
+
+func TestPeerStore(t *testing.T) {
+	store_path := filepath.Join(t.TempDir(), "peers")
+
+	_, key_a, _ := ed25519.GenerateKey(crypto_rand.Reader)
+	_, key_b, _ := ed25519.GenerateKey(crypto_rand.Reader)
+
+	A := newTestNetService(t, key_a, nil)
+	B := newTestNetService(t, key_b, nil)
+	store, err := abyss_net.NewFilePeerStore(store_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := A.SetPeerStore(store, abyss_net.TOFU_REJECT); err != nil {
+		t.Fatal(err)
+	}
+	go A.ListenAndServe()
+	go B.ListenAndServe()
+
+	A.AppendKnownPeer(B.LocalIdentity().RootCertificate(), B.LocalIdentity().HandshakeKeyCertificate())
+	B.AppendKnownPeer(A.LocalIdentity().RootCertificate(), A.LocalIdentity().HandshakeKeyCertificate())
+	A.ConnectAbyssAsync(B.LocalAURL())
+	B.ConnectAbyssAsync(A.LocalAURL())
+	waitAbyssPeer(t, A)
+	waitAbyssPeer(t, B)
+
+	//a new service on A's store (A itself stays connected to B, so it takes another identity).
+	//B is dialed by hash alone, from the stored certificates and address.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		reloaded, err := abyss_net.NewFilePeerStore(store_path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if known_peer, ok := reloaded.Get(B.LocalAURL().Hash); ok && len(known_peer.Addresses) != 0 {
+			store = reloaded
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("peer address not stored")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	_, key_a2, _ := ed25519.GenerateKey(crypto_rand.Reader)
+	A2 := newTestNetService(t, key_a2, nil)
+	if err := A2.SetPeerStore(store, abyss_net.TOFU_REJECT); err != nil {
+		t.Fatal(err)
+	}
+	go A2.ListenAndServe()
+	B.AppendKnownPeer(A2.LocalIdentity().RootCertificate(), A2.LocalIdentity().HandshakeKeyCertificate())
+	A2.ConnectAbyssAsync(&aurl.AURL{Scheme: "abyss", Hash: B.LocalAURL().Hash})
+	B.ConnectAbyssAsync(A2.LocalAURL())
+	waitAbyssPeer(t, A2)
+
+	//B restarts with a new handshake key. A2 refuses it.
+	B2 := newTestNetService(t, key_b, nil)
+	err = A2.AppendKnownPeer(B2.LocalIdentity().RootCertificate(), B2.LocalIdentity().HandshakeKeyCertificate())
+	if !errors.Is(err, abyss_net.ErrHandshakeKeyChanged) {
+		t.Fatal("changed handshake key accepted")
+	}
+	select {
+	case change := <-A2.GetPeerKeyChangeChannel():
+		if change.PeerHash != B.LocalAURL().Hash || change.Accepted {
+			t.Fatal("unexpected key change report")
+		}
+	default:
+		t.Fatal("key change not reported")
+	}
+}
+
+func TestDefaultPeerStore(t *testing.T) {
+	if dir := abyss_net.NewDefaultBetaNetServiceConfig().PeerStoreDir; dir == "" {
+		t.Fatal("no default peer store")
+	}
+
+	//writes are batched until the delay or Flush.
+	batch_path := filepath.Join(t.TempDir(), "batched")
+	batched, _ := abyss_net.NewFilePeerStore(batch_path)
+	for range 100 {
+		batched.Put("peer", abyss.KnownPeer{LastSeen: time.Now()})
+	}
+	if _, err := os.Stat(batch_path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("peer store written on each Put")
+	}
+	if err := batched.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(batch_path); err != nil {
+		t.Fatal("peer store not flushed")
+	}
+
+	//A restarts with the same key and directory, and still knows B.
+	config := abyss_net.NewDefaultBetaNetServiceConfig()
+	config.PeerStoreDir = t.TempDir()
+	_, key_a, _ := ed25519.GenerateKey(crypto_rand.Reader)
+	newA := func() *abyss_net.BetaNetService {
+		netserv := newTestNetService(t, key_a, config)
+		go netserv.ListenAndServe()
+		return netserv
+	}
+	B := newTestNetService(t, nil, nil)
+	go B.ListenAndServe()
+
+	A := newA()
+	A.AppendKnownPeer(B.LocalIdentity().RootCertificate(), B.LocalIdentity().HandshakeKeyCertificate())
+	B.AppendKnownPeer(A.LocalIdentity().RootCertificate(), A.LocalIdentity().HandshakeKeyCertificate())
+	A.ConnectAbyssAsync(B.LocalAURL())
+	B.ConnectAbyssAsync(A.LocalAURL())
+	waitAbyssPeer(t, A)
+	waitAbyssPeer(t, B)
+	shutdown_ctx, shutdown_cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer shutdown_cancel()
+	A.Shutdown(shutdown_ctx)
+
+	//B forgets A on its shutdown; A2 dials B by hash alone, from the stored certificates and address.
+	A2 := newA()
+	B.AppendKnownPeer(A2.LocalIdentity().RootCertificate(), A2.LocalIdentity().HandshakeKeyCertificate())
+	if err := A2.ConnectAbyssAsync(&aurl.AURL{Scheme: "abyss", Hash: B.LocalAURL().Hash}); err != nil {
+		t.Fatal(err)
+	}
+	B.ConnectAbyssAsync(A2.LocalAURL())
+	waitAbyssPeer(t, A2)
+	waitAbyssPeer(t, B)
+}
+
+func waitAbyssPeer(t *testing.T, netserv *abyss_net.BetaNetService) {
+	t.Helper()
+	select {
+	case <-netserv.GetAbyssPeerChannel():
+	case <-time.After(5 * time.Second):
+		t.Fatal("abyss connection timeout")
+	}
+}
+
+// a truncated store file is moved aside, and bad entries are skipped; neither stops the service.
+func TestPeerStoreCorrupt(t *testing.T) {
+	store_dir := t.TempDir()
+	_, privkey, _ := ed25519.GenerateKey(crypto_rand.Reader)
+	config := abyss_net.NewDefaultBetaNetServiceConfig()
+	config.PeerStoreDir = store_dir
+	netserv := newTestNetService(t, privkey, config)
+	store_path := filepath.Join(store_dir, netserv.LocalIdentity().IDHash()+abyss_net.PEER_STORE_FILE_EXT)
+	netserv.Shutdown(t.Context())
+
+	//a partial entry.
+	data, err := cbor.Marshal(map[string]any{
+		"good": map[string]any{"RootCertificateDer": []byte{1}, "LastSeen": time.Now().UnixMilli()},
+		"bad":  "not a peer",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(store_path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := abyss_net.NewFilePeerStore(store_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.Get("good"); !ok {
+		t.Fatal("good entry dropped")
+	}
+	if _, ok := store.Get("bad"); ok {
+		t.Fatal("bad entry loaded")
+	}
+
+	//a truncated file.
+	if err := os.WriteFile(store_path, data[:len(data)/2], 0600); err != nil {
+		t.Fatal(err)
+	}
+	netserv = newTestNetService(t, privkey, config)
+	defer netserv.Shutdown(t.Context())
+	if _, err := os.Stat(store_path + abyss_net.PEER_STORE_CORRUPT_EXT); err != nil {
+		t.Fatal("corrupt peer store not moved aside")
+	}
+}
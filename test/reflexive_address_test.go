@@ -0,0 +1,146 @@
+package test
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	abyss_host "github.com/MinwooWebeng/abyss_core/host"
+	abyss "github.com/MinwooWebeng/abyss_core/interfaces"
+	abyss_net "github.com/MinwooWebeng/abyss_core/net_service"
+)
+
+// the socket of an observer behind which one peer port looks like a public address:
+// packets from that port are read as from public_ip, and packets to public_ip go back where they came from.
+type PublicAddressConn struct {
+	net.PacketConn
+	port      int
+	public_ip net.IP
+	original  net.Addr
+	mtx       *sync.Mutex
+}
+
+func NewPublicAddressConn(port int, public_ip net.IP) *PublicAddressConn {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+	if err != nil {
+		panic(err)
+	}
+	return &PublicAddressConn{
+		PacketConn: conn,
+		port:       port,
+		public_ip:  public_ip,
+		mtx:        new(sync.Mutex),
+	}
+}
+
+func (c *PublicAddressConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(p)
+	if udp_addr, ok := addr.(*net.UDPAddr); ok && udp_addr.Port == c.port {
+		c.mtx.Lock()
+		c.original = addr
+		c.mtx.Unlock()
+		return n, &net.UDPAddr{IP: c.public_ip, Port: c.port}, err
+	}
+	return n, addr, err
+}
+
+func (c *PublicAddressConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if udp_addr, ok := addr.(*net.UDPAddr); ok && udp_addr.IP.Equal(c.public_ip) {
+		c.mtx.Lock()
+		addr = c.original
+		c.mtx.Unlock()
+	}
+	return c.PacketConn.WriteTo(p, addr)
+}
+
+func TestReflexiveAddressConsensus(t *testing.T) {
+	table := abyss_net.NewReflexiveAddressTable()
+	public_1 := &net.UDPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 4433}
+	public_2 := &net.UDPAddr{IP: net.IPv4(198, 51, 100, 2), Port: 4433}
+
+	if _, changed := table.Report("A", &net.UDPAddr{IP: net.IPv4(192, 168, 0, 2), Port: 4433}); changed {
+		t.Fatal("private address accepted")
+	}
+	if _, changed := table.Report("A", public_1); changed {
+		t.Fatal("one peer made a consensus")
+	}
+	if _, changed := table.Report("B", public_2); changed {
+		t.Fatal("consensus without majority")
+	}
+	if consensus, changed := table.Report("C", public_1); !changed || consensus.String() != public_1.String() {
+		t.Fatal("no consensus with quorum and majority", consensus)
+	}
+	//a tie keeps the consensus; each peer has one vote.
+	if _, changed := table.Report("D", public_2); changed {
+		t.Fatal("consensus changed without majority")
+	}
+	if _, changed := table.Report("A", public_1); changed || table.Consensus().String() != public_1.String() {
+		t.Fatal("repeated vote changed the consensus")
+	}
+}
+
+func TestReflexiveAddress(t *testing.T) {
+	A_conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	A_port := A_conn.LocalAddr().(*net.UDPAddr).Port
+	public_ip := net.IPv4(198, 51, 100, 1)
+	A_host, _ := newTestHost(t, A_conn, nil)
+	B_host, _ := newTestHost(t, NewPublicAddressConn(A_port, public_ip), nil)
+	C_host, _ := newTestHost(t, NewPublicAddressConn(A_port, public_ip), nil)
+	for _, host := range []*abyss_host.AbyssHost{A_host, B_host, C_host} {
+		go host.ListenAndServe(context.Background())
+	}
+	hasPublicAddress := func() bool {
+		for _, address := range A_host.NetworkService.LocalAURL().Addresses {
+			if address.IP.Equal(public_ip) && address.Port == A_port {
+				return true
+			}
+		}
+		return false
+	}
+
+	connect := func(observer *abyss_host.AbyssHost) {
+		for _, pair := range [][2]*abyss_host.AbyssHost{{A_host, observer}, {observer, A_host}} {
+			identity := pair[1].NetworkService.LocalIdentity()
+			if err := pair[0].NetworkService.AppendKnownPeer(identity.RootCertificate(), identity.HandshakeKeyCertificate()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		A_host.OpenOutboundConnection(observer.GetLocalAbyssURL())
+		observer.OpenOutboundConnection(A_host.GetLocalAbyssURL())
+	}
+
+	//one observer is not a quorum.
+	connect(B_host)
+	<-time.After(time.Second)
+	if hasPublicAddress() {
+		t.Fatal("public address from a single observer")
+	}
+
+	connect(C_host)
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case event := <-A_host.GetEventChannel():
+			change, ok := event.(abyss.ELocalAURLChange)
+			if !ok {
+				continue
+			}
+			for _, address := range change.LocalAURL.Addresses {
+				if address.IP.Equal(public_ip) && address.Port == A_port {
+					if !hasPublicAddress() {
+						t.Fatal("LocalAURL not updated")
+					}
+					return
+				}
+			}
+			t.Fatal("public address missing in ELocalAURLChange: " + change.LocalAURL.ToString())
+		case <-timeout:
+			t.Fatal("no ELocalAURLChange")
+		}
+	}
+}
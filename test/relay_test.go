@@ -0,0 +1,82 @@
+package test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+
+	"github.com/MinwooWebeng/abyss_core/aurl"
+	abyss_host "github.com/MinwooWebeng/abyss_core/host"
+	abyss_net "github.com/MinwooWebeng/abyss_core/net_service"
+)
+
+func TestRelay(t *testing.T) {
+	R_conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	R_host, _ := newTestHost(t, R_conn, nil)
+	I_host, _ := newTestHost(t, NewNATEmulatedConn(), nil)
+	T_host, _ := newTestHost(t, NewNATEmulatedConn(), &http3.Server{Handler: http.NewServeMux()})
+
+	relay_quota := abyss_net.NewDefaultRelayQuota()
+	R_host.NetworkService.(*abyss_net.BetaNetService).SetRelayQuota(&relay_quota)
+	//T advertises R, and takes the relay notices from it.
+	T_host.NetworkService.(*abyss_net.BetaNetService).SetRelayCandidates([]string{R_host.GetLocalAbyssURL().Hash})
+
+	go R_host.ListenAndServe(context.Background())
+	go I_host.ListenAndServe(context.Background())
+	go T_host.ListenAndServe(context.Background())
+
+	for _, pair := range [][2]*abyss_host.AbyssHost{{R_host, I_host}, {R_host, T_host}, {I_host, R_host}, {T_host, R_host}, {I_host, T_host}} {
+		identity := pair[1].NetworkService.LocalIdentity()
+		if err := pair[0].NetworkService.AppendKnownPeer(identity.RootCertificate(), identity.HandshakeKeyCertificate()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//I and T reach R; neither can reach the other.
+	//(the hosts generate their RSA handshake keys in the background meanwhile, so this may take a while on a busy machine.)
+	I_host.OpenOutboundConnection(R_host.GetLocalAbyssURL())
+	T_host.OpenOutboundConnection(R_host.GetLocalAbyssURL())
+	<-time.After(500 * time.Millisecond)
+	R_host.OpenOutboundConnection(I_host.GetLocalAbyssURL())
+	R_host.OpenOutboundConnection(T_host.GetLocalAbyssURL())
+
+	T_hash := T_host.GetLocalAbyssURL().Hash
+	relayed_url := &aurl.AURL{
+		Scheme: "abyss",
+		Hash:   T_hash,
+		Relays: []string{R_host.GetLocalAbyssURL().Hash},
+	}
+	deadline := time.Now().Add(20 * time.Second)
+	for time.Now().Before(deadline) {
+		I_host.OpenOutboundConnection(relayed_url)
+		<-time.After(time.Second)
+
+		connection, err := I_host.NetworkService.ConnectAbyst(T_hash)
+		if err != nil {
+			continue
+		}
+		defer connection.CloseWithError(0, "")
+		if connection.RemoteAddr().(*net.UDPAddr).Port != R_conn.LocalAddr().(*net.UDPAddr).Port &&
+			!containsPort(T_host.GetLocalAbyssURL().Addresses, connection.RemoteAddr().(*net.UDPAddr).Port) {
+			return //reached T through a relay port of R
+		}
+		t.Fatal("connected without relay: " + connection.RemoteAddr().String())
+	}
+	t.Fatal("I and T did not connect through R")
+}
+
+func containsPort(addresses []*net.UDPAddr, port int) bool {
+	for _, address := range addresses {
+		if address.Port == port {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+package test
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	crypto_rand "crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	abyss_net "github.com/MinwooWebeng/abyss_core/net_service"
+)
+
+func newTestRootKey(t *testing.T, key_type string) abyss_net.PrivateKey {
+	var key any
+	var err error
+	switch key_type {
+	case "ed25519":
+		_, key, err = ed25519.GenerateKey(crypto_rand.Reader)
+	case "P-256":
+		key, err = ecdsa.GenerateKey(elliptic.P256(), crypto_rand.Reader)
+	case "P-384":
+		key, err = ecdsa.GenerateKey(elliptic.P384(), crypto_rand.Reader)
+	case "RSA":
+		key, err = rsa.GenerateKey(crypto_rand.Reader, 2048)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := abyss_net.CheckRootKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestRootKeyTypes(t *testing.T) {
+	key_types := []string{"ed25519", "P-256", "P-384", "RSA"}
+	keys := make(map[string][2]abyss_net.PrivateKey)
+	for _, key_type := range key_types {
+		keys[key_type] = [2]abyss_net.PrivateKey{newTestRootKey(t, key_type), newTestRootKey(t, key_type)}
+	}
+
+	//ID derivation and root signatures agree between the local and the peer view.
+	for _, key_type := range key_types {
+		root_secrets, err := abyss_net.NewRootIdentityWithScheme(keys[key_type][0], abyss_net.HANDSHAKE_KEY_HYBRID)
+		if err != nil {
+			t.Fatal(key_type, err)
+		}
+		peer_identity, err := abyss_net.NewPeerIdentity(mustDecodePem(t, root_secrets.RootCertificate()), mustDecodePem(t, root_secrets.HandshakeKeyCertificate()))
+		if err != nil {
+			t.Fatal(key_type, err)
+		}
+		if peer_identity.IDHash() != root_secrets.IDHash() {
+			t.Fatal(key_type, "ID hash mismatch")
+		}
+		signature, err := root_secrets.Sign([]byte("payload"))
+		if err != nil {
+			t.Fatal(key_type, err)
+		}
+		if err := peer_identity.VerifySignature([]byte("payload"), signature); err != nil {
+			t.Fatal(key_type, err)
+		}
+	}
+
+	//handshakes across key types, in both dial directions.
+	for _, dialer_type := range key_types {
+		for _, accepter_type := range key_types {
+			dialer := newTestNetService(t, keys[dialer_type][0], handshakeSchemeConfig(abyss_net.HANDSHAKE_KEY_HYBRID))
+			accepter := newTestNetService(t, keys[accepter_type][1], handshakeSchemeConfig(abyss_net.HANDSHAKE_KEY_HYBRID))
+			go dialer.ListenAndServe()
+			go accepter.ListenAndServe()
+			dialer.AppendKnownPeer(accepter.LocalIdentity().RootCertificate(), accepter.LocalIdentity().HandshakeKeyCertificate())
+			accepter.AppendKnownPeer(dialer.LocalIdentity().RootCertificate(), dialer.LocalIdentity().HandshakeKeyCertificate())
+
+			dialer.ConnectAbyssAsync(accepter.LocalAURL())
+			accepter.ConnectAbyssAsync(dialer.LocalAURL())
+			waitAbyssPeer(t, dialer)
+			waitAbyssPeer(t, accepter)
+		}
+	}
+}
+
+func TestRootKeyRejected(t *testing.T) {
+	p521_key, _ := ecdsa.GenerateKey(elliptic.P521(), crypto_rand.Reader)
+	rsa_key, _ := rsa.GenerateKey(crypto_rand.Reader, 1024)
+	for _, key := range []any{p521_key, rsa_key, "not a key"} {
+		if _, err := abyss_net.CheckRootKey(key); err == nil {
+			t.Fatal("unsupported root key accepted")
+		}
+	}
+
+	//ed25519 keys by value, or by pointer as ssh parses them, give the same ID.
+	_, ed_key, _ := ed25519.GenerateKey(crypto_rand.Reader)
+	A, err := abyss_net.NewRootIdentity(ed_key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	B, err := abyss_net.NewRootIdentity(&ed_key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if A.IDHash() != B.IDHash() {
+		t.Fatal("ID hash depends on the key representation")
+	}
+}
@@ -0,0 +1,106 @@
+package test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	abyss_host "github.com/MinwooWebeng/abyss_core/host"
+	abyss "github.com/MinwooWebeng/abyss_core/interfaces"
+)
+
+func TestShutdown(t *testing.T) {
+	M_conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	A_conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	M_host, M_pathmap := newTestHost(t, M_conn, nil)
+	A_host, _ := newTestHost(t, A_conn, nil)
+
+	go M_host.ListenAndServe(context.Background())
+	A_served := make(chan bool, 1)
+	go func() {
+		A_host.ListenAndServe(context.Background())
+		A_served <- true
+	}()
+
+	M_world, _ := M_host.OpenWorld("http://m.world.com")
+	M_pathmap.TrySetMapping("/home", M_world.SessionID())
+	world_aurl := M_host.GetLocalAbyssURL()
+	world_aurl.Path = "/home"
+	A_ready := make(chan bool, 1)
+	A_left := make(chan bool, 1)
+	go func() {
+		ev_ch := M_world.GetEventChannel()
+		for {
+			switch event := (<-ev_ch).(type) {
+			case abyss.EWorldMemberRequest:
+				event.Accept()
+			case abyss.EWorldMemberReady:
+				A_ready <- true
+			case abyss.EWorldMemberLeave:
+				if event.PeerHash == A_host.GetLocalAbyssURL().Hash {
+					A_left <- true
+				}
+			}
+		}
+	}()
+
+	for _, pair := range [][2]*abyss_host.AbyssHost{{M_host, A_host}, {A_host, M_host}} {
+		identity := pair[1].NetworkService.LocalIdentity()
+		pair[0].NetworkService.AppendKnownPeer(identity.RootCertificate(), identity.HandshakeKeyCertificate())
+	}
+	A_host.OpenOutboundConnection(M_host.GetLocalAbyssURL())
+	M_host.OpenOutboundConnection(A_host.GetLocalAbyssURL())
+
+	join_ctx, join_ctx_cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer join_ctx_cancel()
+	A_world, err := A_host.JoinWorld(join_ctx, world_aurl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	A_terminated := make(chan bool, 1)
+	go func() {
+		ev_ch := A_world.GetEventChannel()
+		for {
+			switch event := (<-ev_ch).(type) {
+			case abyss.EWorldMemberRequest:
+				event.Accept()
+			case abyss.EWorldTerminate:
+				A_terminated <- true
+				return
+			}
+		}
+	}()
+	select {
+	case <-A_ready:
+	case <-time.After(5 * time.Second):
+		t.Fatal("A did not become a member")
+	}
+
+	shutdown_ctx, shutdown_ctx_cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdown_ctx_cancel()
+	if err := A_host.Shutdown(shutdown_ctx); err != nil {
+		t.Fatal(err)
+	}
+	for name, ch := range map[string]chan bool{"world termination": A_terminated, "ListenAndServe return": A_served, "leave notification": A_left} {
+		select {
+		case <-ch:
+		case <-time.After(5 * time.Second):
+			t.Fatal("no " + name)
+		}
+	}
+
+	//the socket is released.
+	A_address := A_conn.LocalAddr().(*net.UDPAddr)
+	rebound, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero, Port: A_address.Port})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rebound.Close()
+}
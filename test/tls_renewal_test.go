@@ -0,0 +1,73 @@
+package test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	abyss_net "github.com/MinwooWebeng/abyss_core/net_service"
+)
+
+type testClock struct {
+	offset atomic.Int64
+}
+
+func (c *testClock) Now() time.Time {
+	return time.Now().Add(time.Duration(c.offset.Load()))
+}
+func (c *testClock) Advance(d time.Duration) {
+	c.offset.Add(int64(d))
+}
+
+func TestTLSRenewal(t *testing.T) {
+	clock := new(testClock)
+
+	services := make([]*abyss_net.BetaNetService, 3)
+	for i := range services {
+		config := abyss_net.NewDefaultBetaNetServiceConfig()
+		config.TLSRenewal = abyss_net.TLSRenewalConfig{
+			Lifetime:      time.Hour,
+			RenewBefore:   10 * time.Minute,
+			CheckInterval: 10 * time.Millisecond,
+		}
+		config.Clock = clock.Now
+		services[i] = newTestNetService(t, nil, config)
+		go services[i].ListenAndServe()
+	}
+	A, B, C := services[0], services[1], services[2]
+	for _, pair := range [][2]*abyss_net.BetaNetService{{A, B}, {B, A}, {A, C}, {C, A}} {
+		pair[0].AppendKnownPeer(pair[1].LocalIdentity().RootCertificate(), pair[1].LocalIdentity().HandshakeKeyCertificate())
+	}
+
+	A.ConnectAbyssAsync(B.LocalAURL())
+	B.ConnectAbyssAsync(A.LocalAURL())
+	waitAbyssPeer(t, A)
+	waitAbyssPeer(t, B)
+
+	//not yet due.
+	initial_expiry := A.TLSIdentityExpiry()
+	clock.Advance(30 * time.Minute)
+	time.Sleep(100 * time.Millisecond)
+	if !A.TLSIdentityExpiry().Equal(initial_expiry) {
+		t.Fatal("renewed too early")
+	}
+
+	//within RenewBefore of expiry.
+	clock.Advance(25 * time.Minute)
+	deadline := time.Now().Add(5 * time.Second)
+	for !A.TLSIdentityExpiry().After(initial_expiry) {
+		if time.Now().After(deadline) {
+			t.Fatal("TLS identity not renewed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	//the initial certificates have expired. new handshakes use the renewed ones.
+	clock.Advance(10 * time.Minute)
+	if !clock.Now().After(initial_expiry) {
+		t.Fatal("clock not advanced past the initial expiry")
+	}
+	A.ConnectAbyssAsync(C.LocalAURL())
+	C.ConnectAbyssAsync(A.LocalAURL())
+	waitAbyssPeer(t, C)
+}